@@ -0,0 +1,117 @@
+// Copyright (C) 2026 Opsmate, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a
+// copy of this software and associated documentation files (the "Software"),
+// to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense,
+// and/or sell copies of the Software, and to permit persons to whom the
+// Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included
+// in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+// THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+// OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+// ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+// OTHER DEALINGS IN THE SOFTWARE.
+//
+// Except as contained in this notice, the name(s) of the above copyright
+// holders shall not be used in advertising or otherwise to promote the
+// sale, use or other dealings in this Software without prior written
+// authorization.
+
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// acmeChallengePathPrefix is the path under which ACME http-01 challenges
+// are requested (RFC 8555 section 8.3)
+const acmeChallengePathPrefix = "/.well-known/acme-challenge/"
+
+func isBase64URL(str string) bool {
+	for _, c := range str {
+		if !(c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return false
+		}
+	}
+	return str != ""
+}
+
+// parseACMEThumbprint parses the thumbprint of an ACME account key, which is
+// given either as the base64url thumbprint itself or as the JWK
+func parseACMEThumbprint(str string) (string, error) {
+	str = strings.TrimSpace(str)
+	if strings.HasPrefix(str, "{") {
+		return jwkThumbprint([]byte(str))
+	}
+	if digest, err := base64.RawURLEncoding.DecodeString(str); err != nil || len(digest) != sha256.Size {
+		return "", fmt.Errorf("not a base64url SHA-256 thumbprint or a JWK")
+	}
+	return str, nil
+}
+
+// jwkThumbprint returns the base64url SHA-256 thumbprint of a JWK (RFC 7638)
+func jwkThumbprint(jwkJSON []byte) (string, error) {
+	var jwk map[string]any
+	if err := json.Unmarshal(jwkJSON, &jwk); err != nil {
+		return "", fmt.Errorf("invalid JWK: %w", err)
+	}
+	var members []string
+	switch jwk["kty"] {
+	case "EC":
+		members = []string{"crv", "kty", "x", "y"}
+	case "RSA":
+		members = []string{"e", "kty", "n"}
+	case "OKP":
+		members = []string{"crv", "kty", "x"}
+	default:
+		return "", fmt.Errorf("unsupported JWK key type %v", jwk["kty"])
+	}
+	// The members are already in lexicographic order, and json.Marshal
+	// escapes strings the same way as RFC 7638 requires for these values
+	var canonical strings.Builder
+	canonical.WriteByte('{')
+	for i, name := range members {
+		value, ok := jwk[name].(string)
+		if !ok {
+			return "", fmt.Errorf("JWK is missing %q", name)
+		}
+		valueJSON, _ := json.Marshal(value)
+		if i > 0 {
+			canonical.WriteByte(',')
+		}
+		fmt.Fprintf(&canonical, "%q:%s", name, valueJSON)
+	}
+	canonical.WriteByte('}')
+	digest := sha256.Sum256([]byte(canonical.String()))
+	return base64.RawURLEncoding.EncodeToString(digest[:]), nil
+}
+
+// acmeKeyAuthorization returns the key authorization for an http-01
+// challenge request path, or false if path isn't a challenge path
+func acmeKeyAuthorization(path string, thumbprint string) (string, bool) {
+	token, ok := strings.CutPrefix(path, acmeChallengePathPrefix)
+	if !ok || !isBase64URL(token) {
+		return "", false
+	}
+	return token + "." + thumbprint, true
+}
+
+func loadACMEThumbprint(ctx context.Context, testID testID) (string, error) {
+	var thumbprint sql.NullString
+	if err := db.QueryRowContext(ctx, `SELECT acme_thumbprint FROM test WHERE test_id = ?`, testID[:]).Scan(&thumbprint); err != nil {
+		return "", fmt.Errorf("error querying acme_thumbprint: %w", err)
+	}
+	return thumbprint.String, nil
+}
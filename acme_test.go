@@ -0,0 +1,158 @@
+// Copyright (C) 2026 Opsmate, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a
+// copy of this software and associated documentation files (the "Software"),
+// to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense,
+// and/or sell copies of the Software, and to permit persons to whom the
+// Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included
+// in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+// THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+// OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+// ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+// OTHER DEALINGS IN THE SOFTWARE.
+//
+// Except as contained in this notice, the name(s) of the above copyright
+// holders shall not be used in advertising or otherwise to promote the
+// sale, use or other dealings in this Software without prior written
+// authorization.
+
+package main
+
+import (
+	"testing"
+)
+
+// rfc7638Key is the example RSA key from RFC 7638 section 3.1
+const rfc7638Key = `{
+	"kty": "RSA",
+	"n": "0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw",
+	"e": "AQAB",
+	"alg": "RS256",
+	"kid": "2011-04-29"
+}`
+
+func TestJWKThumbprint(t *testing.T) {
+	tests := []struct {
+		name    string
+		jwk     string
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "RFC 7638 RSA",
+			jwk:  rfc7638Key,
+			want: "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs",
+		},
+		{
+			// RFC 7515 appendix A.3 key, thumbprint computed independently
+			name: "EC",
+			jwk:  `{"y":"x_FEzRu9m36HLN_tue659LNpXW6pCyStikYjKIWI5a0","x":"f83OJ3D2xF1Bg8vub9tLe1gHMzV76e8Tus9uPHvRVEU","kty":"EC","crv":"P-256","use":"sig"}`,
+			want: "oKIywvGUpTVTyxMQ3bwIIeQUudfr_CkLMjCE19ECD-U",
+		},
+		{
+			// RFC 8037 appendix A.3
+			name: "OKP",
+			jwk:  `{"kty":"OKP","crv":"Ed25519","x":"11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo"}`,
+			want: "kPrK_qmxVWaYVA9wwBF6Iuo3vVzz7TxHCTwXBygrS4k",
+		},
+		{name: "missing member", jwk: `{"kty":"EC","crv":"P-256","x":"f83OJ3D2xF1Bg8vub9tLe1gHMzV76e8Tus9uPHvRVEU"}`, wantErr: true},
+		{name: "non-string member", jwk: `{"kty":"RSA","n":"AQAB","e":65537}`, wantErr: true},
+		{name: "unsupported key type", jwk: `{"kty":"oct","k":"AQAB"}`, wantErr: true},
+		{name: "missing key type", jwk: `{"n":"AQAB","e":"AQAB"}`, wantErr: true},
+		{name: "not an object", jwk: `["RSA"]`, wantErr: true},
+		{name: "invalid JSON", jwk: `{"kty":"RSA"`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := jwkThumbprint([]byte(tt.jwk))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got %q", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseACMEThumbprint(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    string
+		wantErr bool
+	}{
+		{name: "thumbprint", input: "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs", want: "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs"},
+		{name: "thumbprint with whitespace", input: " NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs\n", want: "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs"},
+		{name: "JWK", input: "\n" + rfc7638Key + "\n", want: "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs"},
+		{name: "invalid JWK", input: `{"kty":"oct"}`, wantErr: true},
+		{name: "padded", input: "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs=", wantErr: true},
+		{name: "standard base64", input: "NzbLsXh8uDCcd+6MNwXF4W/7noWXFZAfHkxZsRGC9Xs", wantErr: true},
+		{name: "too short", input: "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9X", wantErr: true},
+		{name: "too long", input: "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9XsAA", wantErr: true},
+		{name: "empty", input: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseACMEThumbprint(tt.input)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got %q", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestACMEKeyAuthorization(t *testing.T) {
+	const thumbprint = "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs"
+	tests := []struct {
+		path   string
+		want   string
+		wantOK bool
+	}{
+		{path: "/.well-known/acme-challenge/LoqXcYV8q5ONbJQxbmR7SCTNo3tiAXDfowyjxAjEuX0", want: "LoqXcYV8q5ONbJQxbmR7SCTNo3tiAXDfowyjxAjEuX0." + thumbprint, wantOK: true},
+		{path: "/.well-known/acme-challenge/a-b_c", want: "a-b_c." + thumbprint, wantOK: true},
+		{path: "/.well-known/acme-challenge/", wantOK: false},
+		{path: "/.well-known/acme-challenge/token/extra", wantOK: false},
+		{path: "/.well-known/acme-challenge/token=", wantOK: false},
+		{path: "/.well-known/acme-challenge/tok.en", wantOK: false},
+		{path: "/.well-known/acme-challenge", wantOK: false},
+		{path: "/.well-known/pki-validation/token", wantOK: false},
+		{path: "/acme-challenge/token", wantOK: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			got, ok := acmeKeyAuthorization(tt.path, thumbprint)
+			if ok != tt.wantOK {
+				t.Fatalf("got ok=%v, want %v", ok, tt.wantOK)
+			}
+			if got != tt.want {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -71,6 +71,7 @@ async function ctsearch() {
 	tbody.appendChild(make_message_row("Searching..."));
 	let once = false;
 	let after = "";
+	let dns_names = new Set();
 	while (true) {
 		let response;
 		try {
@@ -91,6 +92,7 @@ async function ctsearch() {
 			if (!once) {
 				tbody.appendChild(make_message_row("No certificates found"));
 			}
+			await caa_analysis(Array.from(dns_names));
 			return;
 		}
 		once = true;
@@ -99,6 +101,7 @@ async function ctsearch() {
 			row.appendChild(make_text_cell(issuance.id));
 			row.appendChild(make_text_cell(issuance.issuer.operator ? issuance.issuer.operator.name : "Unknown"));
 			row.appendChild(make_list_cell(issuance.dns_names));
+			issuance.dns_names.forEach(name => dns_names.add(name));
 			row.appendChild(make_text_cell(issuance.revoked === true ? "Revoked" : "Valid"));
 			row.appendChild(make_link_cell(issuance));
 			tbody.appendChild(row);
@@ -106,3 +109,80 @@ async function ctsearch() {
 		}
 	}
 }
+
+async function caa_analysis(dns_names) {
+	function make_message_row(message) {
+		let tr = document.createElement("tr");
+		let td = document.createElement("td");
+		td.colSpan = 4;
+		td.style.textAlign = "center";
+		td.innerText = message;
+		tr.appendChild(td);
+		return tr;
+	}
+	function make_text_cell(message) {
+		let td = document.createElement("td");
+		td.innerText = message;
+		return td;
+	}
+	function make_list(items) {
+		let ul = document.createElement("ul");
+		for (const item of items) {
+			let li = document.createElement("li");
+			li.innerText = item;
+			ul.appendChild(li);
+		}
+		return ul;
+	}
+
+	const tbody = document.getElementById("caa_analysis_results");
+	if (!tbody) {
+		return;
+	}
+	tbody.innerHTML = "";
+	if (dns_names.length == 0) {
+		tbody.appendChild(make_message_row("No certificates found"));
+		return;
+	}
+	const testID = document.body.dataset.testId;
+	const params = new URLSearchParams({"caa_analysis": "1"});
+	for (const name of dns_names) {
+		params.append("dns_name", name);
+	}
+	let response;
+	try {
+		response = await fetch("/test/"+testID+"?"+params.toString());
+	} catch (error) {
+		tbody.appendChild(make_message_row(error.message));
+		return;
+	}
+	if (!response.ok) {
+		tbody.appendChild(make_message_row(await response.text()));
+		return;
+	}
+	const analyses = await response.json();
+	if (analyses.length == 0) {
+		tbody.appendChild(make_message_row("No certificates for this test's domain"));
+		return;
+	}
+	for (const analysis of analyses) {
+		let row = document.createElement("tr");
+		let name = make_text_cell(analysis.dns_name);
+		if (analysis.scope !== null) {
+			let scope = document.createElement("div");
+			scope.innerText = analysis.scope ? "Sources in "+analysis.scope : "Sources outside every scope";
+			name.appendChild(scope);
+		}
+		row.appendChild(name);
+		let queries = document.createElement("td");
+		queries.appendChild(make_list(analysis.queries.map(query => query.received_at+" "+query.fqdn+" from "+query.remote_ip)));
+		row.appendChild(queries);
+		let climbing = make_text_cell(analysis.tree_climbing);
+		if (analysis.missing.length > 0) {
+			climbing.appendChild(make_list(analysis.missing.map(name => "Not queried: "+name)));
+		}
+		row.appendChild(climbing);
+		row.appendChild(make_text_cell(analysis.cname_following));
+		tbody.appendChild(row);
+	}
+}
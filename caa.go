@@ -0,0 +1,204 @@
+// Copyright (C) 2026 Opsmate, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a
+// copy of this software and associated documentation files (the "Software"),
+// to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense,
+// and/or sell copies of the Software, and to permit persons to whom the
+// Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included
+// in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+// THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+// OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+// ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+// OTHER DEALINGS IN THE SOFTWARE.
+//
+// Except as contained in this notice, the name(s) of the above copyright
+// holders shall not be used in advertising or otherwise to promote the
+// sale, use or other dealings in this Software without prior written
+// authorization.
+
+package main
+
+import (
+	"net/netip"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// maxCNAMEChain limits how many CNAMEs are followed when determining
+// which names a CA should have queried
+const maxCNAMEChain = 8
+
+// caaAnalysis describes how a CA looked up CAA records for one DNS name
+// in a certificate (RFC 8659 section 3)
+type caaAnalysis struct {
+	DNSName        string     `json:"dns_name"`
+	Scope          *string    `json:"scope"` // nil if no scoped records affect the lookups
+	Queries        []caaQuery `json:"queries"`
+	Missing        []string   `json:"missing"`
+	TreeClimbing   string     `json:"tree_climbing"`
+	CNAMEFollowing string     `json:"cname_following"`
+}
+
+type caaQuery struct {
+	FQDN       string    `json:"fqdn"`
+	ReceivedAt time.Time `json:"received_at"`
+	RemoteIP   string    `json:"remote_ip"`
+}
+
+const (
+	caaPass          = "Pass"
+	caaFail          = "Fail"
+	caaNotApplicable = "N/A"
+)
+
+// caaScopedTypes are the types of records which, if scoped, can change the
+// lookups a CA must make
+var caaScopedTypes = []uint16{dns.TypeCAA, dns.TypeCNAME, dns.TypeDNAME, dns.TypeNS}
+
+// caaLookup is a name which a CA should query for CAA records
+type caaLookup struct {
+	fqdn    string
+	isAlias bool // true if fqdn is the target of a CNAME
+}
+
+// expectedCAALookups returns the names, in order, which a CA must query to
+// find the relevant CAA RRset for dnsName, stopping at the apex of the test
+// zone (which is the highest name whose queries we can observe).  Aliases are
+// followed when their targets are within the test zone; the search ends at
+// aliases to names outside the test zone.
+func expectedCAALookups(zone *testZone, dnsName string) []caaLookup {
+	var lookups []caaLookup
+	for fqdn := dnsName; ; {
+		lookups = append(lookups, caaLookup{fqdn: fqdn})
+		found := false
+		for target, i := fqdn, 0; i < maxCNAMEChain; i++ {
+			answer := zone.lookup(testSubdomain(zone.apex, target), target, dns.TypeCAA)
+			next := ""
+			for _, rr := range answer.answer {
+				switch rr := rr.(type) {
+				case *dns.CAA:
+					found = true
+				case *dns.CNAME:
+					next = strings.ToLower(rr.Target)
+				}
+			}
+			if next == "" {
+				break
+			} else if !dns.IsSubDomain(zone.apex, next) {
+				// The target's CAA records can't be observed, and
+				// may be the relevant RRset, ending the search
+				found = true
+				break
+			}
+			lookups = append(lookups, caaLookup{fqdn: next, isAlias: true})
+			target = next
+		}
+		if found || fqdn == zone.apex {
+			return lookups
+		}
+		fqdn = fqdn[strings.IndexByte(fqdn, '.')+1:]
+	}
+}
+
+// testSubdomain returns the subdomain of fqdn beneath apex
+func testSubdomain(apex string, fqdn string) string {
+	return strings.TrimSuffix(strings.TrimSuffix(fqdn, apex), ".")
+}
+
+// analyzeCAA compares the CAA queries in dnsItems to the lookups required for
+// dnsName, using the test's current DNS records.  dnsName may be a wildcard,
+// in which case the lookups start at the name without the wildcard label.
+func analyzeCAA(zone *testZone, dnsItems []dnsItem, dnsName string) *caaAnalysis {
+	fqdn := dns.Fqdn(strings.ToLower(strings.TrimPrefix(dnsName, "*.")))
+	if !dns.IsSubDomain(zone.apex, fqdn) {
+		return nil
+	}
+
+	queried := make(map[string]bool)
+	for _, item := range dnsItems {
+		if item.QType == dns.TypeCAA {
+			queried[strings.ToLower(item.FQDN)] = true
+		}
+	}
+
+	analysis := &caaAnalysis{
+		DNSName:        dnsName,
+		Queries:        []caaQuery{},
+		Missing:        []string{},
+		TreeClimbing:   caaPass,
+		CNAMEFollowing: caaNotApplicable,
+	}
+	lookups := expectedCAALookups(zone, fqdn)
+	for _, lookup := range lookups {
+		if lookup.isAlias && analysis.CNAMEFollowing == caaNotApplicable {
+			analysis.CNAMEFollowing = caaPass
+		}
+		if queried[lookup.fqdn] {
+			continue
+		}
+		analysis.Missing = append(analysis.Missing, lookup.fqdn)
+		if lookup.isAlias {
+			analysis.CNAMEFollowing = caaFail
+		} else {
+			analysis.TreeClimbing = caaFail
+		}
+	}
+	for _, item := range dnsItems {
+		if item.QType != dns.TypeCAA {
+			continue
+		}
+		if slices.ContainsFunc(lookups, func(lookup caaLookup) bool { return lookup.fqdn == strings.ToLower(item.FQDN) }) {
+			analysis.Queries = append(analysis.Queries, caaQuery{FQDN: item.FQDN, ReceivedAt: item.ReceivedAt, RemoteIP: item.RemoteIP})
+		}
+	}
+	return analysis
+}
+
+// analyzeCAAByScope analyzes dnsName once for the records visible to each
+// scope which affects CAA lookups, considering only queries from sources in
+// the scope, and once for the unscoped records, considering queries from
+// sources outside every scope
+func analyzeCAAByScope(zone *testZone, dnsItems []dnsItem, dnsName string) []*caaAnalysis {
+	scopes := zone.recordScopes(caaScopedTypes)
+	var analyses []*caaAnalysis
+	for _, scope := range append(scopes, nil) {
+		view := &testZone{apex: zone.apex, running: zone.running, records: slices.Clone(zone.records)}
+		view.restrictToScope(scope)
+		var items []dnsItem
+		for _, item := range dnsItems {
+			addr, _ := netip.ParseAddr(item.RemoteIP)
+			if caaViewContains(scopes, scope, addr.Unmap()) {
+				items = append(items, item)
+			}
+		}
+		analysis := analyzeCAA(view, items, dnsName)
+		if analysis == nil {
+			return nil
+		}
+		if len(scopes) > 0 {
+			scopeString := scope.String()
+			analysis.Scope = &scopeString
+		}
+		analyses = append(analyses, analysis)
+	}
+	return analyses
+}
+
+// caaViewContains reports whether a query from addr is analyzed against the
+// view of scope, which is nil for the view of sources outside every scope
+func caaViewContains(scopes []*recordScope, scope *recordScope, addr netip.Addr) bool {
+	if scope != nil {
+		return scope.Contains(addr)
+	}
+	return !slices.ContainsFunc(scopes, func(scope *recordScope) bool { return scope.Contains(addr) })
+}
@@ -0,0 +1,156 @@
+// Copyright (C) 2026 Opsmate, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a
+// copy of this software and associated documentation files (the "Software"),
+// to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense,
+// and/or sell copies of the Software, and to permit persons to whom the
+// Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included
+// in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+// THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+// OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+// ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+// OTHER DEALINGS IN THE SOFTWARE.
+//
+// Except as contained in this notice, the name(s) of the above copyright
+// holders shall not be used in advertising or otherwise to promote the
+// sale, use or other dealings in this Software without prior written
+// authorization.
+
+package main
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestAnalyzeCAA(t *testing.T) {
+	const apex = "0123456789abcdef0123456789abcdef.test.example.com."
+	zone := &testZone{
+		apex:    apex,
+		running: true,
+		records: []dnsZoneRecord{
+			mustZoneRecord(t, apex, "caa", `CAA 0 issue "ca.example"`, ""),
+			mustZoneRecord(t, apex, "alias", "CNAME caa."+apex, ""),
+			mustZoneRecord(t, apex, "a.alias2", "CNAME target."+apex, ""),
+			mustZoneRecord(t, apex, "external", "CNAME _x.sectigo.com.", ""),
+		},
+	}
+	queries := func(names ...string) []dnsItem {
+		var items []dnsItem
+		for _, name := range names {
+			items = append(items, dnsItem{FQDN: name + apex, QType: dns.TypeCAA})
+		}
+		return items
+	}
+
+	tests := []struct {
+		name           string
+		dnsName        string
+		queries        []dnsItem
+		missing        []string
+		treeClimbing   string
+		cnameFollowing string
+	}{
+		{name: "climbed to apex", dnsName: "a.b." + apex, queries: queries("a.b.", "b.", ""), treeClimbing: caaPass, cnameFollowing: caaNotApplicable},
+		{name: "stopped early", dnsName: "a.b." + apex, queries: queries("a.b."), missing: []string{"b." + apex, apex}, treeClimbing: caaFail, cnameFollowing: caaNotApplicable},
+		{name: "case insensitive", dnsName: "A." + apex, queries: queries("a.", ""), treeClimbing: caaPass, cnameFollowing: caaNotApplicable},
+		{name: "stops at relevant RRset", dnsName: "x.caa." + apex, queries: queries("x.caa.", "caa."), treeClimbing: caaPass, cnameFollowing: caaNotApplicable},
+		{name: "wildcard", dnsName: "*.caa." + apex, queries: queries("caa."), treeClimbing: caaPass, cnameFollowing: caaNotApplicable},
+		{name: "followed CNAME", dnsName: "alias." + apex, queries: queries("alias.", "caa."), treeClimbing: caaPass, cnameFollowing: caaPass},
+		{name: "didn't follow CNAME", dnsName: "alias." + apex, queries: queries("alias.", ""), missing: []string{"caa." + apex}, treeClimbing: caaPass, cnameFollowing: caaFail},
+		{name: "alias without CAA", dnsName: "a.alias2." + apex, queries: queries("a.alias2.", "target.", "alias2.", ""), treeClimbing: caaPass, cnameFollowing: caaPass},
+		{name: "external CNAME", dnsName: "external." + apex, queries: queries("external."), treeClimbing: caaPass, cnameFollowing: caaNotApplicable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			analysis := analyzeCAA(zone, tt.queries, tt.dnsName)
+			if !slices.Equal(analysis.Missing, append([]string{}, tt.missing...)) {
+				t.Errorf("got missing %v, want %v", analysis.Missing, tt.missing)
+			}
+			if analysis.TreeClimbing != tt.treeClimbing {
+				t.Errorf("got tree climbing %s, want %s", analysis.TreeClimbing, tt.treeClimbing)
+			}
+			if analysis.CNAMEFollowing != tt.cnameFollowing {
+				t.Errorf("got CNAME following %s, want %s", analysis.CNAMEFollowing, tt.cnameFollowing)
+			}
+		})
+	}
+
+	if analysis := analyzeCAA(zone, nil, "example.com"); analysis != nil {
+		t.Errorf("got analysis for name outside test zone: %v", analysis)
+	}
+}
+
+func TestAnalyzeCAAByScope(t *testing.T) {
+	const apex = "0123456789abcdef0123456789abcdef.test.example.com."
+	zone := &testZone{
+		apex:    apex,
+		running: true,
+		records: []dnsZoneRecord{
+			mustZoneRecord(t, apex, "caa", `CAA 0 issue "ca.example"`, ""),
+			mustZoneRecord(t, apex, "alias", "CNAME caa."+apex, "198.51.100.0/24"),
+			mustZoneRecord(t, apex, "www", "A 192.0.2.1", "203.0.113.0/24"),
+		},
+	}
+	query := func(name string, remoteIP string) dnsItem {
+		return dnsItem{FQDN: name + apex, QType: dns.TypeCAA, RemoteIP: remoteIP}
+	}
+
+	tests := []struct {
+		name    string
+		queries []dnsItem
+		want    map[string][2]string // scope => tree climbing, CNAME following
+	}{
+		{
+			name:    "each source follows its view",
+			queries: []dnsItem{query("alias.", "198.51.100.7"), query("caa.", "198.51.100.7"), query("alias.", "192.0.2.53"), query("", "192.0.2.53")},
+			want:    map[string][2]string{"198.51.100.0/24": {caaPass, caaPass}, "": {caaPass, caaNotApplicable}},
+		},
+		{
+			name:    "scoped source didn't follow CNAME",
+			queries: []dnsItem{query("alias.", "198.51.100.7"), query("", "198.51.100.7"), query("alias.", "192.0.2.53"), query("", "192.0.2.53")},
+			want:    map[string][2]string{"198.51.100.0/24": {caaPass, caaFail}, "": {caaPass, caaNotApplicable}},
+		},
+		{
+			name:    "queries from scoped source don't count for other sources",
+			queries: []dnsItem{query("alias.", "198.51.100.7"), query("caa.", "198.51.100.7"), query("", "198.51.100.7")},
+			want:    map[string][2]string{"198.51.100.0/24": {caaPass, caaPass}, "": {caaFail, caaNotApplicable}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			analyses := analyzeCAAByScope(zone, tt.queries, "alias."+apex)
+			if len(analyses) != len(tt.want) {
+				t.Fatalf("got %d analyses, want %d", len(analyses), len(tt.want))
+			}
+			for _, analysis := range analyses {
+				if analysis.Scope == nil {
+					t.Fatalf("analysis of test with scoped records has no scope")
+				}
+				want, ok := tt.want[*analysis.Scope]
+				if !ok {
+					t.Fatalf("unexpected analysis for scope %q", *analysis.Scope)
+				}
+				if analysis.TreeClimbing != want[0] || analysis.CNAMEFollowing != want[1] {
+					t.Errorf("scope %q: got tree climbing %s and CNAME following %s, want %s and %s (missing %v)", *analysis.Scope, analysis.TreeClimbing, analysis.CNAMEFollowing, want[0], want[1], analysis.Missing)
+				}
+			}
+		})
+	}
+
+	unscoped := &testZone{apex: apex, running: true, records: []dnsZoneRecord{mustZoneRecord(t, apex, "www", "A 192.0.2.1", "203.0.113.0/24")}}
+	if analyses := analyzeCAAByScope(unscoped, nil, "www."+apex); len(analyses) != 1 || analyses[0].Scope != nil {
+		t.Errorf("scopes of records which don't affect CAA lookups should not split the analysis")
+	}
+}
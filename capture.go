@@ -0,0 +1,271 @@
+// Copyright (C) 2026 Opsmate, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a
+// copy of this software and associated documentation files (the "Software"),
+// to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense,
+// and/or sell copies of the Software, and to permit persons to whom the
+// Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included
+// in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+// THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+// OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+// ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+// OTHER DEALINGS IN THE SOFTWARE.
+//
+// Except as contained in this notice, the name(s) of the above copyright
+// holders shall not be used in advertising or otherwise to promote the
+// sale, use or other dealings in this Software without prior written
+// authorization.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// maxCapturedBytes is the number of bytes recorded from the start of
+	// each connection to a capture port
+	maxCapturedBytes = 8 * 1024
+
+	// captureSniffTimeout is how long to wait for the client to speak
+	// first before assuming that it expects an SMTP greeting
+	captureSniffTimeout = 3 * time.Second
+
+	// captureUnknownTimeout is how long a connection with an unrecognized
+	// protocol is kept open to record more bytes
+	captureUnknownTimeout = 10 * time.Second
+)
+
+const (
+	captureProtocolTLS     = "TLS"
+	captureProtocolHTTP    = "HTTP"
+	captureProtocolSMTP    = "SMTP"
+	captureProtocolUnknown = "unknown"
+)
+
+// parseCaptureListen parses an argument to -capture-listen, which is a
+// comma-separated list of port numbers or go-listener specifications
+func parseCaptureListen(arg string) []string {
+	var specs []string
+	for _, spec := range strings.Split(arg, ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		if _, err := strconv.ParseUint(spec, 10, 16); err == nil {
+			spec = "tcp:" + spec
+		}
+		specs = append(specs, spec)
+	}
+	return specs
+}
+
+// connQueue is a net.Listener which accepts connections handed to it by
+// a capture server once their protocol has been detected
+type connQueue struct {
+	addr  net.Addr
+	conns chan net.Conn
+}
+
+func newConnQueue(addr net.Addr) *connQueue {
+	return &connQueue{addr: addr, conns: make(chan net.Conn)}
+}
+
+func (q *connQueue) Accept() (net.Conn, error) { return <-q.conns, nil }
+func (q *connQueue) Close() error              { return nil }
+func (q *connQueue) Addr() net.Addr            { return q.addr }
+
+// captureConn records the first bytes read from a connection, and the
+// connection's metadata when it is closed
+type captureConn struct {
+	net.Conn
+	acceptedAt    time.Time
+	reader        *bufio.Reader // replays the bytes peeked while detecting the protocol
+	protocol      string
+	mu            sync.Mutex
+	captured      []byte
+	bytesReceived int64
+	closeOnce     sync.Once
+}
+
+func (c *captureConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
+
+func (c *captureConn) Close() error {
+	c.closeOnce.Do(func() {
+		if err := c.record(context.Background()); err != nil {
+			log.Printf("error recording connection to capture port: %s", err)
+		}
+	})
+	return c.Conn.Close()
+}
+
+// recordingReader reads from a connection and keeps the first bytes
+type recordingReader struct {
+	c *captureConn
+}
+
+func (r recordingReader) Read(p []byte) (int, error) {
+	n, err := r.c.Conn.Read(p)
+	r.c.mu.Lock()
+	r.c.bytesReceived += int64(n)
+	if len(r.c.captured) < maxCapturedBytes {
+		r.c.captured = append(r.c.captured, p[:min(n, maxCapturedBytes-len(r.c.captured))]...)
+	}
+	r.c.mu.Unlock()
+	return n, err
+}
+
+// detectCaptureProtocol guesses the protocol of a connection from the
+// first bytes which the client sends, or their absence
+func detectCaptureProtocol(reader *bufio.Reader, conn net.Conn) string {
+	conn.SetReadDeadline(time.Now().Add(captureSniffTimeout))
+	defer conn.SetReadDeadline(time.Time{})
+	first, err := reader.Peek(1)
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		return captureProtocolSMTP
+	} else if err != nil {
+		return captureProtocolUnknown
+	}
+	if first[0] == 0x16 {
+		return captureProtocolTLS
+	}
+	requestLine, _ := reader.Peek(8)
+	if method, _, ok := bytes.Cut(requestLine, []byte(" ")); ok && isHTTPMethod(string(method)) {
+		return captureProtocolHTTP
+	}
+	return captureProtocolUnknown
+}
+
+func isHTTPMethod(token string) bool {
+	switch token {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	return false
+}
+
+// captureServerName returns the name which identifies the test that a
+// captured connection was for: the SNI of a TLS ClientHello, the Host
+// header of an HTTP request, or the domain of an SMTP recipient
+func captureServerName(protocol string, captured []byte) string {
+	switch protocol {
+	case captureProtocolTLS:
+		return clientHelloServerName(captured)
+	case captureProtocolHTTP:
+		if req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(captured))); err == nil {
+			return getHTTPHost(req)
+		}
+	case captureProtocolSMTP:
+		offset := 0
+		for line := range strings.Lines(string(captured)) {
+			offset += len(line)
+			if command, arg, ok := strings.Cut(line, ":"); ok && strings.EqualFold(strings.TrimSpace(command), "RCPT TO") {
+				address := strings.Trim(strings.TrimSpace(arg), "<>")
+				if address, _, _ = strings.Cut(address, ">"); strings.Contains(address, "@") {
+					return strings.ToLower(address[strings.LastIndexByte(address, '@')+1:])
+				}
+			} else if strings.EqualFold(strings.TrimSpace(line), "STARTTLS") {
+				// Recipients are encrypted after STARTTLS, but the
+				// ClientHello which follows it contains the server name
+				return clientHelloServerName(captured[offset:])
+			}
+		}
+	}
+	return ""
+}
+
+func clientHelloServerName(records []byte) string {
+	body, err := reassembleClientHello(records)
+	if err != nil {
+		return ""
+	}
+	hello, err := parseClientHello(body)
+	if err != nil {
+		return ""
+	}
+	return hello.ServerName
+}
+
+func (c *captureConn) record(ctx context.Context) error {
+	c.mu.Lock()
+	captured, bytesReceived := c.captured, c.bytesReceived
+	c.mu.Unlock()
+
+	serverName := captureServerName(c.protocol, captured)
+	testID, _, ok := parseHostname(serverName)
+	if !ok {
+		return nil
+	}
+	if ok, err := isRunningTest(ctx, testID); err != nil {
+		return fmt.Errorf("error checking if %v is a running test: %w", testID, err)
+	} else if !ok {
+		return nil
+	}
+	remoteAddr, err := netip.ParseAddrPort(c.RemoteAddr().String())
+	if err != nil {
+		return fmt.Errorf("error parsing remote address: %w", err)
+	}
+	localAddr, err := netip.ParseAddrPort(c.LocalAddr().String())
+	if err != nil {
+		return fmt.Errorf("error parsing local address: %w", err)
+	}
+	if _, err := db.ExecContext(ctx, `INSERT INTO capture_connection (test_id, received_at, remote_ip, remote_port, local_port, protocol, server_name, first_bytes, bytes_received, duration_ms) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`, testID[:], c.acceptedAt.UTC(), remoteAddr.Addr().String(), remoteAddr.Port(), localAddr.Port(), c.protocol, serverName, captured, bytesReceived, time.Since(c.acceptedAt).Milliseconds()); err != nil {
+		return fmt.Errorf("error inserting capture_connection: %w", err)
+	}
+	return nil
+}
+
+// runCaptureServer accepts connections on a non-standard port, and hands
+// each one to the HTTPS, HTTP, or SMTP server depending on the protocol
+// which the client appears to speak
+func runCaptureServer(l net.Listener) {
+	httpsConns, httpConns, smtpConns := newConnQueue(l.Addr()), newConnQueue(l.Addr()), newConnQueue(l.Addr())
+	go runHTTPSServer(httpsConns)
+	go runHTTPServer(httpConns)
+	go runSMTPServer(smtpConns)
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			log.Fatal(err)
+		}
+		go func() {
+			c := &captureConn{Conn: conn, acceptedAt: time.Now()}
+			c.reader = bufio.NewReader(recordingReader{c})
+			c.protocol = detectCaptureProtocol(c.reader, conn)
+			switch c.protocol {
+			case captureProtocolTLS:
+				httpsConns.conns <- c
+			case captureProtocolHTTP:
+				httpConns.conns <- c
+			case captureProtocolSMTP:
+				smtpConns.conns <- c
+			default:
+				conn.SetReadDeadline(time.Now().Add(captureUnknownTimeout))
+				c.reader.Discard(maxCapturedBytes)
+				c.Close()
+			}
+		}()
+	}
+}
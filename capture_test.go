@@ -0,0 +1,146 @@
+// Copyright (C) 2026 Opsmate, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a
+// copy of this software and associated documentation files (the "Software"),
+// to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense,
+// and/or sell copies of the Software, and to permit persons to whom the
+// Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included
+// in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+// THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+// OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+// ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+// OTHER DEALINGS IN THE SOFTWARE.
+//
+// Except as contained in this notice, the name(s) of the above copyright
+// holders shall not be used in advertising or otherwise to promote the
+// sale, use or other dealings in this Software without prior written
+// authorization.
+
+package main
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"slices"
+	"testing"
+)
+
+func TestParseCaptureListen(t *testing.T) {
+	tests := []struct {
+		arg  string
+		want []string
+	}{
+		{arg: "8443", want: []string{"tcp:8443"}},
+		{arg: "25, 587 ,2525", want: []string{"tcp:25", "tcp:587", "tcp:2525"}},
+		{arg: "tcp:0.0.0.0:8443,8080", want: []string{"tcp:0.0.0.0:8443", "tcp:8080"}},
+		{arg: "tcp:[::1]:8443", want: []string{"tcp:[::1]:8443"}},
+		{arg: "8443,,", want: []string{"tcp:8443"}},
+		{arg: "65536", want: []string{"65536"}},
+		{arg: "", want: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.arg, func(t *testing.T) {
+			if got := parseCaptureListen(tt.arg); !slices.Equal(got, tt.want) {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDetectCaptureProtocol(t *testing.T) {
+	tests := []struct {
+		name  string
+		input []byte
+		close bool // whether the client closes after sending input
+		want  string
+	}{
+		{name: "TLS", input: mustDecodeHex(t, chromeClientHello), want: captureProtocolTLS},
+		{name: "TLS first byte", input: []byte{0x16}, close: true, want: captureProtocolTLS},
+		{name: "HTTP GET", input: []byte("GET / HTTP/1.1\r\nHost: example.com\r\n\r\n"), want: captureProtocolHTTP},
+		{name: "HTTP OPTIONS", input: []byte("OPTIONS * HTTP/1.1\r\n\r\n"), want: captureProtocolHTTP},
+		{name: "HTTP short", input: []byte("GET /"), close: true, want: captureProtocolHTTP},
+		{name: "lower case method", input: []byte("get / HTTP/1.1\r\n\r\n"), want: captureProtocolUnknown},
+		{name: "client speaks SMTP first", input: []byte("EHLO client.example.com\r\n"), want: captureProtocolUnknown},
+		{name: "SSH", input: []byte("SSH-2.0-OpenSSH_9.6\r\n"), want: captureProtocolUnknown},
+		{name: "truncated method", input: []byte("GET"), close: true, want: captureProtocolUnknown},
+		{name: "closed", close: true, want: captureProtocolUnknown},
+		{name: "silent", want: captureProtocolSMTP},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			server, client := net.Pipe()
+			defer server.Close()
+			go func() {
+				client.Write(tt.input)
+				if tt.close {
+					client.Close()
+				}
+			}()
+			defer client.Close()
+
+			reader := bufio.NewReader(server)
+			if got := detectCaptureProtocol(reader, server); got != tt.want {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+			// The peeked bytes must still be available to the server
+			// which the connection is handed to
+			if len(tt.input) > 0 {
+				replayed := make([]byte, len(tt.input))
+				if _, err := io.ReadFull(reader, replayed); err != nil {
+					t.Fatalf("error reading input after detection: %v", err)
+				}
+				if string(replayed) != string(tt.input) {
+					t.Fatalf("got %q after detection, want %q", replayed, tt.input)
+				}
+			}
+		})
+	}
+}
+
+func TestCaptureServerName(t *testing.T) {
+	clientHello := string(mustDecodeHex(t, chromeClientHello))
+	smtpSession := "EHLO client.example.net\r\nMAIL FROM:<sender@example.net>\r\n"
+
+	tests := []struct {
+		name     string
+		protocol string
+		captured string
+		want     string
+	}{
+		{name: "TLS", protocol: captureProtocolTLS, captured: clientHello, want: "www.example.com"},
+		{name: "TLS split records", protocol: captureProtocolTLS, captured: string(splitRecords([]byte(clientHello), 2, 100)), want: "www.example.com"},
+		{name: "TLS truncated", protocol: captureProtocolTLS, captured: clientHello[:100], want: ""},
+		{name: "HTTP", protocol: captureProtocolHTTP, captured: "GET / HTTP/1.1\r\nHost: WWW.Example.com:8080\r\n\r\n", want: "www.example.com"},
+		{name: "HTTP no host", protocol: captureProtocolHTTP, captured: "GET / HTTP/1.0\r\n\r\n", want: ""},
+		{name: "HTTP malformed", protocol: captureProtocolHTTP, captured: "GET /\r\n\r\n", want: ""},
+		{name: "SMTP", protocol: captureProtocolSMTP, captured: smtpSession + "RCPT TO:<user@Example.COM>\r\n", want: "example.com"},
+		{name: "SMTP parameters", protocol: captureProtocolSMTP, captured: smtpSession + "rcpt to: <user@example.com> NOTIFY=NEVER\r\n", want: "example.com"},
+		{name: "SMTP no brackets", protocol: captureProtocolSMTP, captured: smtpSession + "RCPT TO:user@example.com\r\n", want: "example.com"},
+		{name: "SMTP source route", protocol: captureProtocolSMTP, captured: smtpSession + "RCPT TO:<@relay.example.net:user@example.com>\r\n", want: "example.com"},
+		{name: "SMTP first recipient", protocol: captureProtocolSMTP, captured: smtpSession + "RCPT TO:<postmaster>\r\nRCPT TO:<a@example.com>\r\nRCPT TO:<b@example.org>\r\n", want: "example.com"},
+		{name: "SMTP sender only", protocol: captureProtocolSMTP, captured: smtpSession, want: ""},
+		{name: "SMTP STARTTLS", protocol: captureProtocolSMTP, captured: "EHLO client.example.net\r\nSTARTTLS\r\n" + clientHello, want: "www.example.com"},
+		{name: "SMTP lower case STARTTLS", protocol: captureProtocolSMTP, captured: "EHLO client.example.net\r\nstarttls\r\n" + clientHello, want: "www.example.com"},
+		{name: "SMTP STARTTLS without ClientHello", protocol: captureProtocolSMTP, captured: "EHLO client.example.net\r\nSTARTTLS\r\n", want: ""},
+		{name: "unknown", protocol: captureProtocolUnknown, captured: "RCPT TO:<user@example.com>\r\n", want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := captureServerName(tt.protocol, []byte(tt.captured)); got != tt.want {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -49,7 +49,7 @@ func generateRandomSerialNumber() *big.Int {
 	return serialNumber
 }
 
-func getSelfSignedCert(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+func getSelfSignedKey() (crypto.Signer, error) {
 	selfSignedKeyGen.Do(func() {
 		if key, err := rsa.GenerateKey(rand.Reader, 2048); err == nil {
 			selfSignedKey = key
@@ -58,21 +58,40 @@ func getSelfSignedCert(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
 	if selfSignedKey == nil {
 		return nil, fmt.Errorf("generating key failed")
 	}
-	template := &x509.Certificate{
-		DNSNames:     []string{hello.ServerName},
+	return selfSignedKey, nil
+}
+
+// newCertTemplate returns a template for a server certificate which is
+// valid for serverName from 12 hours ago until 12 hours from now
+func newCertTemplate(serverName string) *x509.Certificate {
+	return &x509.Certificate{
+		DNSNames:     []string{serverName},
 		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
 		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
 		NotAfter:     time.Now().Add(12 * time.Hour),
 		NotBefore:    time.Now().Add(-12 * time.Hour),
 		SerialNumber: generateRandomSerialNumber(),
-		Subject:      pkix.Name{CommonName: hello.ServerName},
+		Subject:      pkix.Name{CommonName: serverName},
+	}
+}
+
+func getSelfSignedCert(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return makeSelfSignedCert(hello.ServerName, nil)
+}
+
+func makeSelfSignedCert(serverName string, extensions []pkix.Extension) (*tls.Certificate, error) {
+	key, err := getSelfSignedKey()
+	if err != nil {
+		return nil, err
 	}
-	certBytes, err := x509.CreateCertificate(rand.Reader, template, template, selfSignedKey.Public(), selfSignedKey)
+	template := newCertTemplate(serverName)
+	template.ExtraExtensions = extensions
+	certBytes, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
 	if err != nil {
 		return nil, err
 	}
 	return &tls.Certificate{
 		Certificate: [][]byte{certBytes},
-		PrivateKey:  selfSignedKey,
+		PrivateKey:  key,
 	}, nil
 }
@@ -0,0 +1,479 @@
+// Copyright (C) 2026 Opsmate, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a
+// copy of this software and associated documentation files (the "Software"),
+// to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense,
+// and/or sell copies of the Software, and to permit persons to whom the
+// Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included
+// in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+// THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+// OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+// ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+// OTHER DEALINGS IN THE SOFTWARE.
+//
+// Except as contained in this notice, the name(s) of the above copyright
+// holders shall not be used in advertising or otherwise to promote the
+// sale, use or other dealings in this Software without prior written
+// authorization.
+
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/tls"
+	"database/sql"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"src.agwa.name/go-dbutil"
+)
+
+// maxClientHelloSize bounds the bytes captured from the start of a TLS
+// connection.  It is large enough for a ClientHello with post-quantum key
+// shares spread over several records.
+const maxClientHelloSize = 32 * 1024
+
+// helloCapturingListener wraps the connections accepted by a TCP listener
+// beneath TLS, so that the raw ClientHello can be parsed
+type helloCapturingListener struct {
+	net.Listener
+}
+
+func (l helloCapturingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &helloCapturingConn{Conn: conn}, nil
+}
+
+type helloCapturingConn struct {
+	net.Conn
+	captured      []byte
+	doneCapturing bool
+	clientHelloID atomic.Int64 // 0 if the ClientHello was not recorded
+}
+
+func (c *helloCapturingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if !c.doneCapturing && len(c.captured) < maxClientHelloSize {
+		c.captured = append(c.captured, p[:min(n, maxClientHelloSize-len(c.captured))]...)
+	}
+	return n, err
+}
+
+// takeCaptured stops capturing and returns the bytes read so far.  It must
+// be called from the handshake, which is the only reader at that point.
+func (c *helloCapturingConn) takeCaptured() []byte {
+	captured := c.captured
+	c.captured = nil
+	c.doneCapturing = true
+	return captured
+}
+
+type helloCapturingConnKey struct{}
+
+// withHelloCapturingConn is an http.Server ConnContext function which makes
+// the helloCapturingConn beneath a TLS connection available to handlers
+func withHelloCapturingConn(ctx context.Context, conn net.Conn) context.Context {
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		if captureConn, ok := tlsConn.NetConn().(*helloCapturingConn); ok {
+			return context.WithValue(ctx, helloCapturingConnKey{}, captureConn)
+		}
+	}
+	return ctx
+}
+
+// recordedClientHelloID returns the ID of the tls_client_hello row for conn,
+// or NULL if conn is not a helloCapturingConn or its ClientHello wasn't recorded
+func recordedClientHelloID(conn net.Conn) sql.NullInt64 {
+	if conn, ok := conn.(*helloCapturingConn); ok {
+		if id := conn.clientHelloID.Load(); id != 0 {
+			return sql.NullInt64{Int64: id, Valid: true}
+		}
+	}
+	return sql.NullInt64{}
+}
+
+// requestClientHelloID returns the ID of the tls_client_hello row for the
+// connection that a request arrived on
+func requestClientHelloID(ctx context.Context) sql.NullInt64 {
+	conn, _ := ctx.Value(helloCapturingConnKey{}).(*helloCapturingConn)
+	if conn == nil {
+		return sql.NullInt64{}
+	}
+	return recordedClientHelloID(conn)
+}
+
+// clientHello contains the fields of a TLS ClientHello message, in the
+// order sent by the client and including GREASE values (RFC 8701)
+type clientHello struct {
+	Version           uint16   `json:"version"`
+	CipherSuites      []uint16 `json:"cipher_suites"`
+	Extensions        []uint16 `json:"extensions"`
+	ServerName        string   `json:"server_name"`
+	ALPN              []string `json:"alpn"`
+	SupportedVersions []uint16 `json:"supported_versions"`
+	SupportedGroups   []uint16 `json:"supported_groups"`
+	PointFormats      []uint16 `json:"point_formats"`
+	SignatureSchemes  []uint16 `json:"signature_schemes"`
+	KeyShares         []uint16 `json:"key_shares"`
+}
+
+const (
+	extensionServerName          = 0
+	extensionSupportedGroups     = 10
+	extensionPointFormats        = 11
+	extensionSignatureAlgorithms = 13
+	extensionALPN                = 16
+	extensionSupportedVersions   = 43
+	extensionKeyShare            = 51
+)
+
+var errShortClientHello = errors.New("ClientHello is truncated")
+
+// helloReader reads the length-prefixed fields of a TLS handshake message
+type helloReader []byte
+
+func (r *helloReader) bytes(n int) ([]byte, error) {
+	if len(*r) < n {
+		return nil, errShortClientHello
+	}
+	b := (*r)[:n]
+	*r = (*r)[n:]
+	return b, nil
+}
+
+func (r *helloReader) uint8() (uint8, error) {
+	b, err := r.bytes(1)
+	if err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+func (r *helloReader) uint16() (uint16, error) {
+	b, err := r.bytes(2)
+	if err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint16(b), nil
+}
+
+// vector reads a field prefixed by a lengthSize byte length
+func (r *helloReader) vector(lengthSize int) (helloReader, error) {
+	lengthBytes, err := r.bytes(lengthSize)
+	if err != nil {
+		return nil, err
+	}
+	length := 0
+	for _, b := range lengthBytes {
+		length = length<<8 | int(b)
+	}
+	return r.bytes(length)
+}
+
+func (r *helloReader) uint16s(lengthSize int) ([]uint16, error) {
+	vector, err := r.vector(lengthSize)
+	if err != nil {
+		return nil, err
+	}
+	if len(vector)%2 != 0 {
+		return nil, fmt.Errorf("list has odd length")
+	}
+	values := make([]uint16, 0, len(vector)/2)
+	for len(vector) > 0 {
+		value, _ := vector.uint16()
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+// reassembleClientHello returns the ClientHello handshake message from the
+// TLS records at the start of a connection
+func reassembleClientHello(records []byte) ([]byte, error) {
+	var message []byte
+	r := helloReader(records)
+	for {
+		header, err := r.bytes(3)
+		if err != nil {
+			return nil, err
+		}
+		if header[0] != 22 {
+			return nil, fmt.Errorf("record is not a handshake record")
+		}
+		fragment, err := r.vector(2)
+		if err != nil {
+			return nil, err
+		}
+		message = append(message, fragment...)
+		if len(message) >= 4 {
+			if message[0] != 1 {
+				return nil, fmt.Errorf("handshake message is not a ClientHello")
+			}
+			if length := int(message[1])<<16 | int(message[2])<<8 | int(message[3]); len(message) >= 4+length {
+				return message[4 : 4+length], nil
+			}
+		}
+	}
+}
+
+func parseClientHello(body []byte) (*clientHello, error) {
+	var (
+		hello clientHello
+		err   error
+		r     = helloReader(body)
+	)
+	if hello.Version, err = r.uint16(); err != nil {
+		return nil, err
+	}
+	if _, err := r.bytes(32); err != nil { // random
+		return nil, err
+	}
+	if _, err := r.vector(1); err != nil { // legacy_session_id
+		return nil, err
+	}
+	if hello.CipherSuites, err = r.uint16s(2); err != nil {
+		return nil, err
+	}
+	if _, err := r.vector(1); err != nil { // legacy_compression_methods
+		return nil, err
+	}
+	if len(r) == 0 {
+		return &hello, nil
+	}
+	extensions, err := r.vector(2)
+	if err != nil {
+		return nil, err
+	}
+	for len(extensions) > 0 {
+		extensionType, err := extensions.uint16()
+		if err != nil {
+			return nil, err
+		}
+		data, err := extensions.vector(2)
+		if err != nil {
+			return nil, err
+		}
+		hello.Extensions = append(hello.Extensions, extensionType)
+		if err := hello.parseExtension(extensionType, data); err != nil {
+			return nil, fmt.Errorf("extension %d: %w", extensionType, err)
+		}
+	}
+	return &hello, nil
+}
+
+func (hello *clientHello) parseExtension(extensionType uint16, data helloReader) error {
+	var err error
+	switch extensionType {
+	case extensionServerName:
+		list, err := data.vector(2)
+		if err != nil {
+			return err
+		}
+		for len(list) > 0 {
+			nameType, err := list.uint8()
+			if err != nil {
+				return err
+			}
+			name, err := list.vector(2)
+			if err != nil {
+				return err
+			}
+			if nameType == 0 {
+				hello.ServerName = string(name)
+			}
+		}
+	case extensionALPN:
+		list, err := data.vector(2)
+		if err != nil {
+			return err
+		}
+		for len(list) > 0 {
+			proto, err := list.vector(1)
+			if err != nil {
+				return err
+			}
+			hello.ALPN = append(hello.ALPN, string(proto))
+		}
+	case extensionSupportedVersions:
+		hello.SupportedVersions, err = data.uint16s(1)
+	case extensionSupportedGroups:
+		hello.SupportedGroups, err = data.uint16s(2)
+	case extensionPointFormats:
+		var formats helloReader
+		formats, err = data.vector(1)
+		for _, format := range formats {
+			hello.PointFormats = append(hello.PointFormats, uint16(format))
+		}
+	case extensionSignatureAlgorithms:
+		hello.SignatureSchemes, err = data.uint16s(2)
+	case extensionKeyShare:
+		list, err := data.vector(2)
+		if err != nil {
+			return err
+		}
+		for len(list) > 0 {
+			group, err := list.uint16()
+			if err != nil {
+				return err
+			}
+			if _, err := list.vector(2); err != nil {
+				return err
+			}
+			hello.KeyShares = append(hello.KeyShares, group)
+		}
+	}
+	return err
+}
+
+// isGREASE reports whether value is reserved by RFC 8701 to keep servers
+// tolerant of unknown values
+func isGREASE(value uint16) bool {
+	return value&0x0f0f == 0x0a0a && value>>8 == value&0xff
+}
+
+func withoutGREASE(values []uint16) []uint16 {
+	return slices.DeleteFunc(slices.Clone(values), isGREASE)
+}
+
+func joinDecimal(values []uint16) string {
+	fields := make([]string, len(values))
+	for i, value := range values {
+		fields[i] = strconv.Itoa(int(value))
+	}
+	return strings.Join(fields, "-")
+}
+
+// JA3 returns the JA3 fingerprint string of the ClientHello and its MD5 hash
+func (hello *clientHello) JA3() (string, string) {
+	ja3 := strings.Join([]string{
+		strconv.Itoa(int(hello.Version)),
+		joinDecimal(withoutGREASE(hello.CipherSuites)),
+		joinDecimal(withoutGREASE(hello.Extensions)),
+		joinDecimal(withoutGREASE(hello.SupportedGroups)),
+		joinDecimal(hello.PointFormats),
+	}, ",")
+	hash := md5.Sum([]byte(ja3))
+	return ja3, hex.EncodeToString(hash[:])
+}
+
+// JA4 returns the JA4 fingerprint of the ClientHello.  ClientHellos are only
+// captured from TCP connections, so the protocol is always "t".
+func (hello *clientHello) JA4() string {
+	version := hello.Version
+	if versions := withoutGREASE(hello.SupportedVersions); len(versions) > 0 {
+		version = slices.Max(versions)
+	}
+	versionString := map[uint16]string{
+		tls.VersionTLS13: "13",
+		tls.VersionTLS12: "12",
+		tls.VersionTLS11: "11",
+		tls.VersionTLS10: "10",
+		tls.VersionSSL30: "s3",
+	}[version]
+	if versionString == "" {
+		versionString = "00"
+	}
+	sni := "i"
+	if slices.Contains(hello.Extensions, extensionServerName) {
+		sni = "d"
+	}
+	alpn := "00"
+	if len(hello.ALPN) > 0 && hello.ALPN[0] != "" {
+		first, last := hello.ALPN[0][0], hello.ALPN[0][len(hello.ALPN[0])-1]
+		if isAlphanumeric(first) && isAlphanumeric(last) {
+			alpn = string([]byte{first, last})
+		} else {
+			alpn = hex.EncodeToString([]byte{first})[:1] + hex.EncodeToString([]byte{last})[1:]
+		}
+	}
+	ciphers := withoutGREASE(hello.CipherSuites)
+	extensions := withoutGREASE(hello.Extensions)
+	a := fmt.Sprintf("t%s%s%02d%02d%s", versionString, sni, min(len(ciphers), 99), min(len(extensions), 99), alpn)
+
+	extensions = slices.DeleteFunc(extensions, func(e uint16) bool { return e == extensionServerName || e == extensionALPN })
+	c := joinHex(sorted(extensions))
+	if signatureSchemes := withoutGREASE(hello.SignatureSchemes); len(signatureSchemes) > 0 {
+		c += "_" + joinHex(signatureSchemes)
+	}
+	return a + "_" + truncatedSHA256(joinHex(sorted(ciphers)), len(ciphers) == 0) + "_" + truncatedSHA256(c, len(extensions) == 0)
+}
+
+func isAlphanumeric(b byte) bool {
+	return ('0' <= b && b <= '9') || ('a' <= b && b <= 'z') || ('A' <= b && b <= 'Z')
+}
+
+func sorted(values []uint16) []uint16 {
+	values = slices.Clone(values)
+	slices.Sort(values)
+	return values
+}
+
+func joinHex(values []uint16) string {
+	fields := make([]string, len(values))
+	for i, value := range values {
+		fields[i] = fmt.Sprintf("%04x", value)
+	}
+	return strings.Join(fields, ",")
+}
+
+func truncatedSHA256(str string, empty bool) string {
+	if empty {
+		return "000000000000"
+	}
+	hash := sha256.Sum256([]byte(str))
+	return hex.EncodeToString(hash[:])[:12]
+}
+
+// recordTestClientHello records the ClientHello captured from conn, and the
+// certificate mode it was answered with, logging any errors so that the
+// handshake can proceed
+func recordTestClientHello(ctx context.Context, testID testID, conn *helloCapturingConn, certMode string) {
+	if id, err := recordClientHello(ctx, testID, conn, certMode); err != nil {
+		log.Printf("error recording ClientHello for test %v: %s", testID, err)
+	} else {
+		conn.clientHelloID.Store(id)
+	}
+}
+
+// recordClientHello parses the ClientHello captured from conn and records
+// it for the test, returning the ID of the tls_client_hello row
+func recordClientHello(ctx context.Context, testID testID, conn *helloCapturingConn, certMode string) (int64, error) {
+	remoteAddr, err := netip.ParseAddrPort(conn.RemoteAddr().String())
+	if err != nil {
+		return 0, fmt.Errorf("error parsing remote address: %w", err)
+	}
+	body, err := reassembleClientHello(conn.takeCaptured())
+	if err != nil {
+		return 0, fmt.Errorf("error reassembling ClientHello: %w", err)
+	}
+	hello, err := parseClientHello(body)
+	if err != nil {
+		return 0, fmt.Errorf("error parsing ClientHello: %w", err)
+	}
+	ja3, ja3Hash := hello.JA3()
+	var id int64
+	if err := db.QueryRowContext(ctx, `INSERT INTO tls_client_hello (test_id, remote_ip, remote_port, server_name, hello_json, raw, ja3, ja3_hash, ja4, tls_cert_mode) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?) RETURNING tls_client_hello_id`, testID[:], remoteAddr.Addr().String(), remoteAddr.Port(), hello.ServerName, dbutil.JSON(hello), body, ja3, ja3Hash, hello.JA4(), certMode).Scan(&id); err != nil {
+		return 0, fmt.Errorf("error inserting tls_client_hello: %w", err)
+	}
+	return id, nil
+}
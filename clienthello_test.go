@@ -0,0 +1,232 @@
+// Copyright (C) 2026 Opsmate, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a
+// copy of this software and associated documentation files (the "Software"),
+// to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense,
+// and/or sell copies of the Software, and to permit persons to whom the
+// Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included
+// in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+// THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+// OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+// ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+// OTHER DEALINGS IN THE SOFTWARE.
+//
+// Except as contained in this notice, the name(s) of the above copyright
+// holders shall not be used in advertising or otherwise to promote the
+// sale, use or other dealings in this Software without prior written
+// authorization.
+
+package main
+
+import (
+	"encoding/hex"
+	"slices"
+	"testing"
+)
+
+// chromeClientHello is a TLS record containing a ClientHello shaped like
+// Chrome's, with GREASE values, SNI, and ALPN, whose JA4 fingerprint is the
+// example from the JA4 technical details
+const chromeClientHello = "160301014c010001480303000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f20202122232425262728292a2b2c2d2e2f303132333435363738393a3b3c3d3e3f00200a0a130113021303c02bc02fc02cc030cca9cca8c013c014009c009d002f0035010000df0a0a000000000014001200000f7777772e6578616d706c652e636f6d00170000ff01000100000a000a00081a1a001d00170018000b00020100002300000010000e000c02683208687474702f312e31000500050100000000000d0012001004030804040105030805050108060601001200000033002b00291a1a000100001d00200000000000000000000000000000000000000000000000000000000000000000002d00020101002b0007062a2a03040303001b00030200024469000500030268323a3a000100001500140000000000000000000000000000000000000000"
+
+// ja3ClientHello is a TLS record containing a TLS 1.0 ClientHello with the
+// fields of the example in the JA3 README, plus GREASE values
+const ja3ClientHello = "16030100730100006f0301000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f00001a0a0a002f00350005000ac009c00ac013c01400320038001300040100002c1a1a000000000010000e00000b6578616d706c652e636f6d000a000a00082a2a001700180019000b00020100"
+
+func mustDecodeHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// splitRecords splits the handshake message in a single TLS record into
+// records containing fragments of the given sizes, and one more containing the
+// rest
+func splitRecords(record []byte, sizes ...int) []byte {
+	header, message := record[:3], record[5:]
+	var records []byte
+	for _, size := range append(sizes, len(message)) {
+		size = min(size, len(message))
+		records = append(records, header...)
+		records = append(records, byte(size>>8), byte(size))
+		records = append(records, message[:size]...)
+		message = message[size:]
+	}
+	return records
+}
+
+func TestClientHelloFingerprints(t *testing.T) {
+	tests := []struct {
+		name       string
+		records    []byte
+		serverName string
+		alpn       []string
+		ja3        string
+		ja3Hash    string
+		ja4        string
+	}{
+		{
+			name:       "JA3 example",
+			records:    mustDecodeHex(t, ja3ClientHello),
+			serverName: "example.com",
+			ja3:        "769,47-53-5-10-49161-49162-49171-49172-50-56-19-4,0-10-11,23-24-25,0",
+			ja3Hash:    "ada70206e40642a3e4461f35503241d5",
+		},
+		{
+			name:       "JA4 example",
+			records:    mustDecodeHex(t, chromeClientHello),
+			serverName: "www.example.com",
+			alpn:       []string{"h2", "http/1.1"},
+			ja4:        "t13d1516h2_8daaf6152771_e5627efa2ab1",
+		},
+		{
+			name:       "split across records",
+			records:    splitRecords(mustDecodeHex(t, chromeClientHello), 2, 100),
+			serverName: "www.example.com",
+			alpn:       []string{"h2", "http/1.1"},
+			ja4:        "t13d1516h2_8daaf6152771_e5627efa2ab1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, err := reassembleClientHello(tt.records)
+			if err != nil {
+				t.Fatal(err)
+			}
+			hello, err := parseClientHello(body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if hello.ServerName != tt.serverName {
+				t.Errorf("got server name %q, want %q", hello.ServerName, tt.serverName)
+			}
+			if !slices.Equal(hello.ALPN, tt.alpn) {
+				t.Errorf("got ALPN %q, want %q", hello.ALPN, tt.alpn)
+			}
+			ja3, ja3Hash := hello.JA3()
+			if tt.ja3 != "" && ja3 != tt.ja3 {
+				t.Errorf("got JA3 %s, want %s", ja3, tt.ja3)
+			}
+			if tt.ja3Hash != "" && ja3Hash != tt.ja3Hash {
+				t.Errorf("got JA3 hash %s, want %s", ja3Hash, tt.ja3Hash)
+			}
+			if ja4 := hello.JA4(); tt.ja4 != "" && ja4 != tt.ja4 {
+				t.Errorf("got JA4 %s, want %s", ja4, tt.ja4)
+			}
+		})
+	}
+}
+
+func TestClientHelloGREASE(t *testing.T) {
+	body, err := reassembleClientHello(mustDecodeHex(t, chromeClientHello))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hello, err := parseClientHello(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// GREASE values are recorded as sent, but left out of fingerprints
+	if !isGREASE(hello.CipherSuites[0]) || !isGREASE(hello.Extensions[0]) || !isGREASE(hello.SupportedVersions[0]) {
+		t.Errorf("GREASE values were not recorded: %+v", hello)
+	}
+	for _, value := range []uint16{0x0a0a, 0x1a1a, 0xfafa} {
+		if !isGREASE(value) {
+			t.Errorf("%04x is GREASE", value)
+		}
+	}
+	for _, value := range []uint16{0x0a1a, 0x1301, 0x0a0b, 0x0000} {
+		if isGREASE(value) {
+			t.Errorf("%04x is not GREASE", value)
+		}
+	}
+}
+
+func TestClientHelloTruncated(t *testing.T) {
+	records := mustDecodeHex(t, chromeClientHello)
+	for n := range len(records) {
+		if _, err := reassembleClientHello(records[:n]); err == nil {
+			t.Errorf("reassembling %d of %d bytes succeeded", n, len(records))
+		}
+	}
+	body, err := reassembleClientHello(records)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for n := range len(body) {
+		// A ClientHello may end after the compression methods, since
+		// extensions are optional
+		if _, err := parseClientHello(body[:n]); err == nil && n != 2+32+1+32+2+32+2 {
+			t.Errorf("parsing %d of %d bytes succeeded", n, len(body))
+		}
+	}
+}
+
+func TestClientHelloMalformed(t *testing.T) {
+	records := mustDecodeHex(t, ja3ClientHello)
+	modify := func(offset int, values ...byte) []byte {
+		modified := slices.Clone(records)
+		copy(modified[offset:], values)
+		return modified
+	}
+	const body = 9 // offset of the ClientHello body in the record
+	const ciphers = body + 2 + 32 + 1
+	const extensions = ciphers + 2 + 26 + 2
+
+	tests := []struct {
+		name       string
+		records    []byte
+		reassembly bool // whether reassembly should fail, rather than parsing
+	}{
+		{name: "not a handshake record", records: modify(0, 23), reassembly: true},
+		{name: "not a ClientHello", records: modify(5, 2), reassembly: true},
+		{name: "record longer than data", records: modify(3, 0xff, 0xff), reassembly: true},
+		{name: "session ID longer than body", records: modify(body+2+32, 0xff)},
+		{name: "odd cipher suites length", records: modify(ciphers, 0x00, 0x19)},
+		{name: "cipher suites longer than body", records: modify(ciphers, 0xff, 0xfe)},
+		{name: "extensions longer than body", records: modify(extensions, 0xff, 0xff)},
+		{name: "extension longer than extensions", records: modify(extensions+2+2, 0x00, 0xff)},
+		{name: "server name longer than extension", records: modify(extensions+2+4+4+2+1, 0x00, 0xff)},
+		{name: "odd supported groups length", records: modify(extensions+2+4+20+4, 0x00, 0x07)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, err := reassembleClientHello(tt.records)
+			if tt.reassembly {
+				if err == nil {
+					t.Fatalf("reassembly succeeded")
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("reassembly failed: %v", err)
+			}
+			if hello, err := parseClientHello(body); err == nil {
+				t.Fatalf("parsing succeeded: %+v", hello)
+			}
+		})
+	}
+
+	// No single corrupted byte may cause a panic
+	for i := range records {
+		for _, value := range []byte{0x00, 0x01, 0x7f, 0xff} {
+			if body, err := reassembleClientHello(modify(i, value)); err == nil {
+				if hello, err := parseClientHello(body); err == nil {
+					hello.JA3()
+					hello.JA4()
+				}
+			}
+		}
+	}
+}
@@ -28,20 +28,28 @@ package main
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
 	"database/sql"
+	"database/sql/driver"
 	"embed"
 	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"html/template"
+	"io"
+	"mime"
 	"net"
 	"net/http"
 	"net/url"
 	"runtime/debug"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/miekg/dns"
+	"golang.org/x/net/http/httpguts"
 	"src.agwa.name/go-dbutil"
 )
 
@@ -69,27 +77,43 @@ var homeTemplate = template.Must(template.ParseFS(content, "templates/home.html"
 var testTemplate = template.Must(template.ParseFS(content, "templates/test.html"))
 
 type dashboard struct {
-	Domain    string
-	BuildInfo *debug.BuildInfo
+	Domain         string
+	BuildInfo      *debug.BuildInfo
+	HTTP3Port      int
+	DNSRateLimited bool
 }
 
 func makeDashboard() dashboard {
 	var d dashboard
 	d.Domain = domain
 	d.BuildInfo, _ = debug.ReadBuildInfo()
+	d.HTTP3Port = http3Port
+	d.DNSRateLimited = dnsRateLimiter != nil
 	return d
 }
 
 type testDashboard struct {
 	dashboard
-	TestID     testID
-	StartedAt  time.Time
-	StoppedAt  *time.Time
-	DNS        []dnsItem
-	DNSRecords []dnsRecord
-	HTTP       []httpItem
-	HTTPFiles  []httpFile
-	SMTP       []smtpItem
+	TestID             testID
+	StartedAt          time.Time
+	StoppedAt          *time.Time
+	DNSSECMode         string
+	NegativeTTL        uint32
+	TLSCertMode        string
+	TLSCertPEM         *string
+	ACMEThumbprint     *string
+	DNS                []dnsItem
+	DNSRecords         []dnsRecord
+	DNSFaults          []dnsFault
+	DNSDelays          []dnsDelay
+	DNSSyntheses       []dnsSynthesis
+	HTTP               []httpItem
+	HTTPFiles          []httpFile
+	ClientHellos       []tlsClientHelloItem
+	TLSALPNChallenges  []tlsALPNChallenge
+	TLSALPNValidations []tlsALPNValidationItem
+	SMTP               []smtpItem
+	Captures           []captureItem
 }
 
 func (t *testDashboard) IsRunning() bool {
@@ -98,6 +122,46 @@ func (t *testDashboard) IsRunning() bool {
 func (t *testDashboard) TestDomain() string {
 	return t.TestID.String() + ".test." + domain
 }
+func (t *testDashboard) DNSFaultKinds() []dnsFaultKind {
+	return dnsFaultKinds
+}
+func (t *testDashboard) ClientHello(id *int) *tlsClientHelloItem {
+	for i := range t.ClientHellos {
+		if id != nil && t.ClientHellos[i].TLSClientHelloID == *id {
+			return &t.ClientHellos[i]
+		}
+	}
+	return nil
+}
+func (t *testDashboard) AddressSyntheses() []addressSynthesis {
+	return addressSyntheses
+}
+func (t *testDashboard) DNSSECModes() []dnssecMode {
+	return dnssecModes
+}
+func (t *testDashboard) DNSSECModeDescription() string {
+	for _, mode := range dnssecModes {
+		if mode.Name == t.DNSSECMode {
+			return mode.Description
+		}
+	}
+	return t.DNSSECMode
+}
+func (t *testDashboard) TLSCertModes() []tlsCertMode {
+	return tlsCertModes
+}
+func (t *testDashboard) TLSCertModeDescription() string {
+	return tlsCertModeDescription(t.TLSCertMode)
+}
+func (t *testDashboard) UploadedCertDescription() string {
+	if t.TLSCertPEM == nil {
+		return ""
+	}
+	return describeUploadedCert(*t.TLSCertPEM)
+}
+func (t *testDashboard) DS() string {
+	return makeDS(t.TestDomain() + ".").String()
+}
 
 var dnsRequestTable = dbutil.Table{Name: "dns_request"}
 
@@ -109,6 +173,22 @@ type dnsItem struct {
 	FQDN         string    `sql:"fqdn"`
 	QType        uint16    `sql:"qtype"`
 	Bytes        []byte    `sql:"bytes"`
+	DNSSECMode   *string   `sql:"dnssec_mode"`
+	Fault        *string   `sql:"fault"`
+	Transport    *string   `sql:"transport"`
+	EDNSVersion  *int      `sql:"edns_version"`
+	EDNSUDPSize  *int      `sql:"edns_udp_size"`
+	EDNSDO       *bool     `sql:"edns_do"`
+	Cookie       *string   `sql:"cookie"`
+	ClientSubnet *string   `sql:"client_subnet"`
+	NSID         *bool     `sql:"nsid"`
+	RD           *bool     `sql:"rd"`
+	CD           *bool     `sql:"cd"`
+	Response     []byte    `sql:"response_bytes"`
+	Synthesis    *string   `sql:"synthesis"`
+	DelayMS      *int64    `sql:"delay_ms"`
+	Variant      *string   `sql:"variant"`
+	Referral     *string   `sql:"referral"`
 }
 
 func (i *dnsItem) DelegatedThirdParty() *delegatedThirdParty { return getDNSDelegatedThirdParty(i.RemoteIP) }
@@ -125,9 +205,76 @@ func (i *dnsItem) QTypeString() string {
 	}
 }
 
+func (i *dnsItem) EDNSString() string {
+	if i.EDNSUDPSize == nil {
+		return "None"
+	}
+	fields := []string{fmt.Sprintf("v%d", *i.EDNSVersion), fmt.Sprintf("%d bytes", *i.EDNSUDPSize)}
+	if *i.EDNSDO {
+		fields = append(fields, "DO")
+	}
+	if i.Cookie != nil {
+		fields = append(fields, "Cookie")
+	}
+	if i.ClientSubnet != nil {
+		fields = append(fields, "ECS "+*i.ClientSubnet)
+	}
+	if *i.NSID {
+		fields = append(fields, "NSID")
+	}
+	return strings.Join(fields, ", ")
+}
+
+func (i *dnsItem) Delay() time.Duration {
+	if i.DelayMS == nil {
+		return 0
+	}
+	return time.Duration(*i.DelayMS) * time.Millisecond
+}
+
+func (i *dnsItem) FlagsString() string {
+	var flags []string
+	if i.RD != nil && *i.RD {
+		flags = append(flags, "RD")
+	}
+	if i.CD != nil && *i.CD {
+		flags = append(flags, "CD")
+	}
+	return strings.Join(flags, " ")
+}
+
+// CasePattern shows which letters of the query name are uppercase (X) and
+// lowercase (-), revealing 0x20 case randomization by the resolver
+func (i *dnsItem) CasePattern() string {
+	if i.FQDN == strings.ToLower(i.FQDN) {
+		return ""
+	}
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'A' && r <= 'Z':
+			return 'X'
+		case r >= 'a' && r <= 'z':
+			return '-'
+		default:
+			return r
+		}
+	}, i.FQDN)
+}
+
 func (i *dnsItem) MessageString() string {
+	return unpackDNSMessageString(i.Bytes)
+}
+
+func (i *dnsItem) ResponseString() string {
+	if i.Response == nil {
+		return "No response recorded"
+	}
+	return unpackDNSMessageString(i.Response)
+}
+
+func unpackDNSMessageString(msgBytes []byte) string {
 	msg := new(dns.Msg)
-	if err := msg.Unpack(i.Bytes); err != nil {
+	if err := msg.Unpack(msgBytes); err != nil {
 		return "error unpacking DNS message: " + err.Error()
 	}
 	return msg.String()
@@ -136,10 +283,12 @@ func (i *dnsItem) MessageString() string {
 var dnsRecordTable = dbutil.Table{Name: "dns_record"}
 
 type dnsRecord struct {
-	DNSRecordID int            `sql:"dns_record_id"`
-	Subdomain   string         `sql:"subdomain"`
-	Type        uint16         `sql:"type"`
-	Data        map[string]any `sql:"data_json,json"`
+	DNSRecordID int     `sql:"dns_record_id"`
+	Subdomain   string  `sql:"subdomain"`
+	Type        uint16  `sql:"type"`
+	TTL         uint32  `sql:"ttl"`
+	DataJSON    string  `sql:"data_json"`
+	Scope       *string `sql:"scope"`
 }
 
 func (r *dnsRecord) TypeString() string {
@@ -150,48 +299,463 @@ func (r *dnsRecord) TypeString() string {
 	}
 }
 
+func (r *dnsRecord) DataString() string {
+	rr, err := makeRecordRR(".", r.Type, r.TTL, r.DataJSON)
+	if err != nil {
+		return "error decoding record: " + err.Error()
+	}
+	return rdataString(rr)
+}
+
+var dnsFaultTable = dbutil.Table{Name: "dns_fault"}
+
+type dnsFault struct {
+	DNSFaultID int     `sql:"dns_fault_id"`
+	Subdomain  string  `sql:"subdomain"`
+	QType      *uint16 `sql:"qtype"`
+	Fault      string  `sql:"fault"`
+	MaxCount   *int    `sql:"max_count"`
+	HitCount   int     `sql:"hit_count"`
+}
+
+func (f *dnsFault) QTypeString() string {
+	if f.QType == nil {
+		return "All"
+	} else if str, ok := dns.TypeToString[*f.QType]; ok {
+		return str
+	} else {
+		return fmt.Sprintf("TYPE%d", *f.QType)
+	}
+}
+
+func (f *dnsFault) Description() string {
+	for _, kind := range dnsFaultKinds {
+		if kind.Name == f.Fault {
+			return kind.Description
+		}
+	}
+	return f.Fault
+}
+
+// dnsDelegation summarizes the referrals handed out for a delegated subdomain
+type dnsDelegation struct {
+	Subdomain   string
+	Nameservers []string
+	Referrals   int
+	QueryNames  []string // the distinct names and types which were referred
+}
+
+func (t *testDashboard) DNSDelegations() []*dnsDelegation {
+	var delegations []*dnsDelegation
+	bySubdomain := make(map[string]*dnsDelegation)
+	for i := range t.DNSRecords {
+		record := &t.DNSRecords[i]
+		if record.Type != dns.TypeNS {
+			continue
+		}
+		delegation := bySubdomain[record.Subdomain]
+		if delegation == nil {
+			delegation = &dnsDelegation{Subdomain: record.Subdomain}
+			delegations = append(delegations, delegation)
+			bySubdomain[record.Subdomain] = delegation
+		}
+		if data := record.DataString(); !slices.Contains(delegation.Nameservers, data) {
+			delegation.Nameservers = append(delegation.Nameservers, data)
+		}
+	}
+	for i := range t.DNS {
+		item := &t.DNS[i]
+		if item.Referral == nil {
+			continue
+		}
+		delegation := bySubdomain[testSubdomain(makeHostname(t.TestID, "")+".", *item.Referral)]
+		if delegation == nil {
+			continue
+		}
+		delegation.Referrals++
+		if queryName := strings.ToLower(item.FQDN) + " " + item.QTypeString(); !slices.Contains(delegation.QueryNames, queryName) {
+			delegation.QueryNames = append(delegation.QueryNames, queryName)
+		}
+	}
+	return delegations
+}
+
+var dnsDelayTable = dbutil.Table{Name: "dns_delay"}
+
+type dnsDelay struct {
+	DNSDelayID int     `sql:"dns_delay_id"`
+	Subdomain  string  `sql:"subdomain"`
+	QType      *uint16 `sql:"qtype"`
+	DelayMS    int64   `sql:"delay_ms"`
+	JitterMS   int64   `sql:"jitter_ms"`
+	MaxCount   *int    `sql:"max_count"`
+	HitCount   int     `sql:"hit_count"`
+}
+
+func (d *dnsDelay) QTypeString() string {
+	if d.QType == nil {
+		return "All"
+	} else if str, ok := dns.TypeToString[*d.QType]; ok {
+		return str
+	} else {
+		return fmt.Sprintf("TYPE%d", *d.QType)
+	}
+}
+
+func (d *dnsDelay) Description() string {
+	description := (time.Duration(d.DelayMS) * time.Millisecond).String()
+	if d.JitterMS > 0 {
+		description += " + up to " + (time.Duration(d.JitterMS) * time.Millisecond).String() + " jitter"
+	}
+	return description
+}
+
+// dnsRetryGrace accounts for the one second resolution of received_at when
+// deciding whether a query arrived while an earlier one was being delayed
+const dnsRetryGrace = 2 * time.Second
+
+type dnsRetryAttempt struct {
+	*dnsItem
+	Offset time.Duration
+}
+
+// dnsRetryGroup is a delayed query along with the queries for the same name
+// and type which arrived before its response was sent, which are presumably
+// retries by a resolver that timed out
+type dnsRetryGroup struct {
+	FQDN     string
+	QType    string
+	Attempts []dnsRetryAttempt
+	deadline time.Time
+}
+
+func (t *testDashboard) DNSRetryGroups() []*dnsRetryGroup {
+	var groups []*dnsRetryGroup
+	open := make(map[string]*dnsRetryGroup)
+	for i := range t.DNS {
+		item := &t.DNS[i]
+		key := strings.ToLower(item.FQDN) + "/" + item.QTypeString()
+		group := open[key]
+		if group == nil || item.ReceivedAt.After(group.deadline) {
+			if item.DelayMS == nil {
+				continue
+			}
+			group = &dnsRetryGroup{FQDN: strings.ToLower(item.FQDN), QType: item.QTypeString()}
+			groups = append(groups, group)
+			open[key] = group
+		}
+		var offset time.Duration
+		if len(group.Attempts) > 0 {
+			offset = item.ReceivedAt.Sub(group.Attempts[0].ReceivedAt)
+		}
+		group.Attempts = append(group.Attempts, dnsRetryAttempt{dnsItem: item, Offset: offset})
+		if deadline := item.ReceivedAt.Add(item.Delay() + dnsRetryGrace); deadline.After(group.deadline) {
+			group.deadline = deadline
+		}
+	}
+	return groups
+}
+
 var httpRequestTable = dbutil.Table{Name: "http_request"}
 
 type httpItem struct {
-	HTTPRequestID int                 `sql:"http_request_id"`
-	ReceivedAt    time.Time           `sql:"received_at"`
-	RemoteIP      string              `sql:"remote_ip"`
-	RemotePort    string              `sql:"remote_port"`
-	Host          string              `sql:"host"`
-	Method        string              `sql:"method"`
-	URL           string              `sql:"url"`
-	Proto         string              `sql:"proto"`
-	Header        map[string][]string `sql:"header_json,json"`
-	HTTPS         bool                `sql:"https"`
+	HTTPRequestID  int                 `sql:"http_request_id"`
+	ReceivedAt     time.Time           `sql:"received_at"`
+	RemoteIP       string              `sql:"remote_ip"`
+	RemotePort     string              `sql:"remote_port"`
+	Host           string              `sql:"host"`
+	Method         string              `sql:"method"`
+	URL            string              `sql:"url"`
+	Proto          string              `sql:"proto"`
+	Header         map[string][]string `sql:"header_json,json"`
+	HTTPS          bool                `sql:"https"`
+	ResponseStatus *int                `sql:"response_status"`
+	ResponseHeader map[string][]string `sql:"response_header_json,json"`
+	ResponseBody   []byte              `sql:"response_body"`
+	AddressFamily  *string             `sql:"address_family"`
+	Synthesis      *string             `sql:"synthesis"`
+	RequestURI     *string             `sql:"request_uri"`
+	HostHeader     *string             `sql:"host_header"`
+	Body           []byte              `sql:"body"`
+	BodyTruncated  *bool               `sql:"body_truncated"`
+	Trailer        map[string][]string `sql:"trailer_json,json"`
+	ClientHelloID  *int                `sql:"tls_client_hello_id"`
+	AutoAnswered   *bool               `sql:"acme_auto_answered"`
+	QUICVersion    *string             `sql:"quic_version"`
+	QUICUsed0RTT   *bool               `sql:"quic_used_0rtt"`
+	QUICDatagrams  *bool               `sql:"quic_datagrams"`
 }
 
 func (i *httpItem) IsHTTPS() string { return boolString(i.HTTPS) }
 
+func (i *httpItem) IsAutoAnswered() bool { return i.AutoAnswered != nil && *i.AutoAnswered }
+
+// QUICString describes the QUIC connection which carried an HTTP/3 request,
+// or returns the empty string if the request was made over TCP
+func (i *httpItem) QUICString() string {
+	if i.QUICVersion == nil {
+		return ""
+	}
+	str := "QUIC " + *i.QUICVersion
+	if i.QUICUsed0RTT != nil && *i.QUICUsed0RTT {
+		str += ", 0-RTT"
+	}
+	if i.QUICDatagrams != nil && *i.QUICDatagrams {
+		str += ", datagrams"
+	}
+	return str
+}
+
+// AbsoluteURL returns the URL that was requested, including the scheme and host
+func (i *httpItem) AbsoluteURL() *url.URL {
+	u, err := url.Parse(i.URL)
+	if err != nil {
+		return &url.URL{}
+	}
+	if u.Host == "" {
+		u.Scheme = "http"
+		if i.HTTPS {
+			u.Scheme = "https"
+		}
+		u.Host = i.Host
+	}
+	return u
+}
+
+func (i *httpItem) NormalizedURL() string { return normalizeHTTPURL(i.AbsoluteURL()) }
+
+// RedirectTarget returns the URL which the response redirected to, or nil
+// if it wasn't a redirect
+func (i *httpItem) RedirectTarget() *url.URL {
+	if i.ResponseStatus == nil || *i.ResponseStatus < 300 || *i.ResponseStatus > 399 {
+		return nil
+	}
+	location := http.Header(i.ResponseHeader).Get("Location")
+	if location == "" {
+		return nil
+	}
+	target, err := i.AbsoluteURL().Parse(location)
+	if err != nil {
+		return nil
+	}
+	return target
+}
+
 func (i *httpItem) AutonomousSystems() []autonomousSystem { return getAutonomousSystems(i.RemoteIP) }
 
 func (i *httpItem) RemoteAddr() string { return net.JoinHostPort(i.RemoteIP, i.RemotePort) }
 
-func (i *httpItem) HeaderString() string {
+// RequestLine returns the request line with the request target as sent by
+// the client, which may be in absolute-form
+func (i *httpItem) RequestLine() string {
+	target := i.URL
+	if i.RequestURI != nil {
+		target = *i.RequestURI
+	}
+	return i.Method + " " + target + " " + i.Proto
+}
+
+func (i *httpItem) RequestString() string {
 	var buf strings.Builder
+	buf.WriteString(i.RequestLine() + "\r\n")
+	if i.HostHeader != nil {
+		fmt.Fprintf(&buf, "Host: %s\r\n", *i.HostHeader)
+	}
 	if err := http.Header(i.Header).Write(&buf); err != nil {
 		return "error writing HTTP header: " + err.Error()
 	}
+	buf.WriteString("\r\n")
+	buf.Write(i.Body)
+	if i.BodyTruncated != nil && *i.BodyTruncated {
+		fmt.Fprintf(&buf, "\n[body truncated after %d bytes]", len(i.Body))
+	}
+	if len(i.Trailer) > 0 {
+		buf.WriteString("\r\n\r\n")
+		if err := http.Header(i.Trailer).Write(&buf); err != nil {
+			return "error writing HTTP trailer: " + err.Error()
+		}
+	}
+	return buf.String()
+}
+
+func (i *httpItem) ResponseString() string {
+	if i.ResponseStatus == nil {
+		return "Response not recorded"
+	}
+	var buf strings.Builder
+	fmt.Fprintf(&buf, "%d %s\r\n", *i.ResponseStatus, http.StatusText(*i.ResponseStatus))
+	if err := http.Header(i.ResponseHeader).Write(&buf); err != nil {
+		return "error writing HTTP header: " + err.Error()
+	}
+	buf.WriteString("\r\n")
+	buf.Write(i.ResponseBody)
 	return buf.String()
 }
 
 func (i *httpItem) IsDCV() bool {
-	urlLower := strings.ToLower(i.URL)
+	path := i.URL
+	if u, err := url.Parse(i.URL); err == nil && u.IsAbs() {
+		path = u.Path
+	}
+	urlLower := strings.ToLower(path)
 	return strings.HasPrefix(urlLower, "/.well-known/pki-validation") || strings.HasPrefix(urlLower, "/.well-known/acme-challenge")
 }
 
+// httpRedirectChain is a sequence of HTTP requests in which each request
+// followed the redirect returned in response to the previous one
+type httpRedirectChain struct {
+	Requests   []*httpItem
+	Unfollowed string // the final redirect target, if it wasn't followed to this server
+}
+
+// httpRedirectFollowTimeout is how soon after a redirect a request for the
+// redirect target must arrive to be considered part of the chain
+const httpRedirectFollowTimeout = 30 * time.Second
+
+func (t *testDashboard) HTTPRedirectChains() []*httpRedirectChain {
+	var chains []*httpRedirectChain
+	pending := make(map[string]*httpRedirectChain) // keyed by normalized redirect target
+	for i := range t.HTTP {
+		item := &t.HTTP[i]
+		chain := pending[item.NormalizedURL()]
+		if chain != nil && item.ReceivedAt.Sub(chain.Requests[len(chain.Requests)-1].ReceivedAt) <= httpRedirectFollowTimeout {
+			delete(pending, item.NormalizedURL())
+			chain.Requests = append(chain.Requests, item)
+			chain.Unfollowed = ""
+		} else if item.RedirectTarget() != nil {
+			chain = &httpRedirectChain{Requests: []*httpItem{item}}
+			chains = append(chains, chain)
+		} else {
+			continue
+		}
+		if target := item.RedirectTarget(); target != nil {
+			chain.Unfollowed = target.String()
+			pending[normalizeHTTPURL(target)] = chain
+		}
+	}
+	return chains
+}
+
 var httpFileTable = dbutil.Table{Name: "http_file"}
 
 type httpFile struct {
-	HTTPFileID int    `sql:"http_file_id"`
-	Scheme     string `sql:"scheme"`
-	Subdomain  string `sql:"subdomain"`
-	Path       string `sql:"path"`
-	Content    string `sql:"content"`
+	HTTPFileID  int                 `sql:"http_file_id"`
+	Scheme      string              `sql:"scheme"`
+	Subdomain   string              `sql:"subdomain"`
+	Path        string              `sql:"path"`
+	Match       string              `sql:"path_match"`
+	Content     string              `sql:"content"`
+	Status      int                 `sql:"status"`
+	ContentType *string             `sql:"content_type"`
+	Header      map[string][]string `sql:"header_json,json"`
+	Location    *string             `sql:"location"`
+}
+
+func (f *httpFile) StatusString() string {
+	return strconv.Itoa(f.Status) + " " + http.StatusText(f.Status)
+}
+
+func (f *httpFile) HeaderString() string {
+	var buf strings.Builder
+	http.Header(f.Header).Write(&buf)
+	return buf.String()
+}
+
+var tlsClientHelloTable = dbutil.Table{Name: "tls_client_hello"}
+
+type tlsClientHelloItem struct {
+	TLSClientHelloID int         `sql:"tls_client_hello_id"`
+	ReceivedAt       time.Time   `sql:"received_at"`
+	RemoteIP         string      `sql:"remote_ip"`
+	RemotePort       string      `sql:"remote_port"`
+	ServerName       string      `sql:"server_name"`
+	Hello            clientHello `sql:"hello_json,json"`
+	JA3              string      `sql:"ja3"`
+	JA3Hash          string      `sql:"ja3_hash"`
+	JA4              string      `sql:"ja4"`
+	TLSCertMode      *string     `sql:"tls_cert_mode"`
+}
+
+func (i *tlsClientHelloItem) TLSCertModeDescription() string {
+	if i.TLSCertMode == nil {
+		return ""
+	}
+	return tlsCertModeDescription(*i.TLSCertMode)
+}
+
+func (i *tlsClientHelloItem) AutonomousSystems() []autonomousSystem {
+	return getAutonomousSystems(i.RemoteIP)
+}
+
+func (i *tlsClientHelloItem) RemoteAddr() string { return net.JoinHostPort(i.RemoteIP, i.RemotePort) }
+
+func (i *tlsClientHelloItem) ALPNString() string { return strings.Join(i.Hello.ALPN, ", ") }
+
+// VersionNames returns the versions offered in the supported_versions
+// extension, or the legacy version if the extension is absent
+func (i *tlsClientHelloItem) VersionNames() []string {
+	if len(i.Hello.SupportedVersions) == 0 {
+		return []string{tls.VersionName(i.Hello.Version)}
+	}
+	return codepointNames(i.Hello.SupportedVersions, tls.VersionName)
+}
+
+func (i *tlsClientHelloItem) CipherSuiteNames() []string {
+	return codepointNames(i.Hello.CipherSuites, tls.CipherSuiteName)
+}
+
+func (i *tlsClientHelloItem) GroupNames() []string {
+	return codepointNames(i.Hello.SupportedGroups, curveName)
+}
+
+func (i *tlsClientHelloItem) KeyShareNames() []string {
+	return codepointNames(i.Hello.KeyShares, curveName)
+}
+
+func (i *tlsClientHelloItem) SignatureSchemeNames() []string {
+	return codepointNames(i.Hello.SignatureSchemes, func(v uint16) string { return tls.SignatureScheme(v).String() })
+}
+
+func (i *tlsClientHelloItem) ExtensionsString() string {
+	return joinDecimal(i.Hello.Extensions)
+}
+
+func curveName(v uint16) string { return tls.CurveID(v).String() }
+
+func codepointNames(values []uint16, name func(uint16) string) []string {
+	names := make([]string, len(values))
+	for i, value := range values {
+		if isGREASE(value) {
+			names[i] = fmt.Sprintf("GREASE (0x%04X)", value)
+		} else {
+			names[i] = name(value)
+		}
+	}
+	return names
+}
+
+var tlsALPNValidationTable = dbutil.Table{Name: "tls_alpn_validation"}
+
+type tlsALPNValidationItem struct {
+	TLSALPNValidationID    int       `sql:"tls_alpn_validation_id"`
+	ReceivedAt             time.Time `sql:"received_at"`
+	RemoteIP               string    `sql:"remote_ip"`
+	RemotePort             string    `sql:"remote_port"`
+	ServerName             string    `sql:"server_name"`
+	KeyAuthorizationDigest []byte    `sql:"key_authorization_digest"`
+	ClientHelloID          *int      `sql:"tls_client_hello_id"`
+}
+
+func (i *tlsALPNValidationItem) AutonomousSystems() []autonomousSystem {
+	return getAutonomousSystems(i.RemoteIP)
+}
+
+func (i *tlsALPNValidationItem) RemoteAddr() string {
+	return net.JoinHostPort(i.RemoteIP, i.RemotePort)
+}
+
+func (i *tlsALPNValidationItem) DigestString() string {
+	return base64.RawURLEncoding.EncodeToString(i.KeyAuthorizationDigest)
 }
 
 var smtpRequestTable = dbutil.Table{Name: "smtp_request"}
@@ -224,9 +788,34 @@ func (i *smtpItem) MessageHeader() string {
 	}
 }
 
+var captureConnectionTable = dbutil.Table{Name: "capture_connection"}
+
+type captureItem struct {
+	CaptureConnectionID int       `sql:"capture_connection_id"`
+	ReceivedAt          time.Time `sql:"received_at"`
+	RemoteIP            string    `sql:"remote_ip"`
+	RemotePort          string    `sql:"remote_port"`
+	LocalPort           int       `sql:"local_port"`
+	Protocol            string    `sql:"protocol"`
+	ServerName          string    `sql:"server_name"`
+	FirstBytes          []byte    `sql:"first_bytes"`
+	BytesReceived       int64     `sql:"bytes_received"`
+	DurationMS          int64     `sql:"duration_ms"`
+}
+
+func (i *captureItem) AutonomousSystems() []autonomousSystem { return getAutonomousSystems(i.RemoteIP) }
+
+func (i *captureItem) RemoteAddr() string { return net.JoinHostPort(i.RemoteIP, i.RemotePort) }
+
+func (i *captureItem) Duration() time.Duration {
+	return time.Duration(i.DurationMS) * time.Millisecond
+}
+
+func (i *captureItem) FirstBytesDump() string { return hex.Dump(i.FirstBytes) }
+
 func loadTestDashboard(ctx context.Context, testID testID) (*testDashboard, error) {
 	dashboard := &testDashboard{dashboard: makeDashboard(), TestID: testID}
-	if err := db.QueryRowContext(ctx, `SELECT started_at, stopped_at FROM test WHERE test_id = ?`, testID[:]).Scan(&dashboard.StartedAt, &dashboard.StoppedAt); err == sql.ErrNoRows {
+	if err := db.QueryRowContext(ctx, `SELECT started_at, stopped_at, dnssec_mode, negative_ttl, tls_cert_mode, tls_cert_pem, acme_thumbprint FROM test WHERE test_id = ?`, testID[:]).Scan(&dashboard.StartedAt, &dashboard.StoppedAt, &dashboard.DNSSECMode, &dashboard.NegativeTTL, &dashboard.TLSCertMode, &dashboard.TLSCertPEM, &dashboard.ACMEThumbprint); err == sql.ErrNoRows {
 		return nil, nil
 	} else if err != nil {
 		return nil, fmt.Errorf("error querying test table: %w", err)
@@ -237,15 +826,36 @@ func loadTestDashboard(ctx context.Context, testID testID) (*testDashboard, erro
 	if err := dbutil.QueryStructs(ctx, db, dnsRecordTable, &dashboard.DNSRecords, `WHERE test_id = ? ORDER BY subdomain, dns_record_id`, testID[:]); err != nil {
 		return nil, fmt.Errorf("error querying dns_record table: %w", err)
 	}
+	if err := dbutil.QueryStructs(ctx, db, dnsFaultTable, &dashboard.DNSFaults, `WHERE test_id = ? ORDER BY dns_fault_id`, testID[:]); err != nil {
+		return nil, fmt.Errorf("error querying dns_fault table: %w", err)
+	}
+	if err := dbutil.QueryStructs(ctx, db, dnsDelayTable, &dashboard.DNSDelays, `WHERE test_id = ? ORDER BY dns_delay_id`, testID[:]); err != nil {
+		return nil, fmt.Errorf("error querying dns_delay table: %w", err)
+	}
+	if err := dbutil.QueryStructs(ctx, db, dnsSynthesisTable, &dashboard.DNSSyntheses, `WHERE test_id = ? ORDER BY subdomain`, testID[:]); err != nil {
+		return nil, fmt.Errorf("error querying dns_synthesis table: %w", err)
+	}
 	if err := dbutil.QueryStructs(ctx, db, httpRequestTable, &dashboard.HTTP, `WHERE test_id = ? ORDER BY received_at, http_request_id`, testID[:]); err != nil {
 		return nil, fmt.Errorf("error querying http_request table: %w", err)
 	}
 	if err := dbutil.QueryStructs(ctx, db, httpFileTable, &dashboard.HTTPFiles, `WHERE test_id = ? ORDER BY scheme, subdomain, path`, testID[:]); err != nil {
 		return nil, fmt.Errorf("error querying http_file table: %w", err)
 	}
+	if err := dbutil.QueryStructs(ctx, db, tlsClientHelloTable, &dashboard.ClientHellos, `WHERE test_id = ? ORDER BY received_at, tls_client_hello_id`, testID[:]); err != nil {
+		return nil, fmt.Errorf("error querying tls_client_hello table: %w", err)
+	}
+	if err := dbutil.QueryStructs(ctx, db, tlsALPNChallengeTable, &dashboard.TLSALPNChallenges, `WHERE test_id = ? ORDER BY subdomain`, testID[:]); err != nil {
+		return nil, fmt.Errorf("error querying tls_alpn_challenge table: %w", err)
+	}
+	if err := dbutil.QueryStructs(ctx, db, tlsALPNValidationTable, &dashboard.TLSALPNValidations, `WHERE test_id = ? ORDER BY received_at, tls_alpn_validation_id`, testID[:]); err != nil {
+		return nil, fmt.Errorf("error querying tls_alpn_validation table: %w", err)
+	}
 	if err := dbutil.QueryStructs(ctx, db, smtpRequestTable, &dashboard.SMTP, `WHERE test_id = ? ORDER BY received_at, smtp_request_id`, testID[:]); err != nil {
 		return nil, fmt.Errorf("error querying smtp_request table: %w", err)
 	}
+	if err := dbutil.QueryStructs(ctx, db, captureConnectionTable, &dashboard.Captures, `WHERE test_id = ? ORDER BY received_at, capture_connection_id`, testID[:]); err != nil {
+		return nil, fmt.Errorf("error querying capture_connection table: %w", err)
+	}
 	return dashboard, nil
 }
 
@@ -281,6 +891,9 @@ func serveDashboard(ctx context.Context, w http.ResponseWriter, r *http.Request)
 		return serveTest(ctx, w, r, testID)
 	} else if r.URL.Path == "/view_issuance" {
 		return serveViewIssuance(ctx, w, r)
+	} else if r.URL.Path == dohPath {
+		serveDoH(w, r)
+		return nil
 	} else {
 		http.Error(w, "Unrecognized path", 400)
 		return nil
@@ -334,39 +947,246 @@ func normalizeAndValidateCNAMETarget(target string) (string, error) {
 	return "", fmt.Errorf("target is not beneath a domain name known to be used by CAs for CNAME targets (please open an issue on GitHub if we're missing a CA domain)")
 }
 
-func decodePostedDNSRecord(r *http.Request) (string, uint16, map[string]any, error) {
+// parseTTL parses a TTL, which must fit in 31 bits (RFC 2181 section 8)
+func parseTTL(str string) (uint32, error) {
+	ttl, err := strconv.ParseUint(strings.TrimSpace(str), 10, 31)
+	if err != nil {
+		return 0, err
+	}
+	return uint32(ttl), nil
+}
+
+// maxCAAAnalysisNames limits the number of DNS names analyzed in one request
+const maxCAAAnalysisNames = 1000
+
+// maxTXTLength limits the total size of the strings in a posted TXT record
+const maxTXTLength = 4096
+
+// splitTXT splits a TXT value into character-strings of at most 255 bytes
+func splitTXT(txt string) []string {
+	strs := []string{}
+	for len(txt) > 255 {
+		strs = append(strs, txt[:255])
+		txt = txt[255:]
+	}
+	return append(strs, txt)
+}
+
+// normalizeAndValidateAliasTarget validates the target of a CNAME or DNAME
+// record, which may also be within the test's own zone
+func normalizeAndValidateAliasTarget(testID testID, target string) (string, error) {
+	if normalized := dns.Fqdn(strings.ToLower(strings.TrimSpace(target))); dns.IsSubDomain(makeHostname(testID, "")+".", normalized) {
+		return normalized, nil
+	}
+	return normalizeAndValidateCNAMETarget(target)
+}
+
+func validatePostedRR(testID testID, subdomain string, rr dns.RR) error {
+	switch rr.Header().Rrtype {
+	case dns.TypeSOA, dns.TypeRRSIG, dns.TypeNSEC, dns.TypeNSEC3, dns.TypeNSEC3PARAM, dns.TypeDNSKEY, dns.TypeNXNAME:
+		return fmt.Errorf("%s records are generated by the server", dns.Type(rr.Header().Rrtype))
+	case dns.TypeOPT, dns.TypeTSIG, dns.TypeTKEY, dns.TypeIXFR, dns.TypeAXFR, dns.TypeMAILA, dns.TypeMAILB, dns.TypeANY:
+		return fmt.Errorf("%s is not a record type which can be published", dns.Type(rr.Header().Rrtype))
+	case dns.TypeNS, dns.TypeDS, dns.TypeCNAME:
+		if subdomain == "" {
+			return fmt.Errorf("%s records cannot be published at the apex", dns.Type(rr.Header().Rrtype))
+		}
+	}
+	switch rr.Header().Rrtype {
+	case dns.TypeNS, dns.TypeDS, dns.TypeDNAME:
+		if subdomain == "*" || strings.HasPrefix(subdomain, "*.") {
+			return fmt.Errorf("%s records cannot be published at a wildcard (RFC 4592 section 4)", dns.Type(rr.Header().Rrtype))
+		}
+	}
+	switch rr := rr.(type) {
+	case *dns.CNAME:
+		target, err := normalizeAndValidateAliasTarget(testID, rr.Target)
+		if err != nil {
+			return fmt.Errorf("invalid CNAME target: %w", err)
+		}
+		rr.Target = target
+	case *dns.DNAME:
+		target, err := normalizeAndValidateAliasTarget(testID, rr.Target)
+		if err != nil {
+			return fmt.Errorf("invalid DNAME target: %w", err)
+		}
+		rr.Target = target
+	case *dns.TXT:
+		length := 0
+		for _, str := range rr.Txt {
+			length += len(str)
+		}
+		if length > maxTXTLength {
+			return fmt.Errorf("TXT record is too long")
+		}
+	}
+	return nil
+}
+
+func decodePostedDNSRecord(r *http.Request, testID testID) (string, []dns.RR, error) {
+	var subdomain string
+	var rrs []dns.RR
 	switch r.PostFormValue("add_dns_record") {
 	case "TXT":
-		subdomain := strings.ToLower(r.PostFormValue("txt_subdomain"))
-		txt := r.PostFormValue("txt_data")
-		if len(txt) > 255 {
-			return "", 0, nil, fmt.Errorf("TXT record is too long")
+		subdomain = strings.ToLower(r.PostFormValue("txt_subdomain"))
+		for _, txt := range strings.Split(strings.TrimRight(strings.ReplaceAll(r.PostFormValue("txt_data"), "\r\n", "\n"), "\n"), "\n") {
+			rrs = append(rrs, &dns.TXT{Hdr: dns.RR_Header{Rrtype: dns.TypeTXT}, Txt: splitTXT(txt)})
 		}
-		return subdomain, dns.TypeTXT, map[string]any{"Txt": []string{txt}}, nil
 	case "CAA":
-		subdomain := strings.ToLower(r.PostFormValue("caa_subdomain"))
+		subdomain = strings.ToLower(r.PostFormValue("caa_subdomain"))
 		flag, err := strconv.ParseUint(r.PostFormValue("caa_flag"), 10, 8)
 		if err != nil {
-			return "", 0, nil, fmt.Errorf("invalid CAA flag: %w", err)
+			return "", nil, fmt.Errorf("invalid CAA flag: %w", err)
 		}
 		tag := r.PostFormValue("caa_tag")
 		if err := validateCAATag(tag); err != nil {
-			return "", 0, nil, fmt.Errorf("invalid CAA tag: %w", err)
+			return "", nil, fmt.Errorf("invalid CAA tag: %w", err)
 		}
 		value := r.PostFormValue("caa_value")
-		return subdomain, dns.TypeCAA, map[string]any{"Flag": flag, "Tag": tag, "Value": value}, nil
+		rrs = []dns.RR{&dns.CAA{Hdr: dns.RR_Header{Rrtype: dns.TypeCAA}, Flag: uint8(flag), Tag: tag, Value: value}}
 	case "CNAME":
-		subdomain := strings.ToLower(r.PostFormValue("cname_subdomain"))
-		target, err := normalizeAndValidateCNAMETarget(r.PostFormValue("cname_target"))
+		subdomain = strings.ToLower(r.PostFormValue("cname_subdomain"))
+		rrs = []dns.RR{&dns.CNAME{Hdr: dns.RR_Header{Rrtype: dns.TypeCNAME}, Target: r.PostFormValue("cname_target")}}
+	case "other":
+		subdomain = strings.ToLower(r.PostFormValue("rr_subdomain"))
+		rrType := strings.ToUpper(strings.TrimSpace(r.PostFormValue("rr_type")))
+		if _, ok := parseQType(rrType); !ok {
+			return "", nil, fmt.Errorf("invalid record type")
+		}
+		rr, err := dns.NewRR(fmt.Sprintf("%s. 15 IN %s %s", makeHostname(testID, subdomain), rrType, r.PostFormValue("rr_data")))
 		if err != nil {
-			return "", 0, nil, fmt.Errorf("invalid CNAME target: %w", err)
+			return "", nil, fmt.Errorf("invalid record: %w", err)
+		} else if rr == nil {
+			return "", nil, fmt.Errorf("record data cannot be empty")
 		}
-		return subdomain, dns.TypeCNAME, map[string]any{"Target": target}, nil
+		rrs = []dns.RR{rr}
 	default:
-		return "", 0, nil, fmt.Errorf("invalid record type")
+		return "", nil, fmt.Errorf("invalid record type")
+	}
+	for _, rr := range rrs {
+		if err := validatePostedRR(testID, subdomain, rr); err != nil {
+			return "", nil, err
+		}
+	}
+	return subdomain, rrs, nil
+}
+
+// findCNAMEConflict returns the name of a subdomain where adding records
+// would leave a CNAME alongside other data (RFC 2181 section 10.1), or the
+// empty string if there is none.  Scopes are not considered, since a client in
+// a CNAME's scope would still see the unscoped records of other types.
+func findCNAMEConflict(ctx context.Context, testID testID, records []dnsZoneRecord) (string, error) {
+	types := make(map[string][]uint16)
+	for _, record := range records {
+		types[record.subdomain] = append(types[record.subdomain], record.rr.Header().Rrtype)
+	}
+	for subdomain, rrtypes := range types {
+		var existing []struct {
+			Type uint16 `sql:"type"`
+		}
+		if err := dbutil.QueryAll(ctx, db, &existing, `SELECT type FROM dns_record WHERE test_id = ? AND subdomain = ?`, testID[:], subdomain); err != nil {
+			return "", fmt.Errorf("error querying dns_record: %w", err)
+		}
+		for _, row := range existing {
+			rrtypes = append(rrtypes, row.Type)
+		}
+		if slices.Contains(rrtypes, dns.TypeCNAME) && slices.ContainsFunc(rrtypes, func(t uint16) bool { return t != dns.TypeCNAME }) {
+			return subdomain, nil
+		}
+	}
+	return "", nil
+}
+
+func insertDNSRecords(ctx context.Context, testID testID, records []dnsZoneRecord) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error beginning transaction: %w", err)
+	}
+	defer tx.Rollback()
+	for _, record := range records {
+		rrData, err := makeRecordData(record.rr)
+		if err != nil {
+			return fmt.Errorf("error encoding %s record: %w", dns.Type(record.rr.Header().Rrtype), err)
+		}
+		scope := sql.NullString{String: record.scope.String(), Valid: record.scope != nil}
+		if _, err := tx.ExecContext(ctx, `INSERT INTO dns_record (test_id, subdomain, type, ttl, data_json, scope) VALUES(?,?,?,?,?,?)`, testID[:], record.subdomain, record.rr.Header().Rrtype, record.rr.Header().Ttl, dbutil.JSON(rrData), scope); err != nil {
+			return fmt.Errorf("error inserting dns_record: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %w", err)
+	}
+	return nil
+}
+
+func decodePostedDNSFault(r *http.Request) (string, sql.NullInt64, string, sql.NullInt64, error) {
+	var (
+		subdomain = strings.ToLower(r.PostFormValue("fault_subdomain"))
+		qtype     sql.NullInt64
+		fault     = r.PostFormValue("fault")
+		maxCount  sql.NullInt64
+	)
+	if str := r.PostFormValue("fault_qtype"); str != "" {
+		value, ok := parseQType(str)
+		if !ok {
+			return "", qtype, "", maxCount, fmt.Errorf("invalid query type")
+		}
+		qtype = sql.NullInt64{Int64: int64(value), Valid: true}
+	}
+	if !isValidDNSFault(fault) {
+		return "", qtype, "", maxCount, fmt.Errorf("invalid fault")
+	}
+	if str := r.PostFormValue("fault_max_count"); str != "" {
+		value, err := strconv.ParseUint(str, 10, 31)
+		if err != nil {
+			return "", qtype, "", maxCount, fmt.Errorf("invalid number of attempts: %w", err)
+		}
+		maxCount = sql.NullInt64{Int64: int64(value), Valid: true}
+	}
+	return subdomain, qtype, fault, maxCount, nil
+}
+
+func decodePostedDNSDelay(r *http.Request) (string, sql.NullInt64, time.Duration, time.Duration, sql.NullInt64, error) {
+	var (
+		subdomain = strings.ToLower(r.PostFormValue("delay_subdomain"))
+		qtype     sql.NullInt64
+		delay     time.Duration
+		jitter    time.Duration
+		maxCount  sql.NullInt64
+		err       error
+	)
+	if str := r.PostFormValue("delay_qtype"); str != "" {
+		value, ok := parseQType(str)
+		if !ok {
+			return "", qtype, 0, 0, maxCount, fmt.Errorf("invalid query type")
+		}
+		qtype = sql.NullInt64{Int64: int64(value), Valid: true}
+	}
+	if delay, err = parseDelay(r.PostFormValue("delay")); err != nil {
+		return "", qtype, 0, 0, maxCount, fmt.Errorf("invalid delay: %w", err)
+	}
+	if str := r.PostFormValue("delay_jitter"); str != "" {
+		if jitter, err = parseDelay(str); err != nil {
+			return "", qtype, 0, 0, maxCount, fmt.Errorf("invalid jitter: %w", err)
+		}
+	}
+	if delay+jitter > maxDNSDelay {
+		return "", qtype, 0, 0, maxCount, fmt.Errorf("delay plus jitter must not be longer than %s", maxDNSDelay)
+	}
+	if str := r.PostFormValue("delay_max_count"); str != "" {
+		value, err := strconv.ParseUint(str, 10, 31)
+		if err != nil {
+			return "", qtype, 0, 0, maxCount, fmt.Errorf("invalid number of attempts: %w", err)
+		}
+		maxCount = sql.NullInt64{Int64: int64(value), Valid: true}
 	}
+	return subdomain, qtype, delay, jitter, maxCount, nil
 }
 
+// maxPostOverhead is the size of a POST to the test dashboard, excluding an
+// uploaded HTTP file, above which the request is rejected
+const maxPostOverhead = 1024 * 1024
+
 func serveTest(ctx context.Context, w http.ResponseWriter, r *http.Request, testID testID) error {
 	dashboard, err := loadTestDashboard(ctx, testID)
 	if err != nil {
@@ -377,23 +1197,168 @@ func serveTest(ctx context.Context, w http.ResponseWriter, r *http.Request, test
 		return nil
 	}
 	if dashboard.IsRunning() && r.Method == http.MethodPost {
+		r.Body = http.MaxBytesReader(w, r.Body, httpFileLimit+maxPostOverhead)
 		if r.PostFormValue("stop") != "" {
-			if _, err := db.ExecContext(ctx, `UPDATE test SET stopped_at = CURRENT_TIMESTAMP WHERE test_id = ? AND stopped_at IS NULL`, testID[:]); err != nil {
+			if _, err := db.ExecContext(ctx, `UPDATE test SET stopped_at = CURRENT_TIMESTAMP, tls_key_pem = NULL WHERE test_id = ? AND stopped_at IS NULL`, testID[:]); err != nil {
 				return fmt.Errorf("serveTest: error updating test: %w", err)
 			}
 		} else if r.PostFormValue("add_dns_record") != "" {
-			subdomain, rrType, rrData, err := decodePostedDNSRecord(r)
+			subdomain, rrs, err := decodePostedDNSRecord(r, testID)
 			if err != nil {
 				http.Error(w, err.Error(), 400)
 				return nil
 			}
-			if _, err := db.ExecContext(ctx, `INSERT INTO dns_record (test_id, subdomain, type, data_json) VALUES(?,?,?,?)`, testID[:], subdomain, rrType, dbutil.JSON(rrData)); err != nil {
-				return fmt.Errorf("serveTest: error inserting dns_record: %w", err)
+			ttl := uint32(defaultRecordTTL)
+			if str := r.PostFormValue("ttl"); str != "" {
+				if ttl, err = parseTTL(str); err != nil {
+					http.Error(w, "Invalid TTL: "+err.Error(), 400)
+					return nil
+				}
+			}
+			scope, err := parseRecordScope(r.PostFormValue("scope"))
+			if err != nil {
+				http.Error(w, "Invalid source network: "+err.Error(), 400)
+				return nil
+			}
+			records := make([]dnsZoneRecord, len(rrs))
+			for i, rr := range rrs {
+				rr.Header().Ttl = ttl
+				records[i] = dnsZoneRecord{subdomain: subdomain, rr: rr, scope: scope}
+			}
+			if conflict, err := findCNAMEConflict(ctx, testID, records); err != nil {
+				return fmt.Errorf("serveTest: %w", err)
+			} else if conflict != "" {
+				http.Error(w, fmt.Sprintf("A CNAME record cannot be published alongside other records at %s", makeHostname(testID, conflict)), 400)
+				return nil
+			}
+			if err := insertDNSRecords(ctx, testID, records); err != nil {
+				return fmt.Errorf("serveTest: %w", err)
+			}
+		} else if r.PostFormValue("import_zone") != "" {
+			records, err := parseZoneFile(testID, r.PostFormValue("zone_data"))
+			if err != nil {
+				http.Error(w, "Invalid zone file: "+err.Error(), 400)
+				return nil
+			}
+			if conflict, err := findCNAMEConflict(ctx, testID, records); err != nil {
+				return fmt.Errorf("serveTest: %w", err)
+			} else if conflict != "" {
+				http.Error(w, fmt.Sprintf("Invalid zone file: a CNAME record cannot be published alongside other records at %s", makeHostname(testID, conflict)), 400)
+				return nil
+			}
+			if err := insertDNSRecords(ctx, testID, records); err != nil {
+				return fmt.Errorf("serveTest: %w", err)
+			}
+		} else if mode := r.PostFormValue("set_dnssec_mode"); mode != "" {
+			if !isValidDNSSECMode(mode) {
+				http.Error(w, "Invalid DNSSEC mode", 400)
+				return nil
+			}
+			if _, err := db.ExecContext(ctx, `UPDATE test SET dnssec_mode = ? WHERE test_id = ?`, mode, testID[:]); err != nil {
+				return fmt.Errorf("serveTest: error updating test: %w", err)
+			}
+		} else if mode := r.PostFormValue("set_tls_cert_mode"); mode != "" {
+			if !isValidTLSCertMode(mode) {
+				http.Error(w, "Invalid TLS certificate mode", 400)
+				return nil
+			}
+			// A previously uploaded certificate is kept unless a new one is posted
+			certPEM, keyPEM := r.PostFormValue("tls_cert_pem"), r.PostFormValue("tls_key_pem")
+			if mode == tlsCertUploaded && (certPEM != "" || keyPEM != "" || dashboard.TLSCertPEM == nil) {
+				if err := validateUploadedCert(certPEM, keyPEM); err != nil {
+					http.Error(w, "Invalid certificate or key: "+err.Error(), 400)
+					return nil
+				}
+				if _, err := db.ExecContext(ctx, `UPDATE test SET tls_cert_pem = ?, tls_key_pem = ? WHERE test_id = ?`, certPEM, keyPEM, testID[:]); err != nil {
+					return fmt.Errorf("serveTest: error updating test: %w", err)
+				}
+			}
+			if _, err := db.ExecContext(ctx, `UPDATE test SET tls_cert_mode = ? WHERE test_id = ?`, mode, testID[:]); err != nil {
+				return fmt.Errorf("serveTest: error updating test: %w", err)
+			}
+		} else if r.PostFormValue("set_acme_thumbprint") != "" {
+			thumbprint, err := parseACMEThumbprint(r.PostFormValue("acme_thumbprint"))
+			if err != nil {
+				http.Error(w, "Invalid ACME account key: "+err.Error(), 400)
+				return nil
+			}
+			if _, err := db.ExecContext(ctx, `UPDATE test SET acme_thumbprint = ? WHERE test_id = ?`, thumbprint, testID[:]); err != nil {
+				return fmt.Errorf("serveTest: error updating test: %w", err)
+			}
+		} else if r.PostFormValue("rm_acme_thumbprint") != "" {
+			if _, err := db.ExecContext(ctx, `UPDATE test SET acme_thumbprint = NULL WHERE test_id = ?`, testID[:]); err != nil {
+				return fmt.Errorf("serveTest: error updating test: %w", err)
+			}
+		} else if r.PostFormValue("set_negative_ttl") != "" {
+			ttl, err := parseTTL(r.PostFormValue("negative_ttl"))
+			if err != nil {
+				http.Error(w, "Invalid TTL: "+err.Error(), 400)
+				return nil
+			}
+			if _, err := db.ExecContext(ctx, `UPDATE test SET negative_ttl = ? WHERE test_id = ?`, ttl, testID[:]); err != nil {
+				return fmt.Errorf("serveTest: error updating test: %w", err)
 			}
 		} else if dnsRecordID := r.PostFormValue("rm_dns_record"); dnsRecordID != "" {
 			if _, err := db.ExecContext(ctx, `DELETE FROM dns_record WHERE test_id = ? AND dns_record_id = ?`, testID[:], dnsRecordID); err != nil {
 				return fmt.Errorf("serveTest: error deleting dns_record: %w", err)
 			}
+		} else if r.PostFormValue("add_dns_fault") != "" {
+			subdomain, qtype, fault, maxCount, err := decodePostedDNSFault(r)
+			if err != nil {
+				http.Error(w, err.Error(), 400)
+				return nil
+			}
+			if _, err := db.ExecContext(ctx, `INSERT INTO dns_fault (test_id, subdomain, qtype, fault, max_count) VALUES(?,?,?,?,?)`, testID[:], subdomain, qtype, fault, maxCount); err != nil {
+				return fmt.Errorf("serveTest: error inserting dns_fault: %w", err)
+			}
+		} else if dnsFaultID := r.PostFormValue("rm_dns_fault"); dnsFaultID != "" {
+			if _, err := db.ExecContext(ctx, `DELETE FROM dns_fault WHERE test_id = ? AND dns_fault_id = ?`, testID[:], dnsFaultID); err != nil {
+				return fmt.Errorf("serveTest: error deleting dns_fault: %w", err)
+			}
+		} else if r.PostFormValue("add_dns_delay") != "" {
+			subdomain, qtype, delay, jitter, maxCount, err := decodePostedDNSDelay(r)
+			if err != nil {
+				http.Error(w, err.Error(), 400)
+				return nil
+			}
+			if _, err := db.ExecContext(ctx, `INSERT INTO dns_delay (test_id, subdomain, qtype, delay_ms, jitter_ms, max_count) VALUES(?,?,?,?,?,?)`, testID[:], subdomain, qtype, delay.Milliseconds(), jitter.Milliseconds(), maxCount); err != nil {
+				return fmt.Errorf("serveTest: error inserting dns_delay: %w", err)
+			}
+		} else if dnsDelayID := r.PostFormValue("rm_dns_delay"); dnsDelayID != "" {
+			if _, err := db.ExecContext(ctx, `DELETE FROM dns_delay WHERE test_id = ? AND dns_delay_id = ?`, testID[:], dnsDelayID); err != nil {
+				return fmt.Errorf("serveTest: error deleting dns_delay: %w", err)
+			}
+		} else if r.PostFormValue("set_dns_synthesis") != "" {
+			var (
+				subdomain = strings.ToLower(r.PostFormValue("synthesis_subdomain"))
+				addresses = r.PostFormValue("synthesis_addresses")
+				mx        = r.PostFormValue("synthesis_mx") != ""
+			)
+			if getAddressSynthesis(addresses) == nil {
+				http.Error(w, "Invalid addresses", 400)
+				return nil
+			}
+			if _, err := db.ExecContext(ctx, `INSERT INTO dns_synthesis (test_id, subdomain, addresses, mx) VALUES(?,?,?,?) ON CONFLICT (test_id, subdomain) DO UPDATE SET addresses = excluded.addresses, mx = excluded.mx`, testID[:], subdomain, addresses, mx); err != nil {
+				return fmt.Errorf("serveTest: error upserting dns_synthesis: %w", err)
+			}
+		} else if r.PostFormValue("rm_dns_synthesis") != "" {
+			if _, err := db.ExecContext(ctx, `DELETE FROM dns_synthesis WHERE test_id = ? AND subdomain = ?`, testID[:], r.PostFormValue("synthesis_subdomain")); err != nil {
+				return fmt.Errorf("serveTest: error deleting dns_synthesis: %w", err)
+			}
+		} else if r.PostFormValue("set_tls_alpn_challenge") != "" {
+			subdomain := strings.ToLower(r.PostFormValue("tls_alpn_subdomain"))
+			digest, err := parseKeyAuthorizationDigest(r.PostFormValue("tls_alpn_digest"))
+			if err != nil {
+				http.Error(w, "Invalid key authorization digest: "+err.Error(), 400)
+				return nil
+			}
+			if _, err := db.ExecContext(ctx, `INSERT INTO tls_alpn_challenge (test_id, subdomain, key_authorization_digest) VALUES(?,?,?) ON CONFLICT (test_id, subdomain) DO UPDATE SET key_authorization_digest = excluded.key_authorization_digest`, testID[:], subdomain, digest); err != nil {
+				return fmt.Errorf("serveTest: error upserting tls_alpn_challenge: %w", err)
+			}
+		} else if r.PostFormValue("rm_tls_alpn_challenge") != "" {
+			if _, err := db.ExecContext(ctx, `DELETE FROM tls_alpn_challenge WHERE test_id = ? AND subdomain = ?`, testID[:], r.PostFormValue("tls_alpn_subdomain")); err != nil {
+				return fmt.Errorf("serveTest: error deleting tls_alpn_challenge: %w", err)
+			}
 		} else if httpFileID := r.PostFormValue("rm_http_file"); httpFileID != "" {
 			if _, err := db.ExecContext(ctx, `DELETE FROM http_file WHERE test_id = ? AND http_file_id = ?`, testID[:], httpFileID); err != nil {
 				return fmt.Errorf("serveTest: error deleting http_file: %w", err)
@@ -403,22 +1368,76 @@ func serveTest(ctx context.Context, w http.ResponseWriter, r *http.Request, test
 				scheme    = r.PostFormValue("file_scheme")
 				subdomain = r.PostFormValue("file_subdomain")
 				path      = r.PostFormValue("file_path")
-				content   = r.PostFormValue("file_content")
+				match     = r.PostFormValue("file_match")
+				content   = []byte(r.PostFormValue("file_content"))
 			)
 			subdomain = strings.ToLower(subdomain)
+			if match == "" {
+				match = httpFileMatchExact
+			}
 			if scheme != "http" && scheme != "https" {
 				http.Error(w, "Scheme must be http or https", 400)
 				return nil
 			}
-			if !strings.HasPrefix(path, "/.well-known/pki-validation/") && !strings.HasPrefix(path, "/.well-known/acme-challenge/") {
-				http.Error(w, "Path must start with /.well-known/pki-validation/ or /.well-known/acme-challenge/", 400)
+			if err := validateHTTPFilePath(path, match); err != nil {
+				http.Error(w, "Invalid path: "+err.Error(), 400)
 				return nil
 			}
-			if len(content) > 512 {
-				http.Error(w, "Content must not be longer than 512 bytes", 400)
+			if upload, header, err := r.FormFile("file_upload"); err == nil {
+				defer upload.Close()
+				if header.Size > httpFileLimit {
+					http.Error(w, fmt.Sprintf("Content must not be longer than %d bytes", httpFileLimit), 400)
+					return nil
+				}
+				if content, err = io.ReadAll(io.LimitReader(upload, httpFileLimit)); err != nil {
+					return fmt.Errorf("serveTest: error reading uploaded file: %w", err)
+				}
+			} else if err != http.ErrMissingFile && err != http.ErrNotMultipart {
+				http.Error(w, "Invalid upload: "+err.Error(), 400)
 				return nil
 			}
-			if err := dbutil.MustAffectRow(db.ExecContext(ctx, `INSERT INTO http_file (test_id, scheme, subdomain, path, content) VALUES(?,?,?,?,?) ON CONFLICT (test_id, scheme, subdomain, path) DO NOTHING`, testID[:], scheme, subdomain, path, content)); err == sql.ErrNoRows {
+			if int64(len(content)) > httpFileLimit {
+				http.Error(w, fmt.Sprintf("Content must not be longer than %d bytes", httpFileLimit), 400)
+				return nil
+			}
+			status, err := parseHTTPFileStatus(r.PostFormValue("file_status"))
+			if err != nil {
+				http.Error(w, "Invalid status: "+err.Error(), 400)
+				return nil
+			}
+			contentType := r.PostFormValue("file_content_type")
+			if contentType != "" {
+				if _, _, err := mime.ParseMediaType(contentType); err != nil || !httpguts.ValidHeaderFieldValue(contentType) {
+					http.Error(w, "Invalid content type", 400)
+					return nil
+				}
+			}
+			header, err := parseHTTPFileHeaders(r.PostFormValue("file_headers"))
+			if err != nil {
+				http.Error(w, "Invalid headers: "+err.Error(), 400)
+				return nil
+			}
+			var headerJSON driver.Valuer
+			if len(header) > 0 {
+				headerJSON = dbutil.JSON(header)
+			}
+			var location string
+			if str := strings.TrimSpace(r.PostFormValue("file_redirect")); str != "" {
+				if location, err = parseHTTPFileRedirect(str); err != nil {
+					http.Error(w, "Invalid redirect: "+err.Error(), 400)
+					return nil
+				}
+				if r.PostFormValue("file_status") == "" {
+					status = http.StatusFound
+				} else if !isHTTPRedirectStatus(status) {
+					http.Error(w, "Redirect status must be 301, 302, 307, or 308", 400)
+					return nil
+				}
+			} else if isHTTPRedirectStatus(status) {
+				http.Error(w, "A redirect status requires a redirect target", 400)
+				return nil
+			}
+			if err := dbutil.MustAffectRow(db.ExecContext(ctx, `INSERT INTO http_file (test_id, scheme, subdomain, path, path_match, content, status, content_type, header_json, location) VALUES(?,?,?,?,?,?,?,?,?,?) ON CONFLICT (test_id, scheme, subdomain, path) DO NOTHING`, testID[:], scheme, subdomain, path, match, content, status, nullString(contentType), headerJSON, nullString(location))); err == sql.ErrNoRows {
 				http.Error(w, "There is already a file at this subdomain and path", 400)
 				return nil
 			} else if err != nil {
@@ -428,6 +1447,39 @@ func serveTest(ctx context.Context, w http.ResponseWriter, r *http.Request, test
 		http.Redirect(w, r, "/test/"+testID.String(), http.StatusSeeOther)
 		return nil
 	}
+	if r.FormValue("export_zone") != "" {
+		text, err := formatZoneFile(testID, dashboard.DNSRecords)
+		if err != nil {
+			return fmt.Errorf("serveTest: error exporting zone: %w", err)
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", testID.String()+".zone"))
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(text))
+		return nil
+	}
+	if r.FormValue("caa_analysis") != "" {
+		dnsNames := r.Form["dns_name"]
+		if len(dnsNames) > maxCAAAnalysisNames {
+			http.Error(w, "Too many DNS names", 400)
+			return nil
+		}
+		records, err := lookupDNSRecords(ctx, testID)
+		if err != nil {
+			return fmt.Errorf("serveTest: %w", err)
+		}
+		zone := &testZone{apex: makeHostname(testID, "") + ".", records: records}
+		analyses := []*caaAnalysis{}
+		for _, dnsName := range dnsNames {
+			analyses = append(analyses, analyzeCAAByScope(zone, dashboard.DNS, dnsName)...)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(analyses)
+		return nil
+	}
 	if r.FormValue("ctsearch") != "" {
 		resp, err := ctsearch(ctx, "issuances", url.Values{
 			"domain":             {makeHostname(testID, "")},
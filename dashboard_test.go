@@ -1,6 +1,17 @@
 package main
 
-import "testing"
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
 
 func TestNormalizeAndValidateCNAMETarget(t *testing.T) {
 	tests := []struct {
@@ -34,3 +45,166 @@ func TestNormalizeAndValidateCNAMETarget(t *testing.T) {
 		})
 	}
 }
+
+func TestDNSItemCasePattern(t *testing.T) {
+	tests := []struct {
+		fqdn string
+		want string
+	}{
+		{fqdn: "_acme-challenge.example.com.", want: ""},
+		{fqdn: "_AcMe-challenge.example.com.", want: "_X-X-----------.-------.---."},
+		{fqdn: "WWW.1.EXAMPLE.COM.", want: "XXX.1.XXXXXXX.XXX."},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.fqdn, func(t *testing.T) {
+			item := dnsItem{FQDN: tt.fqdn}
+			if got := item.CasePattern(); got != tt.want {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitTXT(t *testing.T) {
+	tests := []struct {
+		txt  string
+		want []int
+	}{
+		{txt: "", want: []int{0}},
+		{txt: strings.Repeat("x", 255), want: []int{255}},
+		{txt: strings.Repeat("x", 256), want: []int{255, 1}},
+		{txt: strings.Repeat("x", 600), want: []int{255, 255, 90}},
+	}
+
+	for _, tt := range tests {
+		t.Run(strconv.Itoa(len(tt.txt)), func(t *testing.T) {
+			var got []int
+			for _, str := range splitTXT(tt.txt) {
+				got = append(got, len(str))
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Fatalf("got lengths %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodePostedDNSRecord(t *testing.T) {
+	setTestServerGlobals(t)
+	testID := generateTestID()
+
+	tests := []struct {
+		name    string
+		form    url.Values
+		wantErr bool
+	}{
+		{name: "TXT", form: url.Values{"add_dns_record": {"TXT"}, "txt_subdomain": {"_acme-challenge"}, "txt_data": {"token"}}},
+		{name: "TXT too long", form: url.Values{"add_dns_record": {"TXT"}, "txt_subdomain": {"www"}, "txt_data": {strings.Repeat("x", maxTXTLength+1)}}, wantErr: true},
+		{name: "CAA", form: url.Values{"add_dns_record": {"CAA"}, "caa_subdomain": {""}, "caa_flag": {"0"}, "caa_tag": {"issue"}, "caa_value": {"ca.example"}}},
+		{name: "CNAME", form: url.Values{"add_dns_record": {"CNAME"}, "cname_subdomain": {"www"}, "cname_target": {"_abc.acm-validations.aws"}}},
+		{name: "CNAME at apex", form: url.Values{"add_dns_record": {"CNAME"}, "cname_subdomain": {""}, "cname_target": {"_abc.acm-validations.aws"}}, wantErr: true},
+		{name: "CNAME to invalid target", form: url.Values{"add_dns_record": {"CNAME"}, "cname_subdomain": {"www"}, "cname_target": {"localhost"}}, wantErr: true},
+		{name: "other NS at apex", form: url.Values{"add_dns_record": {"other"}, "rr_subdomain": {""}, "rr_type": {"NS"}, "rr_data": {"ns1.example.net."}}, wantErr: true},
+		{name: "other generated type", form: url.Values{"add_dns_record": {"other"}, "rr_subdomain": {"www"}, "rr_type": {"NSEC"}, "rr_data": {"www.example.com. A"}}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			_, rrs, err := decodePostedDNSRecord(r, testID)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got %v", rrs)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestDNSRetryGroups(t *testing.T) {
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	ms := func(n int64) *int64 { return &n }
+	item := func(seconds int, fqdn string, qtype uint16, delayMS *int64) dnsItem {
+		return dnsItem{ReceivedAt: start.Add(time.Duration(seconds) * time.Second), FQDN: fqdn, QType: qtype, DelayMS: delayMS}
+	}
+
+	tests := []struct {
+		name string
+		dns  []dnsItem
+		want []string // FQDN/QTYPE followed by each attempt's offset
+	}{
+		{
+			name: "no delays",
+			dns:  []dnsItem{item(0, "a.example.", dns.TypeA, nil), item(1, "a.example.", dns.TypeA, nil)},
+		},
+		{
+			name: "retries within delay",
+			dns: []dnsItem{
+				item(0, "a.example.", dns.TypeA, ms(5000)),
+				item(3, "A.Example.", dns.TypeA, nil),
+				item(6, "a.example.", dns.TypeA, nil),
+			},
+			want: []string{"a.example./A 0s 3s 6s"},
+		},
+		{
+			name: "query after grace starts nothing",
+			dns: []dnsItem{
+				item(0, "a.example.", dns.TypeA, ms(5000)),
+				item(8, "a.example.", dns.TypeA, nil),
+			},
+			want: []string{"a.example./A 0s"},
+		},
+		{
+			name: "delayed retry extends window",
+			dns: []dnsItem{
+				item(0, "a.example.", dns.TypeA, ms(5000)),
+				item(6, "a.example.", dns.TypeA, ms(5000)),
+				item(12, "a.example.", dns.TypeA, nil),
+				item(15, "a.example.", dns.TypeA, nil),
+			},
+			want: []string{"a.example./A 0s 6s 12s"},
+		},
+		{
+			name: "delayed query after window starts new group",
+			dns: []dnsItem{
+				item(0, "a.example.", dns.TypeA, ms(1000)),
+				item(10, "a.example.", dns.TypeA, ms(1000)),
+				item(11, "a.example.", dns.TypeA, nil),
+			},
+			want: []string{"a.example./A 0s", "a.example./A 0s 1s"},
+		},
+		{
+			name: "grouped by name and type",
+			dns: []dnsItem{
+				item(0, "a.example.", dns.TypeA, ms(5000)),
+				item(0, "a.example.", dns.TypeAAAA, ms(5000)),
+				item(1, "b.example.", dns.TypeA, nil),
+				item(2, "a.example.", dns.TypeAAAA, nil),
+			},
+			want: []string{"a.example./A 0s", "a.example./AAAA 0s 2s"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dashboard := &testDashboard{DNS: tt.dns}
+			var got []string
+			for _, group := range dashboard.DNSRetryGroups() {
+				str := group.FQDN + "/" + group.QType
+				for _, attempt := range group.Attempts {
+					str += " " + attempt.Offset.String()
+				}
+				got = append(got, str)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
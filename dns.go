@@ -27,17 +27,23 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"database/sql"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"github.com/miekg/dns"
 	"log"
 	"net"
 	"net/netip"
+	"slices"
 	"src.agwa.name/go-dbutil"
 	"strings"
+	"time"
 )
 
 func serveDNS(w dns.ResponseWriter, req *dns.Msg) {
+	ctx := context.Background()
 	testDomain := "test." + domain + "."
 
 	if len(req.Question) == 0 || req.Question[0].Qclass != dns.ClassINET ||
@@ -54,109 +60,525 @@ func serveDNS(w dns.ResponseWriter, req *dns.Msg) {
 		return
 	}
 
-	var answers []dns.RR
+	transport := dnsTransport(w)
+	var remoteAddr netip.Addr
+	if addrPort, err := netip.ParseAddrPort(w.RemoteAddr().String()); err == nil {
+		remoteAddr = addrPort.Addr().Unmap()
+	}
 
-	if fqdn == testDomain {
-		answers = []dns.RR{}
-		if qtype == dns.TypeNS || qtype == dns.TypeANY {
-			answers = append(answers, &dns.NS{
-				Hdr: dns.RR_Header{Name: testDomain, Rrtype: dns.TypeNS, Class: dns.ClassINET, Ttl: 86400},
-				Ns:  domain + ".",
-			})
-		}
-		if qtype == dns.TypeSOA || qtype == dns.TypeANY {
-			answers = append(answers, makeSOA())
-		}
-	} else if testID, subdomain, ok := parseHostname(fqdn); ok {
-		if !strings.HasPrefix(fqdn, "_") {
-			answers = []dns.RR{}
-			if qtype == dns.TypeA || qtype == dns.TypeANY {
-				for _, addr := range v4address {
-					answers = append(answers, &dns.A{
-						Hdr: dns.RR_Header{Name: fqdn, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 3600},
-						A:   addr.AsSlice(),
-					})
-				}
+	var (
+		soa          = makeSOA(testDomain, defaultNegativeTTL)
+		mode         = dnssecValid
+		answer       dnsAnswer
+		notes        dnsResponseNotes
+		recordedTest *testID
+	)
+
+	if testID, subdomain, ok := parseHostname(fqdn); ok && !(subdomain == "" && qtype == dns.TypeDS) {
+		test, err := loadTestZone(ctx, testID)
+		if err != nil {
+			log.Printf("error loading DNS zone for test %v: %s", testID, err)
+			sendServerFailure(w, req)
+			return
+		}
+		soa = makeSOA(test.apex, test.negativeTTL)
+		mode = test.dnssecMode
+		test.restrictToClient(remoteAddr)
+		answer = test.lookup(subdomain, fqdn, qtype)
+		notes.variant = test.answerVariant(subdomain, answer)
+		notes.referral = answer.delegation
+		if test.running {
+			if notes.fault, err = matchDNSFault(ctx, testID, subdomain, qtype, transport == "udp"); err != nil {
+				log.Printf("error matching DNS faults: %s", err)
 			}
-			if qtype == dns.TypeAAAA || qtype == dns.TypeANY {
-				for _, addr := range v6address {
-					answers = append(answers, &dns.AAAA{
-						Hdr:  dns.RR_Header{Name: fqdn, Rrtype: dns.TypeAAAA, Class: dns.ClassINET, Ttl: 3600},
-						AAAA: addr.AsSlice(),
-					})
-				}
+			if notes.delay, err = matchDNSDelay(ctx, testID, subdomain, qtype); err != nil {
+				log.Printf("error matching DNS delays: %s", err)
 			}
-			if qtype == dns.TypeMX || qtype == dns.TypeANY {
-				answers = append(answers, &dns.MX{
-					Hdr:        dns.RR_Header{Name: fqdn, Rrtype: dns.TypeMX, Class: dns.ClassINET, Ttl: 86400},
-					Preference: 10,
-					Mx:         domain + ".",
-				})
+			if isSynthesizedType(qtype) && !strings.HasPrefix(fqdn, "_") && answer.delegation == "" {
+				matched := matchDNSSynthesis(test.syntheses, subdomain)
+				notes.synthesis = matched.Label()
 			}
+			recordedTest = &testID
 		}
-		if isRunning, err := isRunningTest(context.Background(), testID); err != nil {
-			log.Printf("error checking if %v is a running test: %s", testID, err)
-		} else if isRunning {
-			if err := lookupDNSRecords(context.Background(), testID, subdomain, qtype, &answers); err != nil {
-				log.Printf("error looking up DNS records: %s", err)
-			}
-			if err := recordDNSRequest(context.Background(), testID, w.RemoteAddr(), req); err != nil {
-				log.Printf("error recording DNS request: %s", err)
-			}
+	} else {
+		var err error
+		if answer, err = lookupParentZone(ctx, fqdn, qtype); err != nil {
+			log.Printf("error looking up %s in parent zone: %s", fqdn, err)
+			sendServerFailure(w, req)
+			return
 		}
 	}
 
+	var respBytes []byte
+	if resp := makeDNSResponse(req, transport, soa, mode, answer, notes.fault); resp != nil {
+		var err error
+		if respBytes, err = resp.Pack(); err != nil {
+			log.Printf("error packing DNS response: %s", err)
+			sendServerFailure(w, req)
+			return
+		}
+	}
+
+	if recordedTest != nil {
+		if wantsDNSSEC(req) {
+			notes.dnssecMode = mode
+		}
+		if err := recordDNSRequest(ctx, *recordedTest, w.RemoteAddr(), transport, req, respBytes, notes); err != nil {
+			log.Printf("error recording DNS request: %s", err)
+		}
+	}
+
+	if respBytes != nil {
+		// The dns package reads the next query from a TCP or DoT connection
+		// only after this handler returns, and closes the connection once it
+		// stops reading, so the delay is applied inline.  This holds up any
+		// queries pipelined behind the delayed one on the same connection.
+		time.Sleep(notes.delay)
+		w.Write(respBytes)
+	}
+}
+
+// makeDNSResponse returns the response to req from the zone with the given
+// SOA, or nil if no response should be sent
+func makeDNSResponse(req *dns.Msg, transport string, soa *dns.SOA, mode string, answer dnsAnswer, fault string) *dns.Msg {
+	zone := soa.Hdr.Name
 	resp := new(dns.Msg)
+
+	switch fault {
+	case dnsFaultDrop:
+		return nil
+	case dnsFaultServerFailure:
+		return resp.SetRcode(req, dns.RcodeServerFailure)
+	case dnsFaultRefused:
+		return resp.SetRcode(req, dns.RcodeRefused)
+	}
+
 	resp.SetReply(req)
 	resp.Authoritative = true
 	resp.Compress = true
-	resp.Answer = answers
-	if len(answers) == 0 {
-		if answers == nil {
-			resp.Rcode = dns.RcodeNameError
+	if fault == dnsFaultTruncate {
+		resp.Truncated = true
+	} else if answer.delegation != "" {
+		// The server is not authoritative for the delegated name (RFC 1034 section 4.3.2)
+		resp.Authoritative = false
+		resp.Rcode = answer.rcode
+		resp.Ns = answer.authority
+		resp.Extra = answer.glue
+	} else {
+		resp.Rcode = answer.rcode
+		resp.Answer = answer.answer
+		if len(resp.Answer) == 0 {
+			negativeSOA := dns.Copy(soa).(*dns.SOA)
+			negativeSOA.Hdr.Ttl = min(soa.Hdr.Ttl, soa.Minttl) // RFC 2308 section 3
+			resp.Ns = []dns.RR{negativeSOA}
 		}
-		resp.Ns = []dns.RR{makeSOA()}
 	}
-	w.WriteMsg(resp)
+	if opt := req.IsEdns0(); opt != nil {
+		if opt.Do() && !resp.Truncated {
+			var err error
+			if answer.delegation != "" {
+				err = signReferral(resp, zone, mode, min(soa.Hdr.Ttl, soa.Minttl))
+			} else {
+				err = signDNSResponse(resp, zone, mode, answer.types)
+			}
+			if err != nil {
+				log.Printf("error signing DNS response: %s", err)
+				return new(dns.Msg).SetRcode(req, dns.RcodeServerFailure)
+			}
+		}
+		resp.SetEdns0(1232, opt.Do())
+	}
+	if fault == dnsFaultPad {
+		padDNSResponse(resp, zone)
+	} else if transport == "udp" {
+		resp.Truncate(maxUDPSize(req))
+	}
+	return resp
 }
 
-func lookupDNSRecords(ctx context.Context, testID testID, subdomain string, qtype uint16, rrs *[]dns.RR) error {
-	var rows []struct {
-		Type     uint16 `sql:"type"`
-		DataJSON string `sql:"data_json"`
+// dnsAnswer is the result of looking up a query in a zone
+type dnsAnswer struct {
+	rcode  int
+	answer []dns.RR
+	types  []uint16 // the types which exist at the query name
+
+	// A referral has no answer, and instead contains the NS and DS RRsets
+	// of a delegated name along with glue for its nameservers
+	delegation string
+	authority  []dns.RR
+	glue       []dns.RR
+}
+
+// answerNode answers a query given the RRs at the query name, which are nil
+// if the name does not exist
+func answerNode(rrs []dns.RR, qtype uint16) dnsAnswer {
+	if rrs == nil {
+		return dnsAnswer{rcode: dns.RcodeNameError}
+	}
+	return dnsAnswer{
+		rcode:  dns.RcodeSuccess,
+		answer: selectAnswers(rrs, qtype),
+		types:  rrTypes(rrs),
 	}
-	if qtype == dns.TypeANY {
-		if err := dbutil.QueryAll(ctx, db, &rows, `SELECT type, data_json FROM dns_record WHERE test_id = ? AND subdomain = ? ORDER BY dns_record_id`, testID[:], subdomain); err != nil {
-			return fmt.Errorf("error querying dns_record row: %w", err)
+}
+
+// testZone contains the per-test settings and records that affect DNS responses
+type testZone struct {
+	apex        string
+	running     bool
+	dnssecMode  string
+	negativeTTL uint32
+	records     []dnsZoneRecord
+	syntheses   []dnsSynthesis
+}
+
+// dnsZoneRecord is a record published by the test owner
+type dnsZoneRecord struct {
+	subdomain string
+	rr        dns.RR
+	scope     *recordScope // nil if visible to all sources
+}
+
+func loadTestZone(ctx context.Context, id testID) (*testZone, error) {
+	var (
+		stoppedAt sql.NullTime
+		zone      = testZone{apex: makeHostname(id, "") + "."}
+	)
+	if err := db.QueryRowContext(ctx, `SELECT stopped_at, dnssec_mode, negative_ttl FROM test WHERE test_id = ?`, id[:]).Scan(&stoppedAt, &zone.dnssecMode, &zone.negativeTTL); err == sql.ErrNoRows {
+		zone.dnssecMode = dnssecOff
+		zone.negativeTTL = defaultNegativeTTL
+		return &zone, nil
+	} else if err != nil {
+		return nil, err
+	}
+	zone.running = !stoppedAt.Valid
+	if zone.running {
+		var err error
+		if zone.records, err = lookupDNSRecords(ctx, id); err != nil {
+			return nil, err
 		}
-	} else {
-		if err := dbutil.QueryAll(ctx, db, &rows, `SELECT type, data_json FROM dns_record WHERE test_id = ? AND subdomain = ? AND (type = ? OR type = ?) ORDER BY dns_record_id`, testID[:], subdomain, qtype, dns.TypeCNAME); err != nil {
-			return fmt.Errorf("error querying dns_record row: %w", err)
+		if zone.syntheses, err = loadDNSSyntheses(ctx, id); err != nil {
+			return nil, err
 		}
 	}
-	for _, row := range rows {
-		makeRR := dns.TypeToRR[row.Type]
-		if makeRR == nil {
-			return fmt.Errorf("dns_record row contains unknown DNS record type %d", row.Type)
+	return &zone, nil
+}
+
+// lookup answers a query for fqdn, which is subdomain beneath the zone's apex
+func (zone *testZone) lookup(subdomain string, fqdn string, qtype uint16) dnsAnswer {
+	for _, ancestor := range subdomainAncestors(subdomain) {
+		if ns := zone.recordsOfType(ancestor, dns.TypeNS); ns != nil {
+			return zone.referral(ancestor, ns)
 		}
-		rr := makeRR()
-		rr.Header().Name = makeHostname(testID, subdomain) + "."
-		rr.Header().Rrtype = row.Type
-		rr.Header().Class = dns.ClassINET
-		rr.Header().Ttl = 15
-		if err := json.Unmarshal([]byte(row.DataJSON), &rr); err != nil {
-			return fmt.Errorf("dns_record row contains bad JSON in the data column: %w", err)
+		if dname := zone.findRecord(ancestor, dns.TypeDNAME); dname != nil {
+			return synthesizeFromDNAME(dname.(*dns.DNAME), fqdn)
 		}
-		if row.Type == dns.TypeCNAME {
-			*rrs = []dns.RR{rr}
-			break
+	}
+	// The DS RRset at a delegation belongs to the parent side (RFC 4035 section 3.1.4.1)
+	if ns := zone.recordsOfType(subdomain, dns.TypeNS); ns != nil && qtype != dns.TypeDS {
+		return zone.referral(subdomain, ns)
+	}
+	return answerNode(zone.nodeRRs(subdomain, fqdn), qtype)
+}
+
+// referral returns a referral to the nameservers of the delegated subdomain,
+// with glue for the nameservers which are beneath it
+func (zone *testZone) referral(subdomain string, ns []dns.RR) dnsAnswer {
+	answer := dnsAnswer{
+		rcode:      dns.RcodeSuccess,
+		delegation: ns[0].Header().Name,
+		authority:  append(ns, zone.recordsOfType(subdomain, dns.TypeDS)...),
+	}
+	var targets []string
+	for _, rr := range ns {
+		target := strings.ToLower(rr.(*dns.NS).Ns)
+		if !dns.IsSubDomain(answer.delegation, target) || slices.Contains(targets, target) {
+			continue
+		}
+		targets = append(targets, target)
+		for _, glue := range zone.recordsAt(testSubdomain(zone.apex, target)) {
+			if rrtype := glue.Header().Rrtype; rrtype == dns.TypeA || rrtype == dns.TypeAAAA {
+				answer.glue = append(answer.glue, glue)
+			}
+		}
+	}
+	return answer
+}
+
+func (zone *testZone) recordsOfType(subdomain string, rrtype uint16) []dns.RR {
+	var rrs []dns.RR
+	for _, record := range zone.records {
+		if record.subdomain == subdomain && record.rr.Header().Rrtype == rrtype {
+			rrs = append(rrs, record.rr)
+		}
+	}
+	return rrs
+}
+
+func (zone *testZone) findRecord(subdomain string, rrtype uint16) dns.RR {
+	for _, record := range zone.records {
+		if record.subdomain == subdomain && record.rr.Header().Rrtype == rrtype {
+			return record.rr
 		}
-		*rrs = append(*rrs, rr)
 	}
 	return nil
 }
 
-func recordDNSRequest(ctx context.Context, testID testID, remoteAddr net.Addr, req *dns.Msg) error {
+// nodeRRs returns all the RRs at fqdn, or nil if fqdn does not exist
+func (zone *testZone) nodeRRs(subdomain string, fqdn string) []dns.RR {
+	var builtin, records []dns.RR
+	if subdomain == "" {
+		builtin = append(builtin, makeSOA(fqdn, zone.negativeTTL), makeNS(fqdn))
+		if zone.dnssecMode != dnssecOff {
+			builtin = append(builtin, makeDNSKEY(fqdn))
+		}
+	}
+	if !strings.HasPrefix(fqdn, "_") {
+		builtin = append(builtin, synthesizeRRs(matchDNSSynthesis(zone.syntheses, subdomain), fqdn)...)
+	}
+	if zone.exists(subdomain) {
+		records = zone.recordsAt(subdomain)
+	} else {
+		// Synthesize records from the wildcard at the closest encloser, if any (RFC 4592)
+		for _, rr := range zone.recordsAt(wildcardSubdomain(zone.closestEncloser(subdomain))) {
+			rr = dns.Copy(rr)
+			rr.Header().Name = fqdn
+			records = append(records, rr)
+		}
+	}
+
+	// Records published by the test owner replace built-in RRs of the same
+	// type, and a CNAME or delegation replaces all built-in RRs
+	recordTypes := rrTypes(records)
+	rrs := slices.DeleteFunc(builtin, func(rr dns.RR) bool {
+		return slices.Contains(recordTypes, rr.Header().Rrtype) || slices.Contains(recordTypes, dns.TypeCNAME) || slices.Contains(recordTypes, dns.TypeNS)
+	})
+	rrs = append(rrs, records...)
+
+	if rrs == nil && zone.exists(subdomain) {
+		// subdomain is an empty non-terminal, which exists but has no RRs
+		return []dns.RR{}
+	}
+	return rrs
+}
+
+func (zone *testZone) recordsAt(subdomain string) []dns.RR {
+	var rrs []dns.RR
+	for _, record := range zone.records {
+		if record.subdomain == subdomain {
+			rrs = append(rrs, record.rr)
+		}
+	}
+	return rrs
+}
+
+// exists reports whether the test owner has published records at or beneath subdomain
+func (zone *testZone) exists(subdomain string) bool {
+	if subdomain == "" {
+		return true
+	}
+	return slices.ContainsFunc(zone.records, func(record dnsZoneRecord) bool {
+		return record.subdomain == subdomain || strings.HasSuffix(record.subdomain, "."+subdomain)
+	})
+}
+
+// closestEncloser returns the closest ancestor of subdomain which exists
+func (zone *testZone) closestEncloser(subdomain string) string {
+	ancestors := subdomainAncestors(subdomain)
+	for i := len(ancestors) - 1; i >= 0; i-- {
+		if zone.exists(ancestors[i]) {
+			return ancestors[i]
+		}
+	}
+	return ""
+}
+
+func wildcardSubdomain(encloser string) string {
+	if encloser == "" {
+		return "*"
+	}
+	return "*." + encloser
+}
+
+// subdomainAncestors returns the proper ancestors of subdomain, excluding
+// the empty subdomain, starting with the one closest to the apex
+func subdomainAncestors(subdomain string) []string {
+	var ancestors []string
+	for i := len(subdomain) - 1; i >= 0; i-- {
+		if subdomain[i] == '.' {
+			ancestors = append(ancestors, subdomain[i+1:])
+		}
+	}
+	return ancestors
+}
+
+// synthesizeFromDNAME answers a query for fqdn, which is beneath dname's owner (RFC 6672)
+func synthesizeFromDNAME(dname *dns.DNAME, fqdn string) dnsAnswer {
+	target := strings.TrimSuffix(fqdn, dname.Hdr.Name) + dname.Target
+	if _, ok := dns.IsDomainName(target); !ok {
+		return dnsAnswer{rcode: dns.RcodeYXDomain, answer: []dns.RR{dname}}
+	}
+	cname := &dns.CNAME{
+		Hdr:    dns.RR_Header{Name: fqdn, Rrtype: dns.TypeCNAME, Class: dns.ClassINET, Ttl: dname.Hdr.Ttl},
+		Target: target,
+	}
+	return dnsAnswer{rcode: dns.RcodeSuccess, answer: []dns.RR{dname, cname}}
+}
+
+// lookupParentZone answers a query for fqdn in the test.<domain> zone
+func lookupParentZone(ctx context.Context, fqdn string, qtype uint16) (dnsAnswer, error) {
+	testDomain := "test." + domain + "."
+	if fqdn == testDomain {
+		return answerNode([]dns.RR{
+			makeSOA(testDomain, defaultNegativeTTL),
+			makeNS(testDomain),
+			makeDNSKEY(testDomain),
+		}, qtype), nil
+	}
+	testID, subdomain, ok := parseHostname(fqdn)
+	if !ok || subdomain != "" {
+		return answerNode(nil, qtype), nil
+	}
+	rrs := []dns.RR{makeNS(fqdn)}
+	test, err := loadTestZone(ctx, testID)
+	if err != nil {
+		return dnsAnswer{}, err
+	}
+	if test.dnssecMode != dnssecOff {
+		rrs = append(rrs, makeDS(fqdn))
+	}
+	return answerNode(rrs, qtype), nil
+}
+
+// selectAnswers returns the RRs from rrs which answer a query of type qtype.
+// ANY queries are answered with only the first RRset (RFC 8482).
+func selectAnswers(rrs []dns.RR, qtype uint16) []dns.RR {
+	answers := []dns.RR{}
+	for _, rr := range rrs {
+		if rr.Header().Rrtype == dns.TypeCNAME {
+			return []dns.RR{rr}
+		}
+	}
+	if qtype == dns.TypeANY && len(rrs) > 0 {
+		qtype = rrs[0].Header().Rrtype
+	}
+	for _, rr := range rrs {
+		if rr.Header().Rrtype == qtype {
+			answers = append(answers, rr)
+		}
+	}
+	return answers
+}
+
+func rrTypes(rrs []dns.RR) []uint16 {
+	var types []uint16
+	for _, rr := range rrs {
+		if !slices.Contains(types, rr.Header().Rrtype) {
+			types = append(types, rr.Header().Rrtype)
+		}
+	}
+	return types
+}
+
+func lookupDNSRecords(ctx context.Context, testID testID) ([]dnsZoneRecord, error) {
+	var rows []struct {
+		Subdomain string         `sql:"subdomain"`
+		Type      uint16         `sql:"type"`
+		TTL       uint32         `sql:"ttl"`
+		DataJSON  string         `sql:"data_json"`
+		Scope     sql.NullString `sql:"scope"`
+	}
+	if err := dbutil.QueryAll(ctx, db, &rows, `SELECT subdomain, type, ttl, data_json, scope FROM dns_record WHERE test_id = ? ORDER BY dns_record_id`, testID[:]); err != nil {
+		return nil, fmt.Errorf("error querying dns_record row: %w", err)
+	}
+	records := make([]dnsZoneRecord, len(rows))
+	for i, row := range rows {
+		rr, err := makeRecordRR(makeHostname(testID, row.Subdomain)+".", row.Type, row.TTL, row.DataJSON)
+		if err != nil {
+			return nil, fmt.Errorf("dns_record row contains bad data: %w", err)
+		}
+		scope, err := parseRecordScope(row.Scope.String)
+		if err != nil {
+			return nil, fmt.Errorf("dns_record row contains bad scope: %w", err)
+		}
+		records[i] = dnsZoneRecord{subdomain: row.Subdomain, rr: rr, scope: scope}
+	}
+	return records, nil
+}
+
+// storesAsRFC3597 reports whether the data of a record type is stored in the
+// dns_record table as RFC 3597 hex, rather than as the JSON encoding of the
+// miekg/dns struct (which can't always be decoded again)
+func storesAsRFC3597(rrtype uint16) bool {
+	_, isKnown := dns.TypeToRR[rrtype]
+	return !isKnown || rrtype == dns.TypeSVCB || rrtype == dns.TypeHTTPS
+}
+
+// makeRecordData returns the value of the data_json column for rr
+func makeRecordData(rr dns.RR) (map[string]any, error) {
+	if storesAsRFC3597(rr.Header().Rrtype) {
+		rfc3597 := new(dns.RFC3597)
+		if err := rfc3597.ToRFC3597(rr); err != nil {
+			return nil, err
+		}
+		return map[string]any{"Rdata": rfc3597.Rdata}, nil
+	}
+	dataJSON, err := json.Marshal(rr)
+	if err != nil {
+		return nil, err
+	}
+	var data map[string]any
+	if err := json.Unmarshal(dataJSON, &data); err != nil {
+		return nil, err
+	}
+	delete(data, "Hdr")
+	return data, nil
+}
+
+// rdataString returns the presentation format of rr's data, without the owner name, TTL, class, and type
+func rdataString(rr dns.RR) string {
+	if rfc3597, ok := rr.(*dns.RFC3597); ok {
+		return fmt.Sprintf("\\# %d %s", len(rfc3597.Rdata)/2, rfc3597.Rdata)
+	}
+	return strings.TrimPrefix(rr.String(), rr.Header().String())
+}
+
+// makeRecordRR is the inverse of makeRecordData
+func makeRecordRR(name string, rrtype uint16, ttl uint32, dataJSON string) (dns.RR, error) {
+	hdr := dns.RR_Header{Name: name, Rrtype: rrtype, Class: dns.ClassINET, Ttl: ttl}
+	if storesAsRFC3597(rrtype) {
+		var data struct{ Rdata string }
+		if err := json.Unmarshal([]byte(dataJSON), &data); err != nil {
+			return nil, err
+		}
+		rdata, err := hex.DecodeString(data.Rdata)
+		if err != nil {
+			return nil, err
+		}
+		hdr.Rdlength = uint16(len(rdata))
+		rr, _, err := dns.UnpackRRWithHeader(hdr, rdata, 0)
+		return rr, err
+	}
+	rr := dns.TypeToRR[rrtype]()
+	*rr.Header() = hdr
+	if err := json.Unmarshal([]byte(dataJSON), &rr); err != nil {
+		return nil, err
+	}
+	return rr, nil
+}
+
+// dnsResponseNotes records how the response to a query was chosen, for
+// display on the test dashboard.  Empty fields are recorded as NULL.
+type dnsResponseNotes struct {
+	dnssecMode string        // the DNSSEC mode, if the query requested DNSSEC
+	fault      string        // the injected fault
+	synthesis  string        // the setting for synthesized A, AAAA, and MX records
+	variant    string        // the scopes of split-horizon records in the answer
+	referral   string        // the delegated name, if the response is a referral
+	delay      time.Duration // how long the response was held
+}
+
+func recordDNSRequest(ctx context.Context, testID testID, remoteAddr net.Addr, transport string, req *dns.Msg, respBytes []byte, notes dnsResponseNotes) error {
 	addrPort, err := netip.ParseAddrPort(remoteAddr.String())
 	if err != nil {
 		return fmt.Errorf("error parsing DNS remote address: %w", err)
@@ -167,32 +589,126 @@ func recordDNSRequest(ctx context.Context, testID testID, remoteAddr net.Addr, r
 		return fmt.Errorf("error packing DNS message: %w", err)
 	}
 
-	if _, err := db.ExecContext(ctx, `INSERT INTO dns_request (test_id, remote_ip, remote_port, fqdn, qtype, bytes) VALUES (?, ?, ?, ?, ?, ?)`, testID[:], addrPort.Addr().String(), addrPort.Port(), req.Question[0].Name, req.Question[0].Qtype, reqBytes); err != nil {
+	var (
+		ednsVersion  sql.NullInt64
+		ednsUDPSize  sql.NullInt64
+		ednsDO       sql.NullBool
+		cookie       sql.NullString
+		clientSubnet sql.NullString
+		nsid         sql.NullBool
+	)
+	if opt := req.IsEdns0(); opt != nil {
+		ednsVersion = sql.NullInt64{Int64: int64(opt.Version()), Valid: true}
+		ednsUDPSize = sql.NullInt64{Int64: int64(opt.UDPSize()), Valid: true}
+		ednsDO = sql.NullBool{Bool: opt.Do(), Valid: true}
+		nsid = sql.NullBool{Valid: true}
+		for _, option := range opt.Option {
+			switch option := option.(type) {
+			case *dns.EDNS0_COOKIE:
+				cookie = sql.NullString{String: option.Cookie, Valid: true}
+			case *dns.EDNS0_SUBNET:
+				clientSubnet = sql.NullString{String: fmt.Sprintf("%s/%d", option.Address, option.SourceNetmask), Valid: true}
+			case *dns.EDNS0_NSID:
+				nsid.Bool = true
+			}
+		}
+	}
+
+	if _, err := db.ExecContext(ctx, `INSERT INTO dns_request (test_id, remote_ip, remote_port, fqdn, qtype, bytes, dnssec_mode, fault, transport, edns_version, edns_udp_size, edns_do, cookie, client_subnet, nsid, rd, cd, response_bytes, synthesis, variant, referral, delay_ms) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		testID[:], addrPort.Addr().String(), addrPort.Port(), req.Question[0].Name, req.Question[0].Qtype, reqBytes,
+		nullString(notes.dnssecMode), nullString(notes.fault),
+		transport, ednsVersion, ednsUDPSize, ednsDO, cookie, clientSubnet, nsid, req.RecursionDesired, req.CheckingDisabled, respBytes,
+		nullString(notes.synthesis), nullString(notes.variant), nullString(notes.referral), sql.NullInt64{Int64: notes.delay.Milliseconds(), Valid: notes.delay != 0}); err != nil {
 		return fmt.Errorf("error inserting dns_request: %w", err)
 	}
 
 	return nil
 }
 
+func nullString(str string) sql.NullString {
+	return sql.NullString{String: str, Valid: str != ""}
+}
+
+func dnsTransport(w dns.ResponseWriter) string {
+	if bufferedWriter, ok := w.(*bufferedDNSWriter); ok {
+		return bufferedWriter.transport
+	} else if stater, ok := w.(dns.ConnectionStater); ok && stater.ConnectionState() != nil {
+		return "tls"
+	} else if _, isUDP := w.RemoteAddr().(*net.UDPAddr); isUDP {
+		return "udp"
+	} else {
+		return "tcp"
+	}
+}
+
+func maxUDPSize(req *dns.Msg) int {
+	if opt := req.IsEdns0(); opt != nil {
+		return int(opt.UDPSize())
+	}
+	return dns.MinMsgSize
+}
+
 func sendRefused(w dns.ResponseWriter, req *dns.Msg) {
 	resp := new(dns.Msg)
 	resp.SetRcode(req, dns.RcodeRefused)
 	w.WriteMsg(resp)
 }
 
-func makeSOA() *dns.SOA {
+func sendServerFailure(w dns.ResponseWriter, req *dns.Msg) {
+	resp := new(dns.Msg)
+	resp.SetRcode(req, dns.RcodeServerFailure)
+	w.WriteMsg(resp)
+}
+
+func makeNS(zone string) *dns.NS {
+	return &dns.NS{
+		Hdr: dns.RR_Header{Name: zone, Rrtype: dns.TypeNS, Class: dns.ClassINET, Ttl: 86400},
+		Ns:  domain + ".",
+	}
+}
+
+// defaultRecordTTL is the TTL of records which don't specify their own
+const defaultRecordTTL = 15
+
+// defaultNegativeTTL is the SOA minimum of test.<domain> and of tests which
+// don't configure their own
+const defaultNegativeTTL = 15
+
+func makeSOA(zone string, negativeTTL uint32) *dns.SOA {
 	return &dns.SOA{
-		Hdr:     dns.RR_Header{Name: "test." + domain + ".", Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: 86400},
+		Hdr:     dns.RR_Header{Name: zone, Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: 86400},
 		Ns:      domain + ".",
 		Mbox:    "hostmaster." + domain + ".",
 		Serial:  1,
 		Refresh: 86400,
 		Retry:   86400,
 		Expire:  86400,
-		Minttl:  15,
+		Minttl:  negativeTTL,
 	}
 }
 
 func runDNSServer(l net.Listener, p net.PacketConn) {
-	log.Fatal(dns.ActivateAndServe(l, p, dns.HandlerFunc(serveDNS)))
+	var handler dns.Handler = dns.HandlerFunc(serveDNS)
+	if p != nil && dnsRateLimiter != nil {
+		handler = dnsRateLimiter.Handler(handler)
+	}
+	log.Fatal(dns.ActivateAndServe(l, p, handler))
+}
+
+// getDNSCertificate returns the certificate for encrypted DNS transports.
+// Resolvers talking to authoritative servers often omit SNI, so the
+// certificate for the main domain is always used.
+func getDNSCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	domainHello := *hello
+	domainHello.ServerName = domain
+	return getHTTPSCertificate(&domainHello)
+}
+
+// runDoTServer serves DNS over TLS (RFC 7858)
+func runDoTServer(l net.Listener) {
+	runDNSServer(tls.NewListener(l, &tls.Config{
+		GetCertificate: getDNSCertificate,
+		NextProtos:     []string{"dot"},
+		MinVersion:     tls.VersionTLS12,
+	}), nil)
 }
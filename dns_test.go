@@ -0,0 +1,259 @@
+// Copyright (C) 2026 Opsmate, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a
+// copy of this software and associated documentation files (the "Software"),
+// to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense,
+// and/or sell copies of the Software, and to permit persons to whom the
+// Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included
+// in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+// THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+// OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+// ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+// OTHER DEALINGS IN THE SOFTWARE.
+//
+// Except as contained in this notice, the name(s) of the above copyright
+// holders shall not be used in advertising or otherwise to promote the
+// sale, use or other dealings in this Software without prior written
+// authorization.
+
+package main
+
+import (
+	"net/netip"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+// setTestServerGlobals sets the server's domain and addresses for the
+// duration of a test
+func setTestServerGlobals(t *testing.T) {
+	t.Helper()
+	oldDomain, oldV4Address, oldV6Address := domain, v4address, v6address
+	t.Cleanup(func() { domain, v4address, v6address = oldDomain, oldV4Address, oldV6Address })
+	domain = "example.com"
+	v4address = []netip.Addr{netip.MustParseAddr("192.0.2.1")}
+	v6address = []netip.Addr{netip.MustParseAddr("2001:db8::1")}
+}
+
+// mustNewRR parses a record in zone file format
+func mustNewRR(t *testing.T, s string) dns.RR {
+	t.Helper()
+	rr, err := dns.NewRR(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rr
+}
+
+// mustZoneRecord returns a record at subdomain of apex, with data in zone
+// file format after the class, and a scope in the form of parseRecordScope
+func mustZoneRecord(t *testing.T, apex string, subdomain string, data string, scope string) dnsZoneRecord {
+	t.Helper()
+	name := apex
+	if subdomain != "" {
+		name = subdomain + "." + apex
+	}
+	parsedScope, err := parseRecordScope(scope)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dnsZoneRecord{subdomain: subdomain, rr: mustNewRR(t, name+" 15 IN "+data), scope: parsedScope}
+}
+
+func TestTestZoneLookup(t *testing.T) {
+	setTestServerGlobals(t)
+
+	const apex = "0123456789abcdef0123456789abcdef.test.example.com."
+	zone := &testZone{
+		apex:        apex,
+		running:     true,
+		dnssecMode:  dnssecOff,
+		negativeTTL: defaultNegativeTTL,
+		records: []dnsZoneRecord{
+			mustZoneRecord(t, apex, "a._b", `TXT "hello"`, ""),
+			mustZoneRecord(t, apex, "_acme-challenge", `TXT "token"`, ""),
+			mustZoneRecord(t, apex, "_acme-challenge", `CAA 0 issue "ca.example"`, ""),
+			mustZoneRecord(t, apex, "www", "A 192.0.2.99", ""),
+			mustZoneRecord(t, apex, "*.wild", "MX 10 mail.example.net.", ""),
+			mustZoneRecord(t, apex, "*.wild", `TXT "wildcard"`, ""),
+			mustZoneRecord(t, apex, "real.wild", "A 192.0.2.50", ""),
+			mustZoneRecord(t, apex, "a.ent.wild", `TXT "deep"`, ""),
+			mustZoneRecord(t, apex, "*._wild", `TXT "wildcard"`, ""),
+			mustZoneRecord(t, apex, "_a._ent._wild", `TXT "deep"`, ""),
+			mustZoneRecord(t, apex, "*.alias", "CNAME _x.sectigo.com.", ""),
+		},
+	}
+
+	tests := []struct {
+		name      string
+		subdomain string
+		qtype     uint16
+		rcode     int
+		answer    []uint16
+		contains  string // if set, the first answer must contain this
+	}{
+		{name: "empty non-terminal", subdomain: "_b", qtype: dns.TypeTXT, rcode: dns.RcodeSuccess},
+		{name: "record beneath empty non-terminal", subdomain: "a._b", qtype: dns.TypeTXT, rcode: dns.RcodeSuccess, answer: []uint16{dns.TypeTXT}},
+		{name: "nonexistent underscore name", subdomain: "_c", qtype: dns.TypeTXT, rcode: dns.RcodeNameError},
+		{name: "NODATA at record", subdomain: "_acme-challenge", qtype: dns.TypeA, rcode: dns.RcodeSuccess},
+		{name: "apex SOA", subdomain: "", qtype: dns.TypeSOA, rcode: dns.RcodeSuccess, answer: []uint16{dns.TypeSOA}},
+		{name: "apex NS", subdomain: "", qtype: dns.TypeNS, rcode: dns.RcodeSuccess, answer: []uint16{dns.TypeNS}},
+		{name: "apex DNSKEY when unsigned", subdomain: "", qtype: dns.TypeDNSKEY, rcode: dns.RcodeSuccess},
+		{name: "no SOA below apex", subdomain: "www", qtype: dns.TypeSOA, rcode: dns.RcodeSuccess},
+		{name: "published A replaces built-in A", subdomain: "www", qtype: dns.TypeA, rcode: dns.RcodeSuccess, answer: []uint16{dns.TypeA}},
+		{name: "built-in MX", subdomain: "www", qtype: dns.TypeMX, rcode: dns.RcodeSuccess, answer: []uint16{dns.TypeMX}},
+		{name: "ANY at apex", subdomain: "", qtype: dns.TypeANY, rcode: dns.RcodeSuccess, answer: []uint16{dns.TypeSOA}},
+		{name: "ANY returns one RRset", subdomain: "_acme-challenge", qtype: dns.TypeANY, rcode: dns.RcodeSuccess, answer: []uint16{dns.TypeTXT}},
+		{name: "ANY at empty non-terminal", subdomain: "_b", qtype: dns.TypeANY, rcode: dns.RcodeSuccess},
+		{name: "ANY at nonexistent name", subdomain: "_c", qtype: dns.TypeANY, rcode: dns.RcodeNameError},
+		{name: "wildcard match", subdomain: "x.wild", qtype: dns.TypeTXT, rcode: dns.RcodeSuccess, answer: []uint16{dns.TypeTXT}, contains: "wildcard"},
+		{name: "wildcard match two labels deep", subdomain: "y.x.wild", qtype: dns.TypeTXT, rcode: dns.RcodeSuccess, answer: []uint16{dns.TypeTXT}, contains: "wildcard"},
+		{name: "wildcard MX replaces built-in MX", subdomain: "x.wild", qtype: dns.TypeMX, rcode: dns.RcodeSuccess, answer: []uint16{dns.TypeMX}, contains: "mail.example.net."},
+		{name: "built-in A at wildcard match", subdomain: "x.wild", qtype: dns.TypeA, rcode: dns.RcodeSuccess, answer: []uint16{dns.TypeA}},
+		{name: "wildcard does not match its parent", subdomain: "wild", qtype: dns.TypeTXT, rcode: dns.RcodeSuccess},
+		{name: "existing name blocks wildcard", subdomain: "real.wild", qtype: dns.TypeTXT, rcode: dns.RcodeSuccess},
+		{name: "existing name blocks wildcard MX", subdomain: "real.wild", qtype: dns.TypeMX, rcode: dns.RcodeSuccess, answer: []uint16{dns.TypeMX}, contains: "MX\t10 example.com."},
+		{name: "empty non-terminal blocks wildcard", subdomain: "ent.wild", qtype: dns.TypeTXT, rcode: dns.RcodeSuccess},
+		{name: "no wildcard at empty non-terminal", subdomain: "x.ent.wild", qtype: dns.TypeTXT, rcode: dns.RcodeSuccess},
+		{name: "underscore wildcard match", subdomain: "_x._wild", qtype: dns.TypeTXT, rcode: dns.RcodeSuccess, answer: []uint16{dns.TypeTXT}, contains: "wildcard"},
+		{name: "underscore empty non-terminal", subdomain: "_ent._wild", qtype: dns.TypeTXT, rcode: dns.RcodeSuccess},
+		{name: "underscore name beneath empty non-terminal", subdomain: "_x._ent._wild", qtype: dns.TypeTXT, rcode: dns.RcodeNameError},
+		{name: "wildcard CNAME replaces built-in A", subdomain: "x.alias", qtype: dns.TypeA, rcode: dns.RcodeSuccess, answer: []uint16{dns.TypeCNAME}, contains: "_x.sectigo.com."},
+		{name: "wildcard CNAME replaces built-in MX", subdomain: "x.alias", qtype: dns.TypeMX, rcode: dns.RcodeSuccess, answer: []uint16{dns.TypeCNAME}, contains: "_x.sectigo.com."},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fqdn := apex
+			if tt.subdomain != "" {
+				fqdn = tt.subdomain + "." + apex
+			}
+			answer := zone.lookup(tt.subdomain, fqdn, tt.qtype)
+			if answer.rcode != tt.rcode {
+				t.Fatalf("got rcode %s, want %s", dns.RcodeToString[answer.rcode], dns.RcodeToString[tt.rcode])
+			}
+			var got []uint16
+			for _, rr := range answer.answer {
+				got = append(got, rr.Header().Rrtype)
+			}
+			if !slices.Equal(got, tt.answer) {
+				t.Fatalf("got answer types %v, want %v", got, tt.answer)
+			}
+			for _, rr := range answer.answer {
+				if rr.Header().Name != fqdn {
+					t.Errorf("answer %v is not owned by the query name", rr)
+				}
+			}
+			if tt.contains != "" && !strings.Contains(answer.answer[0].String(), tt.contains) {
+				t.Errorf("answer %v does not contain %q", answer.answer[0], tt.contains)
+			}
+		})
+	}
+}
+
+func TestTestZoneDelegation(t *testing.T) {
+	setTestServerGlobals(t)
+
+	const apex = "0123456789abcdef0123456789abcdef.test.example.com."
+	long := strings.Repeat("x", 63)
+	zone := &testZone{
+		apex:        apex,
+		running:     true,
+		dnssecMode:  dnssecOff,
+		negativeTTL: defaultNegativeTTL,
+		records: []dnsZoneRecord{
+			mustZoneRecord(t, apex, "sub", "NS ns1.sub."+apex, ""),
+			mustZoneRecord(t, apex, "sub", "NS host."+apex, ""),
+			mustZoneRecord(t, apex, "sub", "NS ns.example.net.", ""),
+			mustZoneRecord(t, apex, "sub", "DS 12345 13 2 0000000000000000000000000000000000000000000000000000000000000000", ""),
+			mustZoneRecord(t, apex, "ns1.sub", "A 192.0.2.53", ""),
+			mustZoneRecord(t, apex, "ns1.sub", "AAAA 2001:db8::53", ""),
+			mustZoneRecord(t, apex, "ns1.sub", `TXT "not glue"`, ""),
+			mustZoneRecord(t, apex, "host", "A 192.0.2.54", ""),
+			mustZoneRecord(t, apex, "insecure", "NS ns.example.net.", ""),
+			mustZoneRecord(t, apex, "dn", "DNAME example.net.", ""),
+			mustZoneRecord(t, apex, "longdn", "DNAME "+long+"."+long+"."+long+".example.net.", ""),
+		},
+	}
+
+	tests := []struct {
+		name       string
+		subdomain  string
+		qtype      uint16
+		delegation string // the delegated name, or empty if no referral
+		authority  []uint16
+		glue       []string
+		rcode      int
+		answer     []uint16
+	}{
+		{name: "at cut", subdomain: "sub", qtype: dns.TypeA, delegation: "sub." + apex, authority: []uint16{dns.TypeNS, dns.TypeNS, dns.TypeNS, dns.TypeDS}, glue: []string{"192.0.2.53", "2001:db8::53"}},
+		{name: "NS at cut", subdomain: "sub", qtype: dns.TypeNS, delegation: "sub." + apex, authority: []uint16{dns.TypeNS, dns.TypeNS, dns.TypeNS, dns.TypeDS}, glue: []string{"192.0.2.53", "2001:db8::53"}},
+		{name: "beneath cut", subdomain: "www.sub", qtype: dns.TypeA, delegation: "sub." + apex, authority: []uint16{dns.TypeNS, dns.TypeNS, dns.TypeNS, dns.TypeDS}, glue: []string{"192.0.2.53", "2001:db8::53"}},
+		{name: "glue name beneath cut", subdomain: "ns1.sub", qtype: dns.TypeA, delegation: "sub." + apex, authority: []uint16{dns.TypeNS, dns.TypeNS, dns.TypeNS, dns.TypeDS}, glue: []string{"192.0.2.53", "2001:db8::53"}},
+		{name: "DS at cut from parent", subdomain: "sub", qtype: dns.TypeDS, answer: []uint16{dns.TypeDS}},
+		{name: "DS beneath cut", subdomain: "www.sub", qtype: dns.TypeDS, delegation: "sub." + apex, authority: []uint16{dns.TypeNS, dns.TypeNS, dns.TypeNS, dns.TypeDS}, glue: []string{"192.0.2.53", "2001:db8::53"}},
+		{name: "insecure delegation", subdomain: "insecure", qtype: dns.TypeA, delegation: "insecure." + apex, authority: []uint16{dns.TypeNS}},
+		{name: "no DS at insecure cut", subdomain: "insecure", qtype: dns.TypeDS, answer: nil},
+		{name: "DNAME owner", subdomain: "dn", qtype: dns.TypeDNAME, answer: []uint16{dns.TypeDNAME}},
+		{name: "DNAME synthesis", subdomain: "www.dn", qtype: dns.TypeA, answer: []uint16{dns.TypeDNAME, dns.TypeCNAME}},
+		{name: "DNAME synthesis two labels deep", subdomain: "a.b.dn", qtype: dns.TypeTXT, answer: []uint16{dns.TypeDNAME, dns.TypeCNAME}},
+		{name: "DNAME overflow", subdomain: long + ".longdn", qtype: dns.TypeA, rcode: dns.RcodeYXDomain, answer: []uint16{dns.TypeDNAME}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fqdn := tt.subdomain + "." + apex
+			answer := zone.lookup(tt.subdomain, fqdn, tt.qtype)
+			if answer.rcode != tt.rcode {
+				t.Fatalf("got rcode %s, want %s", dns.RcodeToString[answer.rcode], dns.RcodeToString[tt.rcode])
+			}
+			if answer.delegation != tt.delegation {
+				t.Fatalf("got delegation %q, want %q", answer.delegation, tt.delegation)
+			}
+			var authority []uint16
+			for _, rr := range answer.authority {
+				authority = append(authority, rr.Header().Rrtype)
+			}
+			if !slices.Equal(authority, tt.authority) {
+				t.Errorf("got authority types %v, want %v", authority, tt.authority)
+			}
+			var glue []string
+			for _, rr := range answer.glue {
+				switch rr := rr.(type) {
+				case *dns.A:
+					glue = append(glue, rr.A.String())
+				case *dns.AAAA:
+					glue = append(glue, rr.AAAA.String())
+				default:
+					t.Errorf("unexpected glue %v", rr)
+				}
+			}
+			if !slices.Equal(glue, tt.glue) {
+				t.Errorf("got glue %v, want %v", glue, tt.glue)
+			}
+			var got []uint16
+			for _, rr := range answer.answer {
+				got = append(got, rr.Header().Rrtype)
+			}
+			if !slices.Equal(got, tt.answer) {
+				t.Fatalf("got answer types %v, want %v", got, tt.answer)
+			}
+			if len(answer.answer) == 2 {
+				cname := answer.answer[1].(*dns.CNAME)
+				if want := strings.TrimSuffix(fqdn, "dn."+apex) + "example.net."; cname.Hdr.Name != fqdn || cname.Target != want {
+					t.Errorf("got synthesized %v, want %s CNAME %s", cname, fqdn, want)
+				}
+			}
+		})
+	}
+}
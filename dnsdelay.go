@@ -0,0 +1,76 @@
+// Copyright (C) 2026 Opsmate, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a
+// copy of this software and associated documentation files (the "Software"),
+// to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense,
+// and/or sell copies of the Software, and to permit persons to whom the
+// Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included
+// in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+// THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+// OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+// ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+// OTHER DEALINGS IN THE SOFTWARE.
+//
+// Except as contained in this notice, the name(s) of the above copyright
+// holders shall not be used in advertising or otherwise to promote the
+// sale, use or other dealings in this Software without prior written
+// authorization.
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxDNSDelay bounds the delay plus jitter, so that delayed responses can
+// still be delivered over connection-oriented transports
+const maxDNSDelay = 60 * time.Second
+
+// matchDNSDelay returns how long to hold the response to a query before
+// sending it, or zero if it should be sent immediately.  Each match counts
+// as an attempt against the delay's limit.
+func matchDNSDelay(ctx context.Context, testID testID, subdomain string, qtype uint16) (time.Duration, error) {
+	var delayMS, jitterMS int64
+	err := db.QueryRowContext(ctx, `UPDATE dns_delay SET hit_count = hit_count + 1 WHERE dns_delay_id = (SELECT dns_delay_id FROM dns_delay WHERE test_id = ? AND subdomain = ? AND (qtype IS NULL OR qtype = ?) AND (max_count IS NULL OR hit_count < max_count) ORDER BY dns_delay_id LIMIT 1) RETURNING delay_ms, jitter_ms`, testID[:], subdomain, qtype).Scan(&delayMS, &jitterMS)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	} else if err != nil {
+		return 0, fmt.Errorf("error updating dns_delay: %w", err)
+	}
+	if jitterMS > 0 {
+		delayMS += rand.Int64N(jitterMS + 1)
+	}
+	return time.Duration(delayMS) * time.Millisecond, nil
+}
+
+// parseDelay parses a duration such as "1500ms" or "10s".  A bare number is
+// interpreted as seconds.
+func parseDelay(str string) (time.Duration, error) {
+	str = strings.TrimSpace(str)
+	var delay time.Duration
+	if seconds, err := strconv.ParseFloat(str, 64); err == nil {
+		if !(seconds >= 0 && seconds <= maxDNSDelay.Seconds()) {
+			return 0, fmt.Errorf("delay must be between 0 and %s", maxDNSDelay)
+		}
+		delay = time.Duration(seconds * float64(time.Second))
+	} else if delay, err = time.ParseDuration(str); err != nil {
+		return 0, err
+	}
+	if delay < 0 || delay > maxDNSDelay {
+		return 0, fmt.Errorf("delay must be between 0 and %s", maxDNSDelay)
+	}
+	return delay, nil
+}
@@ -0,0 +1,111 @@
+// Copyright (C) 2026 Opsmate, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a
+// copy of this software and associated documentation files (the "Software"),
+// to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense,
+// and/or sell copies of the Software, and to permit persons to whom the
+// Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included
+// in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+// THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+// OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+// ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+// OTHER DEALINGS IN THE SOFTWARE.
+//
+// Except as contained in this notice, the name(s) of the above copyright
+// holders shall not be used in advertising or otherwise to promote the
+// sale, use or other dealings in this Software without prior written
+// authorization.
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	dnsFaultServerFailure = "servfail"
+	dnsFaultRefused       = "refused"
+	dnsFaultDrop          = "drop"
+	dnsFaultTruncate      = "truncate"
+	dnsFaultPad           = "pad"
+)
+
+type dnsFaultKind struct {
+	Name        string
+	Description string
+}
+
+var dnsFaultKinds = []dnsFaultKind{
+	{dnsFaultServerFailure, "Respond with SERVFAIL"},
+	{dnsFaultRefused, "Respond with REFUSED"},
+	{dnsFaultDrop, "Don't respond"},
+	{dnsFaultTruncate, "Set TC bit (UDP only)"},
+	{dnsFaultPad, "Pad response to 1600 bytes"},
+}
+
+// paddedResponseSize is larger than both the 1232 byte EDNS buffer size
+// recommended by DNS Flag Day 2020 and a typical Ethernet MTU
+const paddedResponseSize = 1600
+
+func isValidDNSFault(fault string) bool {
+	return slices.ContainsFunc(dnsFaultKinds, func(k dnsFaultKind) bool { return k.Name == fault })
+}
+
+// matchDNSFault returns the fault to inject into the response to a query, or
+// the empty string if the query should be answered normally.  Each match
+// counts as an attempt against the fault's limit.
+func matchDNSFault(ctx context.Context, testID testID, subdomain string, qtype uint16, isUDP bool) (string, error) {
+	var fault string
+	err := db.QueryRowContext(ctx, `UPDATE dns_fault SET hit_count = hit_count + 1 WHERE dns_fault_id = (SELECT dns_fault_id FROM dns_fault WHERE test_id = ? AND subdomain = ? AND (qtype IS NULL OR qtype = ?) AND (max_count IS NULL OR hit_count < max_count) AND (fault <> ? OR ?) ORDER BY dns_fault_id LIMIT 1) RETURNING fault`, testID[:], subdomain, qtype, dnsFaultTruncate, isUDP).Scan(&fault)
+	if err == sql.ErrNoRows {
+		return "", nil
+	} else if err != nil {
+		return "", fmt.Errorf("error updating dns_fault: %w", err)
+	}
+	return fault, nil
+}
+
+func padDNSResponse(resp *dns.Msg, zone string) {
+	if opt := resp.IsEdns0(); opt != nil {
+		const optionHeaderLen = 4
+		if n := paddedResponseSize - resp.Len() - optionHeaderLen; n > 0 {
+			opt.Option = append(opt.Option, &dns.EDNS0_PADDING{Padding: make([]byte, n)})
+		}
+		return
+	}
+
+	// Queries without EDNS can't receive a padding option, so pad with
+	// a TXT record in the additional section instead
+	txt := &dns.TXT{Hdr: dns.RR_Header{Name: zone, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: 0}}
+	for resp.Len() < paddedResponseSize {
+		txt.Txt = append(txt.Txt, strings.Repeat("x", 255))
+		resp.Extra = []dns.RR{txt}
+	}
+}
+
+func parseQType(str string) (uint16, bool) {
+	str = strings.ToUpper(strings.TrimSpace(str))
+	if qtype, ok := dns.StringToType[str]; ok {
+		return qtype, true
+	}
+	if num, ok := strings.CutPrefix(str, "TYPE"); ok {
+		if qtype, err := strconv.ParseUint(num, 10, 16); err == nil {
+			return uint16(qtype), true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,175 @@
+// Copyright (C) 2026 Opsmate, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a
+// copy of this software and associated documentation files (the "Software"),
+// to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense,
+// and/or sell copies of the Software, and to permit persons to whom the
+// Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included
+// in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+// THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+// OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+// ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+// OTHER DEALINGS IN THE SOFTWARE.
+//
+// Except as contained in this notice, the name(s) of the above copyright
+// holders shall not be used in advertising or otherwise to promote the
+// sale, use or other dealings in this Software without prior written
+// authorization.
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"testing"
+
+	"github.com/miekg/dns"
+	"software.sslmate.com/src/dcv-inspector/schema"
+	"src.agwa.name/go-dbutil/dbschema"
+)
+
+// setTestDB replaces db with a fresh database for the duration of a test
+func setTestDB(t *testing.T) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "test.db")
+	testDB, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?_busy_timeout=5000&_foreign_keys=ON&_txlock=immediate", url.PathEscape(path)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := dbschema.Build(context.Background(), testDB, schema.Files); err != nil {
+		testDB.Close()
+		t.Fatal(err)
+	}
+	oldDB := db
+	db = testDB
+	t.Cleanup(func() {
+		db = oldDB
+		testDB.Close()
+	})
+}
+
+func TestMatchDNSFault(t *testing.T) {
+	setTestDB(t)
+	ctx := context.Background()
+
+	testID := generateTestID()
+	if _, err := db.ExecContext(ctx, `INSERT INTO test (test_id) VALUES (?)`, testID[:]); err != nil {
+		t.Fatal(err)
+	}
+	for _, fault := range []struct {
+		subdomain string
+		qtype     sql.NullInt64
+		fault     string
+		maxCount  sql.NullInt64
+	}{
+		{"www", sql.NullInt64{Int64: int64(dns.TypeA), Valid: true}, dnsFaultServerFailure, sql.NullInt64{Int64: 2, Valid: true}},
+		{"www", sql.NullInt64{}, dnsFaultDrop, sql.NullInt64{}},
+		{"tc", sql.NullInt64{}, dnsFaultTruncate, sql.NullInt64{}},
+		{"tc", sql.NullInt64{}, dnsFaultRefused, sql.NullInt64{Int64: 1, Valid: true}},
+	} {
+		if _, err := db.ExecContext(ctx, `INSERT INTO dns_fault (test_id, subdomain, qtype, fault, max_count) VALUES (?, ?, ?, ?, ?)`, testID[:], fault.subdomain, fault.qtype, fault.fault, fault.maxCount); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Each query counts against the limit of the fault it matches, so the
+	// steps must run in order
+	steps := []struct {
+		subdomain string
+		qtype     uint16
+		isUDP     bool
+		want      string
+	}{
+		{"www", dns.TypeAAAA, true, dnsFaultDrop},
+		{"www", dns.TypeA, true, dnsFaultServerFailure},
+		{"www", dns.TypeA, false, dnsFaultServerFailure},
+		{"www", dns.TypeA, true, dnsFaultDrop},
+		{"other", dns.TypeA, true, ""},
+		{"tc", dns.TypeA, true, dnsFaultTruncate},
+		{"tc", dns.TypeA, false, dnsFaultRefused},
+		{"tc", dns.TypeA, true, dnsFaultTruncate},
+		{"tc", dns.TypeA, false, ""},
+	}
+	for i, step := range steps {
+		got, err := matchDNSFault(ctx, testID, step.subdomain, step.qtype, step.isUDP)
+		if err != nil {
+			t.Fatalf("step %d: unexpected error: %v", i, err)
+		}
+		if got != step.want {
+			t.Fatalf("step %d (%s %s udp=%v): got %q, want %q", i, step.subdomain, dns.TypeToString[step.qtype], step.isUDP, got, step.want)
+		}
+	}
+
+	var hitCount int
+	if err := db.QueryRowContext(ctx, `SELECT hit_count FROM dns_fault WHERE fault = ?`, dnsFaultServerFailure).Scan(&hitCount); err != nil {
+		t.Fatal(err)
+	}
+	if hitCount != 2 {
+		t.Fatalf("servfail hit_count is %d, want 2", hitCount)
+	}
+}
+
+func TestPadDNSResponse(t *testing.T) {
+	const zone = "0123456789abcdef0123456789abcdef.test.example.com."
+
+	for _, edns := range []bool{true, false} {
+		t.Run(fmt.Sprintf("edns=%v", edns), func(t *testing.T) {
+			req := new(dns.Msg)
+			req.SetQuestion("www."+zone, dns.TypeA)
+			resp := new(dns.Msg)
+			resp.SetReply(req)
+			resp.Answer = []dns.RR{mustNewRR(t, "www."+zone+" 15 IN A 192.0.2.1")}
+			if edns {
+				resp.SetEdns0(1232, false)
+			}
+
+			padDNSResponse(resp, zone)
+
+			packed, err := resp.Pack()
+			if err != nil {
+				t.Fatalf("error packing padded response: %v", err)
+			}
+			if edns {
+				if len(packed) != paddedResponseSize {
+					t.Fatalf("padded response is %d bytes, want %d", len(packed), paddedResponseSize)
+				}
+				var padded bool
+				for _, option := range resp.IsEdns0().Option {
+					if _, ok := option.(*dns.EDNS0_PADDING); ok {
+						padded = true
+					}
+				}
+				if !padded {
+					t.Fatalf("response has no padding option")
+				}
+				if len(resp.Extra) != 1 {
+					t.Fatalf("got %d additional records, want only the OPT record", len(resp.Extra))
+				}
+			} else {
+				if len(packed) < paddedResponseSize {
+					t.Fatalf("padded response is %d bytes, want at least %d", len(packed), paddedResponseSize)
+				}
+				if len(resp.Extra) != 1 {
+					t.Fatalf("got %d additional records, want 1", len(resp.Extra))
+				}
+				txt, ok := resp.Extra[0].(*dns.TXT)
+				if !ok || txt.Hdr.Name != zone {
+					t.Fatalf("padding record is %v, want a TXT record at %s", resp.Extra[0], zone)
+				}
+			}
+			if len(resp.Answer) != 1 {
+				t.Fatalf("padding changed the answer: %v", resp.Answer)
+			}
+		})
+	}
+}
@@ -0,0 +1,272 @@
+// Copyright (C) 2026 Opsmate, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a
+// copy of this software and associated documentation files (the "Software"),
+// to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense,
+// and/or sell copies of the Software, and to permit persons to whom the
+// Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included
+// in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+// THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+// OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+// ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+// OTHER DEALINGS IN THE SOFTWARE.
+//
+// Except as contained in this notice, the name(s) of the above copyright
+// holders shall not be used in advertising or otherwise to promote the
+// sale, use or other dealings in this Software without prior written
+// authorization.
+
+package main
+
+import (
+	"context"
+	"crypto"
+	"database/sql"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	dnssecOff               = "off"
+	dnssecValid             = "valid"
+	dnssecExpired           = "expired"
+	dnssecWrongKeyTag       = "wrong_key_tag"
+	dnssecMissingSignatures = "missing_signatures"
+	dnssecBogusDenial       = "bogus_denial"
+)
+
+type dnssecMode struct {
+	Name        string
+	Description string
+}
+
+var dnssecModes = []dnssecMode{
+	{dnssecOff, "Unsigned (no DS in parent)"},
+	{dnssecValid, "Signed"},
+	{dnssecExpired, "Signed, but RRSIGs are expired"},
+	{dnssecWrongKeyTag, "Signed, but RRSIGs have the wrong key tag"},
+	{dnssecMissingSignatures, "DS in parent, but RRSIGs are missing"},
+	{dnssecBogusDenial, "Signed, but denial of existence is bogus"},
+}
+
+func isValidDNSSECMode(mode string) bool {
+	return slices.ContainsFunc(dnssecModes, func(m dnssecMode) bool { return m.Name == mode })
+}
+
+// dnssecKey signs test.<domain> and every test zone beneath it
+var dnssecKey struct {
+	dnskey *dns.DNSKEY
+	signer crypto.Signer
+}
+
+func loadDNSSECKey(ctx context.Context) error {
+	var algorithm uint8
+	var publicKey, privateKey string
+	if err := db.QueryRowContext(ctx, `SELECT algorithm, public_key, private_key FROM dnssec_key`).Scan(&algorithm, &publicKey, &privateKey); err == sql.ErrNoRows {
+		dnskey := makeDNSKEY(".")
+		dnskey.Algorithm = dns.ECDSAP256SHA256
+		key, err := dnskey.Generate(256)
+		if err != nil {
+			return fmt.Errorf("error generating DNSSEC key: %w", err)
+		}
+		algorithm, publicKey, privateKey = dnskey.Algorithm, dnskey.PublicKey, dnskey.PrivateKeyString(key)
+		if _, err := db.ExecContext(ctx, `INSERT INTO dnssec_key (algorithm, public_key, private_key) VALUES (?, ?, ?)`, dnskey.Algorithm, publicKey, privateKey); err != nil {
+			return fmt.Errorf("error inserting dnssec_key: %w", err)
+		}
+	} else if err != nil {
+		return fmt.Errorf("error querying dnssec_key: %w", err)
+	}
+
+	dnskey := makeDNSKEY(".")
+	dnskey.Algorithm = algorithm
+	dnskey.PublicKey = publicKey
+	key, err := dnskey.NewPrivateKey(privateKey)
+	if err != nil {
+		return fmt.Errorf("error parsing DNSSEC private key: %w", err)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return fmt.Errorf("DNSSEC private key is not a crypto.Signer")
+	}
+	dnssecKey.dnskey = dnskey
+	dnssecKey.signer = signer
+	return nil
+}
+
+func makeDNSKEY(zone string) *dns.DNSKEY {
+	dnskey := &dns.DNSKEY{
+		Hdr:      dns.RR_Header{Name: zone, Rrtype: dns.TypeDNSKEY, Class: dns.ClassINET, Ttl: 3600},
+		Flags:    dns.ZONE | dns.SEP,
+		Protocol: 3,
+	}
+	if dnssecKey.dnskey != nil {
+		dnskey.Algorithm = dnssecKey.dnskey.Algorithm
+		dnskey.PublicKey = dnssecKey.dnskey.PublicKey
+	}
+	return dnskey
+}
+
+func makeDS(zone string) *dns.DS {
+	ds := makeDNSKEY(zone).ToDS(dns.SHA256)
+	ds.Hdr.Ttl = 3600
+	return ds
+}
+
+func wantsDNSSEC(req *dns.Msg) bool {
+	opt := req.IsEdns0()
+	return opt != nil && opt.Do()
+}
+
+// signDNSResponse adds RRSIGs to every RRset in resp, according to the
+// given mode.  If resp is a negative response, it is converted into a
+// compact denial of existence (RFC 9824), with typesAtName listing the types
+// which exist at the query name.
+func signDNSResponse(resp *dns.Msg, zone string, mode string, typesAtName []uint16) error {
+	if mode == dnssecOff {
+		return nil
+	}
+
+	if len(resp.Answer) == 0 && (resp.Rcode == dns.RcodeSuccess || resp.Rcode == dns.RcodeNameError) {
+		question := resp.Question[0]
+		nsec := &dns.NSEC{
+			Hdr:        dns.RR_Header{Name: question.Name, Rrtype: dns.TypeNSEC, Class: dns.ClassINET, Ttl: negativeTTL(resp)},
+			NextDomain: "\\000." + question.Name,
+		}
+		if resp.Rcode == dns.RcodeNameError {
+			resp.Rcode = dns.RcodeSuccess
+			nsec.TypeBitMap = []uint16{dns.TypeNXNAME}
+		} else {
+			nsec.TypeBitMap = slices.Clone(typesAtName)
+		}
+		nsec.TypeBitMap = append(nsec.TypeBitMap, dns.TypeRRSIG, dns.TypeNSEC)
+		if mode == dnssecBogusDenial {
+			nsec.TypeBitMap = append(slices.DeleteFunc(nsec.TypeBitMap, func(t uint16) bool { return t == dns.TypeNXNAME }), question.Qtype)
+		}
+		slices.Sort(nsec.TypeBitMap)
+		nsec.TypeBitMap = slices.Compact(nsec.TypeBitMap)
+		resp.Ns = append(resp.Ns, nsec)
+	}
+
+	if mode == dnssecMissingSignatures {
+		return nil
+	}
+
+	var err error
+	if resp.Answer, err = signRRs(resp.Answer, zone, mode); err != nil {
+		return err
+	}
+	if resp.Ns, err = signRRs(resp.Ns, zone, mode); err != nil {
+		return err
+	}
+	return nil
+}
+
+// signReferral signs the DS RRset in a referral, or if the delegation is
+// insecure, adds a signed NSEC record proving that there is no DS RRset (RFC
+// 4035 section 3.1.4).  The NS RRset and glue are not signed, since the
+// delegated zone is authoritative for them.
+func signReferral(resp *dns.Msg, zone string, mode string, nsecTTL uint32) error {
+	if mode == dnssecOff {
+		return nil
+	}
+
+	var ns, ds []dns.RR
+	for _, rr := range resp.Ns {
+		if rr.Header().Rrtype == dns.TypeDS {
+			ds = append(ds, rr)
+		} else {
+			ns = append(ns, rr)
+		}
+	}
+	if len(ds) == 0 {
+		delegation := ns[0].Header().Name
+		nsec := &dns.NSEC{
+			Hdr:        dns.RR_Header{Name: delegation, Rrtype: dns.TypeNSEC, Class: dns.ClassINET, Ttl: nsecTTL},
+			NextDomain: "\\000." + delegation,
+			TypeBitMap: []uint16{dns.TypeNS, dns.TypeRRSIG, dns.TypeNSEC},
+		}
+		if mode == dnssecBogusDenial {
+			nsec.TypeBitMap = []uint16{dns.TypeNS, dns.TypeDS, dns.TypeRRSIG, dns.TypeNSEC}
+		}
+		ds = []dns.RR{nsec}
+	}
+
+	if mode != dnssecMissingSignatures {
+		var err error
+		if ds, err = signRRs(ds, zone, mode); err != nil {
+			return err
+		}
+	}
+	resp.Ns = append(ns, ds...)
+	return nil
+}
+
+func negativeTTL(resp *dns.Msg) uint32 {
+	for _, rr := range resp.Ns {
+		if soa, ok := rr.(*dns.SOA); ok {
+			return min(soa.Hdr.Ttl, soa.Minttl)
+		}
+	}
+	return 0
+}
+
+func signRRs(rrs []dns.RR, zone string, mode string) ([]dns.RR, error) {
+	signed := make([]dns.RR, 0, 2*len(rrs))
+	done := make([]bool, len(rrs))
+	for i, rr := range rrs {
+		if done[i] {
+			continue
+		}
+		var rrset []dns.RR
+		for j := i; j < len(rrs); j++ {
+			if !done[j] && rrs[j].Header().Rrtype == rr.Header().Rrtype && strings.EqualFold(rrs[j].Header().Name, rr.Header().Name) {
+				rrset = append(rrset, rrs[j])
+				done[j] = true
+			}
+		}
+		rrsig, err := makeRRSIG(rrset, zone, mode)
+		if err != nil {
+			return nil, err
+		}
+		signed = append(signed, rrset...)
+		signed = append(signed, rrsig)
+	}
+	return signed, nil
+}
+
+func makeRRSIG(rrset []dns.RR, zone string, mode string) (*dns.RRSIG, error) {
+	now := time.Now()
+	rrsig := &dns.RRSIG{
+		Hdr:        dns.RR_Header{Ttl: rrset[0].Header().Ttl},
+		Algorithm:  dnssecKey.dnskey.Algorithm,
+		KeyTag:     makeDNSKEY(zone).KeyTag(),
+		SignerName: zone,
+		Inception:  uint32(now.Add(-1 * time.Hour).Unix()),
+		Expiration: uint32(now.Add(7 * 24 * time.Hour).Unix()),
+	}
+	switch mode {
+	case dnssecExpired:
+		rrsig.Inception = uint32(now.Add(-14 * 24 * time.Hour).Unix())
+		rrsig.Expiration = uint32(now.Add(-7 * 24 * time.Hour).Unix())
+	case dnssecWrongKeyTag:
+		rrsig.KeyTag++
+		if rrsig.KeyTag == 0 {
+			rrsig.KeyTag = 1
+		}
+	}
+	if err := rrsig.Sign(dnssecKey.signer, rrset); err != nil {
+		return nil, fmt.Errorf("error signing %s %s RRset: %w", rrset[0].Header().Name, dns.Type(rrset[0].Header().Rrtype), err)
+	}
+	return rrsig, nil
+}
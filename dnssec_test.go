@@ -0,0 +1,283 @@
+// Copyright (C) 2026 Opsmate, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a
+// copy of this software and associated documentation files (the "Software"),
+// to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense,
+// and/or sell copies of the Software, and to permit persons to whom the
+// Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included
+// in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+// THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+// OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+// ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+// OTHER DEALINGS IN THE SOFTWARE.
+//
+// Except as contained in this notice, the name(s) of the above copyright
+// holders shall not be used in advertising or otherwise to promote the
+// sale, use or other dealings in this Software without prior written
+// authorization.
+
+package main
+
+import (
+	"crypto"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func setTestDNSSECKey(t *testing.T) {
+	t.Helper()
+	dnskey := &dns.DNSKEY{
+		Hdr:       dns.RR_Header{Name: ".", Rrtype: dns.TypeDNSKEY, Class: dns.ClassINET},
+		Flags:     dns.ZONE | dns.SEP,
+		Protocol:  3,
+		Algorithm: dns.ECDSAP256SHA256,
+	}
+	key, err := dnskey.Generate(256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldDNSKEY, oldSigner := dnssecKey.dnskey, dnssecKey.signer
+	t.Cleanup(func() { dnssecKey.dnskey, dnssecKey.signer = oldDNSKEY, oldSigner })
+	dnssecKey.dnskey = dnskey
+	dnssecKey.signer = key.(crypto.Signer)
+}
+
+const dnssecTestZone = "0123456789abcdef0123456789abcdef.test.example.com."
+
+func makeDNSSECTestResponse(t *testing.T, qname string, qtype uint16, rcode int, answer ...string) *dns.Msg {
+	t.Helper()
+	resp := new(dns.Msg)
+	resp.SetQuestion(qname, qtype)
+	resp.Response = true
+	resp.Rcode = rcode
+	for _, s := range answer {
+		resp.Answer = append(resp.Answer, mustNewRR(t, s))
+	}
+	if len(resp.Answer) == 0 {
+		resp.Ns = append(resp.Ns, makeSOA(dnssecTestZone, 15))
+	}
+	return resp
+}
+
+// verifyRRSIGs checks every RRSIG in rrs against the zone's DNSKEY, and
+// returns the RRsets which are covered by a valid, current signature
+func verifyRRSIGs(t *testing.T, rrs []dns.RR) (map[uint16]bool, error) {
+	t.Helper()
+	dnskey := makeDNSKEY(dnssecTestZone)
+	covered := make(map[uint16]bool)
+	for _, rr := range rrs {
+		rrsig, ok := rr.(*dns.RRSIG)
+		if !ok {
+			continue
+		}
+		var rrset []dns.RR
+		for _, other := range rrs {
+			if other.Header().Rrtype == rrsig.TypeCovered && other.Header().Name == rrsig.Hdr.Name {
+				rrset = append(rrset, other)
+			}
+		}
+		if err := rrsig.Verify(dnskey, rrset); err != nil {
+			return covered, err
+		}
+		if !rrsig.ValidityPeriod(time.Now()) {
+			return covered, errRRSIGExpired
+		}
+		covered[rrsig.TypeCovered] = true
+	}
+	return covered, nil
+}
+
+var errRRSIGExpired = errors.New("RRSIG is outside its validity period")
+
+func findNSEC(rrs []dns.RR) *dns.NSEC {
+	for _, rr := range rrs {
+		if nsec, ok := rr.(*dns.NSEC); ok {
+			return nsec
+		}
+	}
+	return nil
+}
+
+func TestSignDNSResponse(t *testing.T) {
+	setTestServerGlobals(t)
+	setTestDNSSECKey(t)
+	www := "www." + dnssecTestZone
+
+	t.Run("positive", func(t *testing.T) {
+		resp := makeDNSSECTestResponse(t, www, dns.TypeA, dns.RcodeSuccess, www+" 3600 IN A 192.0.2.1", www+" 3600 IN A 192.0.2.2")
+		if err := signDNSResponse(resp, dnssecTestZone, dnssecValid, nil); err != nil {
+			t.Fatal(err)
+		}
+		covered, err := verifyRRSIGs(t, resp.Answer)
+		if err != nil {
+			t.Fatalf("RRSIG does not verify: %v", err)
+		}
+		if !covered[dns.TypeA] {
+			t.Fatalf("A RRset is not signed: %v", resp.Answer)
+		}
+		if len(resp.Answer) != 3 {
+			t.Fatalf("expected one RRSIG covering both A records, got %v", resp.Answer)
+		}
+	})
+
+	tests := []struct {
+		name        string
+		rcode       int
+		typesAtName []uint16
+		mode        string
+		wantBitmap  []uint16
+	}{
+		{name: "NXDOMAIN", rcode: dns.RcodeNameError, mode: dnssecValid, wantBitmap: []uint16{dns.TypeRRSIG, dns.TypeNSEC, dns.TypeNXNAME}},
+		{name: "NODATA", rcode: dns.RcodeSuccess, typesAtName: []uint16{dns.TypeMX, dns.TypeA}, mode: dnssecValid, wantBitmap: []uint16{dns.TypeA, dns.TypeMX, dns.TypeRRSIG, dns.TypeNSEC}},
+		{name: "bogus NXDOMAIN", rcode: dns.RcodeNameError, mode: dnssecBogusDenial, wantBitmap: []uint16{dns.TypeTXT, dns.TypeRRSIG, dns.TypeNSEC}},
+		{name: "bogus NODATA", rcode: dns.RcodeSuccess, typesAtName: []uint16{dns.TypeA}, mode: dnssecBogusDenial, wantBitmap: []uint16{dns.TypeA, dns.TypeTXT, dns.TypeRRSIG, dns.TypeNSEC}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := makeDNSSECTestResponse(t, www, dns.TypeTXT, tt.rcode)
+			if err := signDNSResponse(resp, dnssecTestZone, tt.mode, tt.typesAtName); err != nil {
+				t.Fatal(err)
+			}
+			if resp.Rcode != dns.RcodeSuccess {
+				t.Errorf("compact denial should have rcode NOERROR, got %s", dns.RcodeToString[resp.Rcode])
+			}
+			nsec := findNSEC(resp.Ns)
+			if nsec == nil {
+				t.Fatalf("no NSEC in authority section: %v", resp.Ns)
+			}
+			if nsec.Hdr.Name != www || nsec.NextDomain != "\\000."+www {
+				t.Errorf("NSEC %v does not cover only %s", nsec, www)
+			}
+			if !slices.Equal(nsec.TypeBitMap, tt.wantBitmap) {
+				t.Errorf("got type bitmap %v, want %v", nsec.TypeBitMap, tt.wantBitmap)
+			}
+			if nsec.Hdr.Ttl != 15 {
+				t.Errorf("NSEC TTL is %d, want the negative TTL", nsec.Hdr.Ttl)
+			}
+			covered, err := verifyRRSIGs(t, resp.Ns)
+			if err != nil {
+				t.Fatalf("RRSIG does not verify: %v", err)
+			}
+			if !covered[dns.TypeNSEC] || !covered[dns.TypeSOA] {
+				t.Errorf("authority section is not fully signed: %v", resp.Ns)
+			}
+		})
+	}
+}
+
+func TestSignDNSResponseBrokenModes(t *testing.T) {
+	setTestServerGlobals(t)
+	setTestDNSSECKey(t)
+	www := "www." + dnssecTestZone
+
+	t.Run(dnssecOff, func(t *testing.T) {
+		resp := makeDNSSECTestResponse(t, www, dns.TypeTXT, dns.RcodeNameError)
+		if err := signDNSResponse(resp, dnssecTestZone, dnssecOff, nil); err != nil {
+			t.Fatal(err)
+		}
+		if resp.Rcode != dns.RcodeNameError || len(resp.Ns) != 1 {
+			t.Fatalf("unsigned response was modified: %v", resp)
+		}
+	})
+
+	t.Run(dnssecExpired, func(t *testing.T) {
+		resp := makeDNSSECTestResponse(t, www, dns.TypeA, dns.RcodeSuccess, www+" 3600 IN A 192.0.2.1")
+		if err := signDNSResponse(resp, dnssecTestZone, dnssecExpired, nil); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := verifyRRSIGs(t, resp.Answer); err != errRRSIGExpired {
+			t.Fatalf("expected an expired RRSIG, got %v", err)
+		}
+	})
+
+	t.Run(dnssecWrongKeyTag, func(t *testing.T) {
+		resp := makeDNSSECTestResponse(t, www, dns.TypeA, dns.RcodeSuccess, www+" 3600 IN A 192.0.2.1")
+		if err := signDNSResponse(resp, dnssecTestZone, dnssecWrongKeyTag, nil); err != nil {
+			t.Fatal(err)
+		}
+		rrsig := resp.Answer[len(resp.Answer)-1].(*dns.RRSIG)
+		if rrsig.KeyTag == makeDNSKEY(dnssecTestZone).KeyTag() {
+			t.Fatalf("RRSIG has the DNSKEY's key tag")
+		}
+		if _, err := verifyRRSIGs(t, resp.Answer); err == nil {
+			t.Fatalf("RRSIG with the wrong key tag verified")
+		}
+	})
+
+	t.Run(dnssecMissingSignatures, func(t *testing.T) {
+		resp := makeDNSSECTestResponse(t, www, dns.TypeTXT, dns.RcodeNameError)
+		if err := signDNSResponse(resp, dnssecTestZone, dnssecMissingSignatures, nil); err != nil {
+			t.Fatal(err)
+		}
+		if findNSEC(resp.Ns) == nil {
+			t.Errorf("expected an unsigned NSEC")
+		}
+		for _, rr := range append(resp.Answer, resp.Ns...) {
+			if rr.Header().Rrtype == dns.TypeRRSIG {
+				t.Fatalf("unexpected RRSIG %v", rr)
+			}
+		}
+	})
+}
+
+func TestSignReferral(t *testing.T) {
+	setTestDNSSECKey(t)
+	sub := "sub." + dnssecTestZone
+
+	tests := []struct {
+		name       string
+		mode       string
+		ds         bool
+		wantBitmap []uint16 // nil if the DS RRset should be signed instead
+		wantRRSIG  bool
+	}{
+		{name: "secure", mode: dnssecValid, ds: true, wantRRSIG: true},
+		{name: "insecure", mode: dnssecValid, wantBitmap: []uint16{dns.TypeNS, dns.TypeRRSIG, dns.TypeNSEC}, wantRRSIG: true},
+		{name: "bogus insecure", mode: dnssecBogusDenial, wantBitmap: []uint16{dns.TypeNS, dns.TypeDS, dns.TypeRRSIG, dns.TypeNSEC}, wantRRSIG: true},
+		{name: "missing signatures", mode: dnssecMissingSignatures, wantBitmap: []uint16{dns.TypeNS, dns.TypeRRSIG, dns.TypeNSEC}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := new(dns.Msg)
+			resp.SetQuestion("www."+sub, dns.TypeA)
+			resp.Ns = []dns.RR{mustNewRR(t, sub+" 3600 IN NS ns1.example.net.")}
+			if tt.ds {
+				resp.Ns = append(resp.Ns, mustNewRR(t, sub+" 3600 IN DS 12345 13 2 0000000000000000000000000000000000000000000000000000000000000000"))
+			}
+			if err := signReferral(resp, dnssecTestZone, tt.mode, 15); err != nil {
+				t.Fatal(err)
+			}
+			nsec := findNSEC(resp.Ns)
+			if tt.wantBitmap == nil {
+				if nsec != nil {
+					t.Errorf("unexpected NSEC in secure referral: %v", nsec)
+				}
+			} else if nsec == nil {
+				t.Fatalf("no NSEC proving the absence of DS: %v", resp.Ns)
+			} else if !slices.Equal(nsec.TypeBitMap, tt.wantBitmap) {
+				t.Errorf("got type bitmap %v, want %v", nsec.TypeBitMap, tt.wantBitmap)
+			}
+			covered, err := verifyRRSIGs(t, resp.Ns)
+			if err != nil {
+				t.Fatalf("RRSIG does not verify: %v", err)
+			}
+			if covered[dns.TypeNS] {
+				t.Errorf("NS RRset in referral should not be signed")
+			}
+			if got := covered[dns.TypeDS] || covered[dns.TypeNSEC]; got != tt.wantRRSIG {
+				t.Errorf("DS/NSEC signed = %v, want %v", got, tt.wantRRSIG)
+			}
+		})
+	}
+}
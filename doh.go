@@ -0,0 +1,156 @@
+// Copyright (C) 2026 Opsmate, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a
+// copy of this software and associated documentation files (the "Software"),
+// to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense,
+// and/or sell copies of the Software, and to permit persons to whom the
+// Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included
+// in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+// THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+// OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+// ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+// OTHER DEALINGS IN THE SOFTWARE.
+//
+// Except as contained in this notice, the name(s) of the above copyright
+// holders shall not be used in advertising or otherwise to promote the
+// sale, use or other dealings in this Software without prior written
+// authorization.
+
+package main
+
+import (
+	"crypto/tls"
+	"encoding/base64"
+	"io"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"net/netip"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// dohPath is the path at which DNS over HTTPS (RFC 8484) is served
+const dohPath = "/dns-query"
+
+// bufferedDNSWriter is a dns.ResponseWriter for transports which aren't
+// implemented by miekg/dns.  It captures the response so the caller can
+// send it.
+type bufferedDNSWriter struct {
+	transport  string
+	localAddr  net.Addr
+	remoteAddr net.Addr
+	response   []byte
+}
+
+func (w *bufferedDNSWriter) LocalAddr() net.Addr  { return w.localAddr }
+func (w *bufferedDNSWriter) RemoteAddr() net.Addr { return w.remoteAddr }
+func (w *bufferedDNSWriter) WriteMsg(msg *dns.Msg) error {
+	msgBytes, err := msg.Pack()
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(msgBytes)
+	return err
+}
+func (w *bufferedDNSWriter) Write(msgBytes []byte) (int, error) {
+	w.response = append([]byte(nil), msgBytes...)
+	return len(msgBytes), nil
+}
+func (w *bufferedDNSWriter) Close() error        { return nil }
+func (w *bufferedDNSWriter) TsigStatus() error   { return nil }
+func (w *bufferedDNSWriter) TsigTimersOnly(bool) {}
+func (w *bufferedDNSWriter) Hijack()             {}
+
+func readDoHQuery(r *http.Request) ([]byte, int, string) {
+	switch r.Method {
+	case http.MethodGet:
+		query, err := base64.RawURLEncoding.DecodeString(r.URL.Query().Get("dns"))
+		if err != nil || len(query) == 0 {
+			return nil, http.StatusBadRequest, "dns parameter is missing or invalid"
+		}
+		return query, 0, ""
+	case http.MethodPost:
+		if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/dns-message" {
+			return nil, http.StatusUnsupportedMediaType, "Content-Type must be application/dns-message"
+		}
+		query, err := io.ReadAll(io.LimitReader(r.Body, dns.MaxMsgSize+1))
+		if err != nil {
+			return nil, http.StatusBadRequest, "error reading request body: " + err.Error()
+		} else if len(query) > dns.MaxMsgSize {
+			return nil, http.StatusRequestEntityTooLarge, "DNS message is too large"
+		}
+		return query, 0, ""
+	default:
+		return nil, http.StatusMethodNotAllowed, "method not allowed"
+	}
+}
+
+func serveDoH(w http.ResponseWriter, r *http.Request) {
+	query, status, message := readDoHQuery(r)
+	if query == nil {
+		http.Error(w, message, status)
+		return
+	}
+	req := new(dns.Msg)
+	if err := req.Unpack(query); err != nil {
+		http.Error(w, "error unpacking DNS message: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	remoteAddr, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err != nil {
+		http.Error(w, "error parsing remote address: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	dnsWriter := &bufferedDNSWriter{
+		transport:  "https",
+		remoteAddr: net.TCPAddrFromAddrPort(remoteAddr),
+	}
+	if localAddr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+		dnsWriter.localAddr = localAddr
+	}
+	// Leave room for a configured response delay beyond the server's write timeout
+	http.NewResponseController(w).SetWriteDeadline(time.Now().Add(maxDNSDelay + 15*time.Second))
+	serveDNS(dnsWriter, req)
+	if dnsWriter.response == nil {
+		// A fault dropped the response; the closest HTTP equivalent is a timeout
+		http.Error(w, "no DNS response", http.StatusGatewayTimeout)
+		return
+	}
+	w.Header().Set("Content-Type", "application/dns-message")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	w.Write(dnsWriter.response)
+}
+
+func serveDoHOnly(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != dohPath {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	serveDoH(w, r)
+}
+
+func runDoHServer(l net.Listener) {
+	server := http.Server{
+		ReadTimeout:  5 * time.Second,
+		WriteTimeout: 15 * time.Second,
+		IdleTimeout:  30 * time.Second,
+		Handler:      http.HandlerFunc(serveDoHOnly),
+		ErrorLog:     log.New(httpServerLogWriter{}, "", 0),
+	}
+	log.Fatal(server.Serve(tls.NewListener(l, &tls.Config{
+		GetCertificate: getDNSCertificate,
+		NextProtos:     []string{"h2", "http/1.1"},
+		MinVersion:     tls.VersionTLS12,
+	})))
+}
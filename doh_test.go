@@ -0,0 +1,200 @@
+// Copyright (C) 2026 Opsmate, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a
+// copy of this software and associated documentation files (the "Software"),
+// to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense,
+// and/or sell copies of the Software, and to permit persons to whom the
+// Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included
+// in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+// THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+// OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+// ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+// OTHER DEALINGS IN THE SOFTWARE.
+//
+// Except as contained in this notice, the name(s) of the above copyright
+// holders shall not be used in advertising or otherwise to promote the
+// sale, use or other dealings in this Software without prior written
+// authorization.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestBufferedDNSWriter(t *testing.T) {
+	localAddr := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 443}
+	remoteAddr := &net.TCPAddr{IP: net.ParseIP("198.51.100.1"), Port: 12345}
+	w := &bufferedDNSWriter{transport: "https", localAddr: localAddr, remoteAddr: remoteAddr}
+	if w.LocalAddr() != localAddr || w.RemoteAddr() != remoteAddr {
+		t.Fatalf("got addresses %v and %v, want %v and %v", w.LocalAddr(), w.RemoteAddr(), localAddr, remoteAddr)
+	}
+	if transport := dnsTransport(w); transport != "https" {
+		t.Fatalf("got transport %q, want %q", transport, "https")
+	}
+
+	msg := new(dns.Msg)
+	msg.SetQuestion("example.com.", dns.TypeA)
+	if err := w.WriteMsg(msg); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	packed, err := msg.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(w.response, packed) {
+		t.Fatalf("WriteMsg captured %x, want %x", w.response, packed)
+	}
+
+	// Write must copy, since the caller may reuse its buffer
+	buf := []byte{1, 2, 3}
+	if n, err := w.Write(buf); n != len(buf) || err != nil {
+		t.Fatalf("Write returned %d, %v", n, err)
+	}
+	buf[0] = 0
+	if !bytes.Equal(w.response, []byte{1, 2, 3}) {
+		t.Fatalf("Write captured %x, want 010203", w.response)
+	}
+}
+
+func makeDoHQuery(t *testing.T, name string) []byte {
+	t.Helper()
+	msg := new(dns.Msg)
+	msg.SetQuestion(name, dns.TypeA)
+	msg.Id = 0
+	query, err := msg.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return query
+}
+
+func TestReadDoHQuery(t *testing.T) {
+	query := makeDoHQuery(t, "example.com.")
+	encoded := base64.RawURLEncoding.EncodeToString(query)
+
+	tests := []struct {
+		name        string
+		method      string
+		url         string
+		contentType string
+		body        []byte
+		wantStatus  int
+	}{
+		{name: "GET", method: http.MethodGet, url: dohPath + "?dns=" + encoded},
+		{name: "GET padded", method: http.MethodGet, url: dohPath + "?dns=" + base64.URLEncoding.EncodeToString(query) + "%3D", wantStatus: http.StatusBadRequest},
+		{name: "GET standard alphabet", method: http.MethodGet, url: dohPath + "?dns=" + strings.ReplaceAll(encoded, "A", "+"), wantStatus: http.StatusBadRequest},
+		{name: "GET bad base64", method: http.MethodGet, url: dohPath + "?dns=!!!", wantStatus: http.StatusBadRequest},
+		{name: "GET missing", method: http.MethodGet, url: dohPath, wantStatus: http.StatusBadRequest},
+		{name: "POST", method: http.MethodPost, url: dohPath, contentType: "application/dns-message", body: query},
+		{name: "POST with parameters", method: http.MethodPost, url: dohPath, contentType: "Application/DNS-Message; charset=binary", body: query},
+		{name: "POST wrong content type", method: http.MethodPost, url: dohPath, contentType: "application/octet-stream", body: query, wantStatus: http.StatusUnsupportedMediaType},
+		{name: "POST form", method: http.MethodPost, url: dohPath, contentType: "application/x-www-form-urlencoded", body: []byte("dns=" + encoded), wantStatus: http.StatusUnsupportedMediaType},
+		{name: "POST no content type", method: http.MethodPost, url: dohPath, body: query, wantStatus: http.StatusUnsupportedMediaType},
+		{name: "POST too large", method: http.MethodPost, url: dohPath, contentType: "application/dns-message", body: make([]byte, dns.MaxMsgSize+1), wantStatus: http.StatusRequestEntityTooLarge},
+		{name: "PUT", method: http.MethodPut, url: dohPath, contentType: "application/dns-message", body: query, wantStatus: http.StatusMethodNotAllowed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.url, bytes.NewReader(tt.body))
+			if tt.contentType != "" {
+				r.Header.Set("Content-Type", tt.contentType)
+			}
+			got, status, message := readDoHQuery(r)
+			if tt.wantStatus != 0 {
+				if got != nil || status != tt.wantStatus || message == "" {
+					t.Fatalf("got %x, %d, %q; want status %d", got, status, message, tt.wantStatus)
+				}
+				return
+			}
+			if got == nil {
+				t.Fatalf("unexpected error: %d %s", status, message)
+			}
+			if !bytes.Equal(got, query) {
+				t.Fatalf("got %x, want %x", got, query)
+			}
+		})
+	}
+}
+
+func TestServeDoH(t *testing.T) {
+	setTestServerGlobals(t)
+	setTestDB(t)
+	ctx := context.Background()
+
+	testID := generateTestID()
+	if _, err := db.ExecContext(ctx, `INSERT INTO test (test_id) VALUES (?)`, testID[:]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.ExecContext(ctx, `INSERT INTO dns_fault (test_id, subdomain, fault) VALUES (?, ?, ?)`, testID[:], "dropped", dnsFaultDrop); err != nil {
+		t.Fatal(err)
+	}
+
+	serve := func(name string) *httptest.ResponseRecorder {
+		query := makeDoHQuery(t, dns.Fqdn(makeHostname(testID, name)))
+		r := httptest.NewRequest(http.MethodPost, dohPath, bytes.NewReader(query))
+		r.Header.Set("Content-Type", "application/dns-message")
+		r.RemoteAddr = "198.51.100.1:12345"
+		w := httptest.NewRecorder()
+		serveDoH(w, r)
+		return w
+	}
+
+	t.Run("answered", func(t *testing.T) {
+		w := serve("www")
+		if w.Code != http.StatusOK {
+			t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+		}
+		if contentType := w.Header().Get("Content-Type"); contentType != "application/dns-message" {
+			t.Fatalf("got Content-Type %q, want application/dns-message", contentType)
+		}
+		resp := new(dns.Msg)
+		if err := resp.Unpack(w.Body.Bytes()); err != nil {
+			t.Fatalf("error unpacking response: %v", err)
+		}
+		if resp.Rcode != dns.RcodeSuccess || len(resp.Answer) != 1 {
+			t.Fatalf("got response %v, want one answer", resp)
+		}
+	})
+
+	t.Run("dropped", func(t *testing.T) {
+		w := serve("dropped")
+		if w.Code != http.StatusGatewayTimeout {
+			t.Fatalf("got status %d, want %d", w.Code, http.StatusGatewayTimeout)
+		}
+	})
+
+	var transports []string
+	rows, err := db.QueryContext(ctx, `SELECT transport FROM dns_request WHERE test_id = ? ORDER BY dns_request_id`, testID[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var transport string
+		if err := rows.Scan(&transport); err != nil {
+			t.Fatal(err)
+		}
+		transports = append(transports, transport)
+	}
+	if len(transports) != 2 || transports[0] != "https" || transports[1] != "https" {
+		t.Fatalf("recorded transports %q, want two https queries", transports)
+	}
+}
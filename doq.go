@@ -0,0 +1,125 @@
+// Copyright (C) 2026 Opsmate, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a
+// copy of this software and associated documentation files (the "Software"),
+// to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense,
+// and/or sell copies of the Software, and to permit persons to whom the
+// Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included
+// in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+// THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+// OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+// ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+// OTHER DEALINGS IN THE SOFTWARE.
+//
+// Except as contained in this notice, the name(s) of the above copyright
+// holders shall not be used in advertising or otherwise to promote the
+// sale, use or other dealings in this Software without prior written
+// authorization.
+
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/quic-go/quic-go"
+)
+
+// Error codes from RFC 9250 section 8.4
+const (
+	doqProtocolError    = 0x2
+	doqRequestCancelled = 0x3
+)
+
+// runDoQServer serves DNS over QUIC (RFC 9250)
+func runDoQServer(p net.PacketConn) {
+	l, err := quic.Listen(p, &tls.Config{
+		GetCertificate: getDNSCertificate,
+		NextProtos:     []string{"doq"},
+		MinVersion:     tls.VersionTLS13,
+	}, &quic.Config{
+		MaxIdleTimeout:     maxDNSDelay + 30*time.Second,
+		MaxIncomingStreams: 100,
+	})
+	if err != nil {
+		log.Fatalf("error starting DNS over QUIC server: %s", err)
+	}
+	for {
+		conn, err := l.Accept(context.Background())
+		if err != nil {
+			log.Fatalf("error accepting DNS over QUIC connection: %s", err)
+		}
+		go serveDoQConn(conn)
+	}
+}
+
+func serveDoQConn(conn *quic.Conn) {
+	for {
+		stream, err := conn.AcceptStream(context.Background())
+		if err != nil {
+			return
+		}
+		go serveDoQStream(conn, stream)
+	}
+}
+
+// unpackDoQQuery unpacks a query received over DNS over QUIC
+func unpackDoQQuery(query []byte) (*dns.Msg, error) {
+	req := new(dns.Msg)
+	if err := req.Unpack(query); err != nil {
+		return nil, fmt.Errorf("invalid DNS query: %w", err)
+	}
+	if req.Id != 0 {
+		// RFC 9250 section 4.2.1: the Message ID must be 0
+		return nil, fmt.Errorf("DNS query has non-zero Message ID %d", req.Id)
+	}
+	return req, nil
+}
+
+func serveDoQStream(conn *quic.Conn, stream *quic.Stream) {
+	stream.SetReadDeadline(time.Now().Add(10 * time.Second))
+	var length uint16
+	if err := binary.Read(stream, binary.BigEndian, &length); err != nil {
+		stream.CancelRead(doqProtocolError)
+		stream.CancelWrite(doqProtocolError)
+		return
+	}
+	query := make([]byte, length)
+	if _, err := io.ReadFull(stream, query); err != nil {
+		stream.CancelRead(doqProtocolError)
+		stream.CancelWrite(doqProtocolError)
+		return
+	}
+
+	req, err := unpackDoQQuery(query)
+	if err != nil {
+		conn.CloseWithError(doqProtocolError, err.Error())
+		return
+	}
+	dnsWriter := &bufferedDNSWriter{
+		transport:  "quic",
+		localAddr:  conn.LocalAddr(),
+		remoteAddr: conn.RemoteAddr(),
+	}
+	serveDNS(dnsWriter, req)
+	if dnsWriter.response == nil {
+		stream.CancelWrite(doqRequestCancelled)
+		return
+	}
+	stream.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(dnsWriter.response))), dnsWriter.response...))
+	stream.Close()
+}
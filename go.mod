@@ -7,6 +7,8 @@ require (
 	github.com/kentik/patricia v1.2.1
 	github.com/mattn/go-sqlite3 v1.14.24
 	github.com/miekg/dns v1.1.68
+	github.com/quic-go/quic-go v0.59.1
+	golang.org/x/net v0.55.0
 	src.agwa.name/go-dbutil v0.8.1
 	src.agwa.name/go-listener v0.7.0
 )
@@ -14,9 +16,9 @@ require (
 require (
 	github.com/emersion/go-sasl v0.0.0-20200509203442-7bfe0ed36a21 // indirect
 	github.com/lib/pq v1.10.9 // indirect
+	github.com/quic-go/qpack v0.6.0 // indirect
 	golang.org/x/crypto v0.52.0 // indirect
 	golang.org/x/mod v0.36.0 // indirect
-	golang.org/x/net v0.55.0 // indirect
 	golang.org/x/sync v0.20.0 // indirect
 	golang.org/x/sys v0.45.0 // indirect
 	golang.org/x/text v0.37.0 // indirect
@@ -30,10 +30,12 @@ import (
 	"crypto/tls"
 	"database/sql"
 	"fmt"
+	"io"
 	"log"
 	"net"
 	"net/http"
 	"net/netip"
+	"strconv"
 	"strings"
 	"time"
 
@@ -54,18 +56,31 @@ func (httpServerLogWriter) Write(p []byte) (int, error) {
 }
 
 func getHTTPSConfig(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+	var captured *helloCapturingConn
+	if conn, ok := hello.Conn.(*helloCapturingConn); ok {
+		captured = conn
+		defer conn.takeCaptured()
+	}
+
 	if hello.ServerName == domain {
 		return &tls.Config{
 			GetCertificate: getHTTPSCertificate,
-			NextProtos:     []string{"h2", "http/1.1", "acme-tls/1"},
+			NextProtos:     []string{"h2", "http/1.1", acmeTLSALPNProtocol},
 			MinVersion:     tls.VersionTLS13,
 		}, nil
-	} else if _, _, ok := parseHostname(hello.ServerName); ok && !strings.HasPrefix(hello.ServerName, "_") {
-		return &tls.Config{
-			GetCertificate: getSelfSignedCert,
-			NextProtos:     []string{"h2", "http/1.1"},
-			MinVersion:     tls.VersionTLS10,
-		}, nil
+	} else if testID, subdomain, ok := parseHostname(hello.ServerName); ok && !strings.HasPrefix(hello.ServerName, "_") {
+		settings, err := loadTestTLSSettings(hello.Context(), testID)
+		if err != nil {
+			return nil, err
+		}
+		if captured != nil && settings.running {
+			certMode := settings.mode
+			if isTLSALPNChallengeHello(hello) {
+				certMode = tlsCertTLSALPNChallenge
+			}
+			recordTestClientHello(hello.Context(), testID, captured, certMode)
+		}
+		return settings.config(testID, subdomain), nil
 	} else {
 		return nil, fmt.Errorf("unrecognized server name")
 	}
@@ -106,6 +121,54 @@ func requestScheme(r *http.Request) string {
 	}
 }
 
+// localAddressFamily returns the address family of the local address
+// which the client connected to, which indicates whether it followed a
+// synthesized A or AAAA record
+func localAddressFamily(r *http.Request) string {
+	localAddr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr)
+	if !ok {
+		return ""
+	}
+	addrPort, err := netip.ParseAddrPort(localAddr.String())
+	if err != nil {
+		return ""
+	}
+	if addrPort.Addr().Unmap().Is4() {
+		return "IPv4"
+	} else {
+		return "IPv6"
+	}
+}
+
+// httpBodyLimit is the number of bytes of each request body to record
+var httpBodyLimit int64 = 64 * 1024
+
+// readHTTPBody returns up to httpBodyLimit bytes of the request body, and
+// whether it was truncated.  The rest of the body is read and discarded, so
+// that trailers are available afterwards.
+func readHTTPBody(r *http.Request) ([]byte, bool) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, httpBodyLimit+1))
+	if err != nil {
+		return body, false
+	}
+	if int64(len(body)) > httpBodyLimit {
+		io.Copy(io.Discard, r.Body)
+		return body[:httpBodyLimit], true
+	}
+	return body, false
+}
+
+// requestHostHeader returns the Host header as sent by the client.  net/http
+// removes it from r.Header, but r.Host contains it verbatim unless the request
+// target is in absolute-form, in which case the header is ignored (RFC 9112
+// section 3.2.2) and unavailable.  HTTP/2 requests use :authority instead.
+func requestHostHeader(r *http.Request) sql.NullString {
+	if r.ProtoMajor == 1 && r.URL.Host == "" {
+		return sql.NullString{String: r.Host, Valid: true}
+	}
+	return sql.NullString{}
+}
+
 func serveTestHTTP(ctx context.Context, testID testID, subdomain string, w http.ResponseWriter, r *http.Request) error {
 	remoteAddr, err := netip.ParseAddrPort(r.RemoteAddr)
 	if err != nil {
@@ -120,19 +183,61 @@ func serveTestHTTP(ctx context.Context, testID testID, subdomain string, w http.
 		return nil
 	}
 
-	var content string
-	if err := db.QueryRowContext(ctx, `SELECT content FROM http_file WHERE test_id = ? AND scheme = ? AND subdomain = ? AND path = ?`, testID[:], requestScheme(r), subdomain, r.URL.Path).Scan(&content); err != nil && err != sql.ErrNoRows {
-		return fmt.Errorf("serveTestHTTP: error querying http_file row: %w", err)
+	syntheses, err := loadDNSSyntheses(ctx, testID)
+	if err != nil {
+		return fmt.Errorf("serveTestHTTP: %w", err)
 	}
+	synthesis := matchDNSSynthesis(syntheses, subdomain)
+	addressFamily := localAddressFamily(r)
+	body, bodyTruncated := readHTTPBody(r)
 
-	if _, err := db.ExecContext(ctx, `INSERT INTO http_request (test_id, remote_ip, remote_port, host, method, url, proto, header_json, https) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`, testID[:], remoteAddr.Addr().String(), remoteAddr.Port(), r.Host, r.Method, r.URL.String(), r.Proto, dbutil.JSON(r.Header), r.TLS != nil); err != nil {
-		return fmt.Errorf("serveTestHTTP: error inserting http_request for test %v: %w", testID, err)
+	file, err := findHTTPFile(ctx, testID, requestScheme(r), subdomain, r.URL.Path)
+	if err != nil {
+		return fmt.Errorf("serveTestHTTP: %w", err)
 	}
-
+	content := []byte{}
+	status := http.StatusOK
+	autoAnswered := false
 	w.Header().Set("Content-Type", "application/octet-stream")
+	if http3Port != 0 {
+		w.Header().Set("Alt-Svc", http3AltSvc())
+	}
+	if file != nil {
+		content = []byte(file.Content)
+		status = file.Status
+		for name, values := range file.Header {
+			w.Header()[name] = values
+		}
+		if file.ContentType != nil {
+			w.Header().Set("Content-Type", *file.ContentType)
+		}
+		if file.Location != nil {
+			w.Header().Set("Location", *file.Location)
+		}
+	} else if strings.HasPrefix(r.URL.Path, acmeChallengePathPrefix) {
+		thumbprint, err := loadACMEThumbprint(ctx, testID)
+		if err != nil {
+			return fmt.Errorf("serveTestHTTP: %w", err)
+		}
+		if keyAuthorization, ok := acmeKeyAuthorization(r.URL.Path, thumbprint); ok && thumbprint != "" {
+			content = []byte(keyAuthorization)
+			autoAnswered = true
+		}
+	}
+	if responseHasContent(r.Method, status) {
+		w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+	} else {
+		content = []byte{}
+	}
 	w.Header().Set("X-Content-Type-Options", "nosniff")
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte(content))
+	quicInfo := requestQUICDetails(ctx)
+
+	if _, err := db.ExecContext(ctx, `INSERT INTO http_request (test_id, remote_ip, remote_port, host, method, url, proto, header_json, https, response_status, response_header_json, response_body, address_family, synthesis, request_uri, host_header, body, body_truncated, trailer_json, tls_client_hello_id, acme_auto_answered, quic_version, quic_used_0rtt, quic_datagrams) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`, testID[:], remoteAddr.Addr().String(), remoteAddr.Port(), r.Host, r.Method, r.URL.String(), r.Proto, dbutil.JSON(r.Header), r.TLS != nil, status, dbutil.JSON(w.Header()), content, nullString(addressFamily), synthesis.Label(), r.RequestURI, requestHostHeader(r), body, bodyTruncated, dbutil.JSON(r.Trailer), requestClientHelloID(ctx), autoAnswered, quicInfo.version, quicInfo.used0RTT, quicInfo.datagrams); err != nil {
+		return fmt.Errorf("serveTestHTTP: error inserting http_request for test %v: %w", testID, err)
+	}
+
+	w.WriteHeader(status)
+	w.Write(content)
 	return nil
 }
 
@@ -143,9 +248,10 @@ func runHTTPServer(l net.Listener) {
 		IdleTimeout:  3 * time.Second,
 		Handler:      http.HandlerFunc(serveHTTP),
 		ErrorLog:     log.New(httpServerLogWriter{}, "", 0),
+		ConnContext:  withHelloCapturingConn,
 	}
 	log.Fatal(server.Serve(l))
 }
 func runHTTPSServer(l net.Listener) {
-	runHTTPServer(tls.NewListener(l, &tls.Config{GetConfigForClient: getHTTPSConfig}))
+	runHTTPServer(tls.NewListener(helloCapturingListener{l}, &tls.Config{GetConfigForClient: getHTTPSConfig}))
 }
@@ -0,0 +1,107 @@
+// Copyright (C) 2026 Opsmate, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a
+// copy of this software and associated documentation files (the "Software"),
+// to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense,
+// and/or sell copies of the Software, and to permit persons to whom the
+// Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included
+// in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+// THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+// OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+// ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+// OTHER DEALINGS IN THE SOFTWARE.
+//
+// Except as contained in this notice, the name(s) of the above copyright
+// holders shall not be used in advertising or otherwise to promote the
+// sale, use or other dealings in this Software without prior written
+// authorization.
+
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"database/sql"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+)
+
+// http3Port is the UDP port of the HTTP/3 server, which is advertised in
+// Alt-Svc headers and HTTPS records for test hostnames, or 0 if the HTTP/3
+// server is disabled
+var http3Port int
+
+type quicConnContextKey struct{}
+
+func withQUICConn(ctx context.Context, conn *quic.Conn) context.Context {
+	return context.WithValue(ctx, quicConnContextKey{}, conn)
+}
+
+// quicDetails describes the QUIC connection which carried an HTTP/3 request
+type quicDetails struct {
+	version   sql.NullString
+	used0RTT  sql.NullBool
+	datagrams sql.NullBool
+}
+
+func requestQUICDetails(ctx context.Context) quicDetails {
+	conn, ok := ctx.Value(quicConnContextKey{}).(*quic.Conn)
+	if !ok {
+		return quicDetails{}
+	}
+	state := conn.ConnectionState()
+	return quicDetails{
+		version:   sql.NullString{String: state.Version.String(), Valid: true},
+		used0RTT:  sql.NullBool{Bool: state.Used0RTT, Valid: true},
+		datagrams: sql.NullBool{Bool: state.SupportsDatagrams.Remote, Valid: true},
+	}
+}
+
+// http3AltSvc returns the value of the Alt-Svc header (RFC 7838) which
+// advertises the HTTP/3 server
+func http3AltSvc() string {
+	return fmt.Sprintf(`h3=":%d"; ma=3600`, http3Port)
+}
+
+// makeHTTPSRR returns an HTTPS record (RFC 9460) which advertises the HTTP/3
+// server for fqdn.  The port applies to the HTTPS server as well, so it is
+// only included if the HTTP/3 server isn't on the default port.
+func makeHTTPSRR(fqdn string) *dns.HTTPS {
+	rr := &dns.HTTPS{SVCB: dns.SVCB{
+		Hdr:      dns.RR_Header{Name: fqdn, Rrtype: dns.TypeHTTPS, Class: dns.ClassINET, Ttl: 3600},
+		Priority: 1,
+		Target:   ".",
+		Value:    []dns.SVCBKeyValue{&dns.SVCBAlpn{Alpn: []string{http3.NextProtoH3, "h2"}}},
+	}}
+	if http3Port != 443 {
+		rr.Value = append(rr.Value, &dns.SVCBPort{Port: uint16(http3Port)})
+	}
+	return rr
+}
+
+// runHTTP3Server serves HTTP/3 (RFC 9114) using the same handler and TLS
+// configuration as the HTTPS server
+func runHTTP3Server(p net.PacketConn) {
+	server := http3.Server{
+		Handler:     http.HandlerFunc(serveHTTP),
+		TLSConfig:   &tls.Config{GetConfigForClient: getHTTPSConfig},
+		QUICConfig:  &quic.Config{Allow0RTT: true, MaxIdleTimeout: 30 * time.Second},
+		IdleTimeout: 3 * time.Second,
+		ConnContext: withQUICConn,
+	}
+	log.Fatal(server.Serve(p))
+}
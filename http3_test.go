@@ -0,0 +1,138 @@
+// Copyright (C) 2026 Opsmate, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a
+// copy of this software and associated documentation files (the "Software"),
+// to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense,
+// and/or sell copies of the Software, and to permit persons to whom the
+// Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included
+// in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+// THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+// OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+// ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+// OTHER DEALINGS IN THE SOFTWARE.
+//
+// Except as contained in this notice, the name(s) of the above copyright
+// holders shall not be used in advertising or otherwise to promote the
+// sale, use or other dealings in this Software without prior written
+// authorization.
+
+package main
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+// setTestHTTP3Port sets http3Port for the duration of a test
+func setTestHTTP3Port(t *testing.T, port int) {
+	t.Helper()
+	oldPort := http3Port
+	http3Port = port
+	t.Cleanup(func() { http3Port = oldPort })
+}
+
+func TestMakeHTTPSRR(t *testing.T) {
+	const fqdn = "www.0123456789abcdef0123456789abcdef.test.example.com."
+	tests := []struct {
+		port     int
+		want     string
+		wantPort uint16 // 0 if the port SvcParam should be absent
+	}{
+		{port: 443, want: `1 . alpn="h3,h2"`},
+		{port: 8443, want: `1 . alpn="h3,h2" port="8443"`, wantPort: 8443},
+		{port: 1, want: `1 . alpn="h3,h2" port="1"`, wantPort: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.port), func(t *testing.T) {
+			setTestHTTP3Port(t, tt.port)
+			rr := makeHTTPSRR(fqdn)
+			if rr.Hdr.Name != fqdn || rr.Hdr.Rrtype != dns.TypeHTTPS {
+				t.Fatalf("got header %v", rr.Hdr)
+			}
+			if got := rrDataStrings([]dns.RR{rr}); got[0] != "HTTPS "+tt.want {
+				t.Fatalf("got %q, want %q", got[0], "HTTPS "+tt.want)
+			}
+
+			// The record must survive the wire format
+			msg := new(dns.Msg)
+			msg.SetQuestion(fqdn, dns.TypeHTTPS)
+			msg.Answer = []dns.RR{rr}
+			packed, err := msg.Pack()
+			if err != nil {
+				t.Fatalf("error packing HTTPS record: %v", err)
+			}
+			if err := msg.Unpack(packed); err != nil {
+				t.Fatalf("error unpacking HTTPS record: %v", err)
+			}
+			var alpn []string
+			var port uint16
+			for _, kv := range msg.Answer[0].(*dns.HTTPS).Value {
+				switch kv := kv.(type) {
+				case *dns.SVCBAlpn:
+					alpn = kv.Alpn
+				case *dns.SVCBPort:
+					port = kv.Port
+				}
+			}
+			if !slices.Contains(alpn, "h3") {
+				t.Fatalf("alpn is %q, want it to contain h3", alpn)
+			}
+			if port != tt.wantPort {
+				t.Fatalf("got port %d, want %d", port, tt.wantPort)
+			}
+		})
+	}
+}
+
+func TestHTTP3AltSvc(t *testing.T) {
+	tests := []struct {
+		port int
+		want string
+	}{
+		{port: 443, want: `h3=":443"; ma=3600`},
+		{port: 8443, want: `h3=":8443"; ma=3600`},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.port), func(t *testing.T) {
+			setTestHTTP3Port(t, tt.port)
+			if got := http3AltSvc(); got != tt.want {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSynthesizeHTTPSRR(t *testing.T) {
+	setTestServerGlobals(t)
+	const fqdn = "www.0123456789abcdef0123456789abcdef.test.example.com."
+	synthesis := dnsSynthesis{Addresses: synthesizeNone}
+
+	setTestHTTP3Port(t, 0)
+	if isSynthesizedType(dns.TypeHTTPS) {
+		t.Fatalf("HTTPS is synthesized without an HTTP/3 server")
+	}
+	if got := rrDataStrings(synthesizeRRs(synthesis, fqdn)); len(got) != 0 {
+		t.Fatalf("got %q without an HTTP/3 server, want nothing", got)
+	}
+
+	setTestHTTP3Port(t, 8443)
+	if !isSynthesizedType(dns.TypeHTTPS) {
+		t.Fatalf("HTTPS is not synthesized with an HTTP/3 server")
+	}
+	want := []string{`HTTPS 1 . alpn="h3,h2" port="8443"`}
+	if got := rrDataStrings(synthesizeRRs(synthesis, fqdn)); !slices.Equal(got, want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
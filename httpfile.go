@@ -0,0 +1,152 @@
+// Copyright (C) 2026 Opsmate, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a
+// copy of this software and associated documentation files (the "Software"),
+// to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense,
+// and/or sell copies of the Software, and to permit persons to whom the
+// Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included
+// in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+// THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+// OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+// ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+// OTHER DEALINGS IN THE SOFTWARE.
+//
+// Except as contained in this notice, the name(s) of the above copyright
+// holders shall not be used in advertising or otherwise to promote the
+// sale, use or other dealings in this Software without prior written
+// authorization.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+	"unicode/utf8"
+
+	"src.agwa.name/go-dbutil"
+)
+
+// httpFileLimit is the maximum size of an HTTP file's content
+var httpFileLimit int64 = 64 * 1024
+
+// httpFilePathPrefixes are the paths under which HTTP files may be
+// published.  Operators can allow more with -http-file-prefix.
+var httpFilePathPrefixes = []string{
+	"/.well-known/pki-validation/",
+	"/.well-known/acme-challenge/",
+}
+
+func isAllowedHTTPFilePath(filePath string) bool {
+	for _, prefix := range httpFilePathPrefixes {
+		if strings.HasPrefix(filePath, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+const (
+	httpFileMatchExact  = "exact"
+	httpFileMatchPrefix = "prefix"
+	httpFileMatchGlob   = "glob"
+)
+
+// validateHTTPFilePath checks the path of an HTTP file, which is a path.Match
+// pattern if match is glob
+func validateHTTPFilePath(filePath string, match string) error {
+	switch match {
+	case httpFileMatchExact, httpFileMatchPrefix:
+	case httpFileMatchGlob:
+		if _, err := path.Match(filePath, ""); err != nil {
+			return fmt.Errorf("invalid glob pattern: %w", err)
+		}
+	default:
+		return fmt.Errorf("invalid path matching")
+	}
+	if !isAllowedHTTPFilePath(filePath) {
+		return fmt.Errorf("path must start with %s", strings.Join(httpFilePathPrefixes, " or "))
+	}
+	return nil
+}
+
+func (f *httpFile) matches(requestPath string) bool {
+	switch f.Match {
+	case httpFileMatchExact:
+		return requestPath == f.Path
+	case httpFileMatchPrefix:
+		return strings.HasPrefix(requestPath, f.Path)
+	case httpFileMatchGlob:
+		matched, _ := path.Match(f.Path, requestPath)
+		return matched
+	default:
+		return false
+	}
+}
+
+// IsText reports whether the content can be displayed as text
+func (f *httpFile) IsText() bool {
+	return utf8.ValidString(f.Content) && !strings.ContainsRune(f.Content, 0)
+}
+
+// literalPrefixLength returns the length of the part of the file's path
+// which a request path must match literally.  For a glob, this ends at the
+// first metacharacter, so that metacharacters don't count as specificity.
+func (f *httpFile) literalPrefixLength() int {
+	if f.Match == httpFileMatchGlob {
+		if i := strings.IndexAny(f.Path, `*?[\`); i != -1 {
+			return i
+		}
+	}
+	return len(f.Path)
+}
+
+// findHTTPFile returns the HTTP file for a request path, or nil if there is
+// none
+func findHTTPFile(ctx context.Context, testID testID, scheme string, subdomain string, requestPath string) (*httpFile, error) {
+	var files []httpFile
+	if err := dbutil.QueryStructs(ctx, db, httpFileTable, &files, `WHERE test_id = ? AND scheme = ? AND subdomain = ? AND (path = ? OR path_match <> ?)`, testID[:], scheme, subdomain, requestPath, httpFileMatchExact); err != nil {
+		return nil, fmt.Errorf("error querying http_file table: %w", err)
+	}
+	return bestHTTPFile(files, requestPath), nil
+}
+
+// bestHTTPFile returns the file which best matches a request path, or nil if
+// none match.  An exact match takes precedence, followed by the prefix or
+// glob with the longest literal prefix.  Ties go to a glob over a prefix,
+// since the glob further constrains the rest of the path, and then to the
+// longer pattern.
+func bestHTTPFile(files []httpFile, requestPath string) *httpFile {
+	var best *httpFile
+	for i := range files {
+		file := &files[i]
+		if !file.matches(requestPath) {
+			continue
+		}
+		if file.Match == httpFileMatchExact {
+			return file
+		}
+		if best == nil || moreSpecificHTTPFile(file, best) {
+			best = file
+		}
+	}
+	return best
+}
+
+func moreSpecificHTTPFile(a, b *httpFile) bool {
+	if aLen, bLen := a.literalPrefixLength(), b.literalPrefixLength(); aLen != bLen {
+		return aLen > bLen
+	}
+	if a.Match != b.Match {
+		return a.Match == httpFileMatchGlob
+	}
+	return len(a.Path) > len(b.Path)
+}
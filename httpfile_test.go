@@ -0,0 +1,147 @@
+// Copyright (C) 2026 Opsmate, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a
+// copy of this software and associated documentation files (the "Software"),
+// to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense,
+// and/or sell copies of the Software, and to permit persons to whom the
+// Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included
+// in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+// THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+// OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+// ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+// OTHER DEALINGS IN THE SOFTWARE.
+//
+// Except as contained in this notice, the name(s) of the above copyright
+// holders shall not be used in advertising or otherwise to promote the
+// sale, use or other dealings in this Software without prior written
+// authorization.
+
+package main
+
+import (
+	"testing"
+)
+
+func TestValidateHTTPFilePath(t *testing.T) {
+	tests := []struct {
+		path    string
+		match   string
+		wantErr bool
+	}{
+		{path: "/.well-known/pki-validation/file.txt", match: httpFileMatchExact},
+		{path: "/.well-known/acme-challenge/", match: httpFileMatchPrefix},
+		{path: "/.well-known/pki-validation/*.txt", match: httpFileMatchGlob},
+		{path: "/.well-known/pki-validation/[a-f]*", match: httpFileMatchGlob},
+		{path: "/.well-known/pki-validation/[a-f", match: httpFileMatchGlob, wantErr: true},
+		{path: "/.well-known/pki-validation/\\", match: httpFileMatchGlob, wantErr: true},
+		{path: "/.well-known/*", match: httpFileMatchGlob, wantErr: true},
+		{path: "/*", match: httpFileMatchGlob, wantErr: true},
+		{path: "/index.html", match: httpFileMatchExact, wantErr: true},
+		{path: "/.well-known/pki-validation", match: httpFileMatchPrefix, wantErr: true},
+		{path: "/.well-known/pki-validation/file.txt", match: "regexp", wantErr: true},
+		{path: "/.well-known/pki-validation/file.txt", match: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.match+" "+tt.path, func(t *testing.T) {
+			err := validateHTTPFilePath(tt.path, tt.match)
+			if tt.wantErr && err == nil {
+				t.Fatalf("expected error")
+			} else if !tt.wantErr && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestHTTPFileMatches(t *testing.T) {
+	tests := []struct {
+		path        string
+		match       string
+		requestPath string
+		want        bool
+	}{
+		{path: "/.well-known/pki-validation/file.txt", match: httpFileMatchExact, requestPath: "/.well-known/pki-validation/file.txt", want: true},
+		{path: "/.well-known/pki-validation/file.txt", match: httpFileMatchExact, requestPath: "/.well-known/pki-validation/file.txt2", want: false},
+		{path: "/.well-known/pki-validation/file.txt", match: httpFileMatchExact, requestPath: "/.well-known/pki-validation/FILE.txt", want: false},
+		{path: "/.well-known/pki-validation/", match: httpFileMatchPrefix, requestPath: "/.well-known/pki-validation/file.txt", want: true},
+		{path: "/.well-known/pki-validation/", match: httpFileMatchPrefix, requestPath: "/.well-known/pki-validation/", want: true},
+		{path: "/.well-known/pki-validation/", match: httpFileMatchPrefix, requestPath: "/.well-known/pki-validation", want: false},
+		{path: "/.well-known/pki-validation/*.txt", match: httpFileMatchGlob, requestPath: "/.well-known/pki-validation/file.txt", want: true},
+		{path: "/.well-known/pki-validation/*.txt", match: httpFileMatchGlob, requestPath: "/.well-known/pki-validation/dir/file.txt", want: false},
+		{path: "/.well-known/pki-validation/*.txt", match: httpFileMatchGlob, requestPath: "/.well-known/pki-validation/file.html", want: false},
+		{path: "/.well-known/pki-validation/file?.txt", match: httpFileMatchGlob, requestPath: "/.well-known/pki-validation/file1.txt", want: true},
+		{path: "/.well-known/pki-validation/[a-f]*", match: httpFileMatchGlob, requestPath: "/.well-known/pki-validation/g", want: false},
+		{path: "/.well-known/pki-validation/[a-f", match: httpFileMatchGlob, requestPath: "/.well-known/pki-validation/a", want: false},
+		{path: "/.well-known/pki-validation/", match: "regexp", requestPath: "/.well-known/pki-validation/", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.match+" "+tt.path+" "+tt.requestPath, func(t *testing.T) {
+			file := &httpFile{Path: tt.path, Match: tt.match}
+			if got := file.matches(tt.requestPath); got != tt.want {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBestHTTPFile(t *testing.T) {
+	files := []httpFile{
+		{HTTPFileID: 1, Path: "/.well-known/pki-validation/", Match: httpFileMatchPrefix},
+		{HTTPFileID: 2, Path: "/.well-known/pki-validation/*", Match: httpFileMatchGlob},
+		{HTTPFileID: 3, Path: "/.well-known/pki-validation/*.txt", Match: httpFileMatchGlob},
+		{HTTPFileID: 4, Path: "/.well-known/pki-validation/a", Match: httpFileMatchPrefix},
+		{HTTPFileID: 5, Path: "/.well-known/pki-validation/abc.txt", Match: httpFileMatchExact},
+		{HTTPFileID: 6, Path: "/.well-known/pki-validation/b*", Match: httpFileMatchGlob},
+		{HTTPFileID: 7, Path: "/.well-known/pki-validation/b", Match: httpFileMatchPrefix},
+		{HTTPFileID: 8, Path: "/.well-known/pki-validation/c[0-9]/*", Match: httpFileMatchGlob},
+		{HTTPFileID: 9, Path: "/.well-known/pki-validation/c1/", Match: httpFileMatchPrefix},
+	}
+
+	tests := []struct {
+		requestPath string
+		want        int
+	}{
+		// exact beats a longer prefix or glob
+		{requestPath: "/.well-known/pki-validation/abc.txt", want: 5},
+		// a longer literal prefix beats a glob, even one with a longer
+		// pattern whose metacharacters would otherwise count
+		{requestPath: "/.well-known/pki-validation/abd.txt", want: 4},
+		// a glob beats an overlapping prefix with the same literal text,
+		// and the longer of two such globs wins
+		{requestPath: "/.well-known/pki-validation/x.txt", want: 3},
+		{requestPath: "/.well-known/pki-validation/x.bin", want: 2},
+		{requestPath: "/.well-known/pki-validation/b.txt", want: 6},
+		// a glob with a longer literal prefix beats a shorter prefix
+		{requestPath: "/.well-known/pki-validation/c2/file", want: 8},
+		// ...but not a longer one
+		{requestPath: "/.well-known/pki-validation/c1/file", want: 9},
+		{requestPath: "/.well-known/acme-challenge/token", want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.requestPath, func(t *testing.T) {
+			got := bestHTTPFile(files, tt.requestPath)
+			if tt.want == 0 {
+				if got != nil {
+					t.Fatalf("got file %d, want none", got.HTTPFileID)
+				}
+				return
+			}
+			if got == nil {
+				t.Fatalf("got no file, want file %d", tt.want)
+			}
+			if got.HTTPFileID != tt.want {
+				t.Fatalf("got file %d, want file %d", got.HTTPFileID, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,158 @@
+// Copyright (C) 2026 Opsmate, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a
+// copy of this software and associated documentation files (the "Software"),
+// to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense,
+// and/or sell copies of the Software, and to permit persons to whom the
+// Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included
+// in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+// THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+// OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+// ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+// OTHER DEALINGS IN THE SOFTWARE.
+//
+// Except as contained in this notice, the name(s) of the above copyright
+// holders shall not be used in advertising or otherwise to promote the
+// sale, use or other dealings in this Software without prior written
+// authorization.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/http/httpguts"
+)
+
+// maxHTTPFileHeaders limits the number of extra headers in an HTTP file's response
+const maxHTTPFileHeaders = 10
+
+var httpRedirectStatuses = []int{
+	http.StatusMovedPermanently,
+	http.StatusFound,
+	http.StatusTemporaryRedirect,
+	http.StatusPermanentRedirect,
+}
+
+func isHTTPRedirectStatus(status int) bool {
+	return slices.Contains(httpRedirectStatuses, status)
+}
+
+// responseHasContent reports whether a response with the given status to a
+// request with the given method carries content (RFC 9110 sections 6.4.1 and
+// 9.3.2)
+func responseHasContent(method string, status int) bool {
+	return method != http.MethodHead && status >= 200 && status != http.StatusNoContent && status != http.StatusNotModified
+}
+
+// reservedHTTPFileHeaders are set by the server, or have their own field in
+// the HTTP file form
+var reservedHTTPFileHeaders = []string{
+	"Connection",
+	"Content-Length",
+	"Content-Type",
+	"Keep-Alive",
+	"Location",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// parseHTTPFileStatus parses the status code of an HTTP file's response
+func parseHTTPFileStatus(str string) (int, error) {
+	if str == "" {
+		return http.StatusOK, nil
+	}
+	status, err := strconv.Atoi(str)
+	if err != nil || status < 200 || status > 599 {
+		return 0, fmt.Errorf("status must be between 200 and 599")
+	}
+	return status, nil
+}
+
+// parseHTTPFileHeaders parses "Name: value" lines into the extra headers of
+// an HTTP file's response
+func parseHTTPFileHeaders(text string) (http.Header, error) {
+	header := make(http.Header)
+	count := 0
+	for line := range strings.Lines(text) {
+		line = strings.TrimRight(line, "\r\n")
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return nil, fmt.Errorf("%q is not of the form Name: value", line)
+		}
+		value = strings.TrimSpace(value)
+		if !httpguts.ValidHeaderFieldName(name) {
+			return nil, fmt.Errorf("%q is not a valid header name", name)
+		}
+		if !httpguts.ValidHeaderFieldValue(value) {
+			return nil, fmt.Errorf("header %s has an invalid value", name)
+		}
+		if slices.Contains(reservedHTTPFileHeaders, http.CanonicalHeaderKey(name)) {
+			return nil, fmt.Errorf("header %s can't be set", name)
+		}
+		if count++; count > maxHTTPFileHeaders {
+			return nil, fmt.Errorf("there are more than %d headers", maxHTTPFileHeaders)
+		}
+		header.Add(name, value)
+	}
+	return header, nil
+}
+
+// parseHTTPFileRedirect validates the target of an HTTP file's redirect,
+// which may be another test hostname, the other scheme, a non-standard
+// port, or an external URL
+func parseHTTPFileRedirect(str string) (string, error) {
+	location, err := url.Parse(str)
+	if err != nil {
+		return "", err
+	}
+	if location.Scheme != "http" && location.Scheme != "https" {
+		return "", fmt.Errorf("redirect target must be an http or https URL")
+	}
+	if location.Hostname() == "" {
+		return "", fmt.Errorf("redirect target must contain a host")
+	}
+	if port := location.Port(); port != "" {
+		if n, err := strconv.ParseUint(port, 10, 16); err != nil || n == 0 {
+			return "", fmt.Errorf("redirect target has an invalid port")
+		}
+	}
+	if !httpguts.ValidHeaderFieldValue(str) {
+		return "", fmt.Errorf("redirect target contains invalid characters")
+	}
+	return location.String(), nil
+}
+
+// normalizeHTTPURL returns the form of an http or https URL which is used to
+// match redirects with the requests that followed them
+func normalizeHTTPURL(u *url.URL) string {
+	scheme := strings.ToLower(u.Scheme)
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	if port := u.Port(); port != "" && !(scheme == "http" && port == "80") && !(scheme == "https" && port == "443") {
+		host += ":" + port
+	}
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	return scheme + "://" + host + path + "?" + u.RawQuery
+}
@@ -0,0 +1,281 @@
+// Copyright (C) 2026 Opsmate, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a
+// copy of this software and associated documentation files (the "Software"),
+// to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense,
+// and/or sell copies of the Software, and to permit persons to whom the
+// Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included
+// in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+// THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+// OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+// ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+// OTHER DEALINGS IN THE SOFTWARE.
+//
+// Except as contained in this notice, the name(s) of the above copyright
+// holders shall not be used in advertising or otherwise to promote the
+// sale, use or other dealings in this Software without prior written
+// authorization.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseHTTPFileHeaders(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    string
+		want    http.Header
+		wantErr bool
+	}{
+		{name: "empty", text: "", want: http.Header{}},
+		{name: "headers", text: "Cache-Control: no-store\r\nx-custom:  value \n\nX-Custom: second\n", want: http.Header{"Cache-Control": {"no-store"}, "X-Custom": {"value", "second"}}},
+		{name: "missing colon", text: "X-Custom value", wantErr: true},
+		{name: "invalid name", text: "X Custom: value", wantErr: true},
+		{name: "empty name", text: ": value", wantErr: true},
+		{name: "CR in value", text: "X-Custom: a\rSet-Cookie: b", wantErr: true},
+		{name: "NUL in value", text: "X-Custom: a\x00b", wantErr: true},
+		{name: "LF continuation", text: "X-Custom: a\n Set-Cookie: b", wantErr: true},
+		{name: "reserved", text: "Content-Length: 5", wantErr: true},
+		{name: "reserved lower case", text: "transfer-encoding: chunked", wantErr: true},
+		{name: "reserved Location", text: "Location: https://example.com/", wantErr: true},
+		{name: "at limit", text: strings.Repeat("X-Custom: value\n", maxHTTPFileHeaders), want: http.Header{"X-Custom": strings.Split(strings.Repeat("value,", maxHTTPFileHeaders-1)+"value", ",")}},
+		{name: "over limit", text: strings.Repeat("X-Custom: value\n", maxHTTPFileHeaders+1), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseHTTPFileHeaders(tt.text)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got %v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			var gotString, wantString strings.Builder
+			got.Write(&gotString)
+			tt.want.Write(&wantString)
+			if gotString.String() != wantString.String() {
+				t.Fatalf("got %q, want %q", gotString.String(), wantString.String())
+			}
+		})
+	}
+}
+
+func TestResponseHasContent(t *testing.T) {
+	tests := []struct {
+		method string
+		status int
+		want   bool
+	}{
+		{method: http.MethodGet, status: http.StatusOK, want: true},
+		{method: http.MethodPost, status: http.StatusNotFound, want: true},
+		{method: http.MethodGet, status: http.StatusFound, want: true},
+		{method: http.MethodHead, status: http.StatusOK, want: false},
+		{method: http.MethodHead, status: http.StatusNotFound, want: false},
+		{method: http.MethodGet, status: http.StatusNoContent, want: false},
+		{method: http.MethodGet, status: http.StatusNotModified, want: false},
+		{method: http.MethodGet, status: http.StatusEarlyHints, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s %d", tt.method, tt.status), func(t *testing.T) {
+			if got := responseHasContent(tt.method, tt.status); got != tt.want {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseHTTPFileRedirect(t *testing.T) {
+	tests := []struct {
+		location string
+		want     string
+		wantErr  bool
+	}{
+		{location: "https://example.com/path?query", want: "https://example.com/path?query"},
+		{location: "http://www.0123456789abcdef0123456789abcdef.test.example.com:8080/", want: "http://www.0123456789abcdef0123456789abcdef.test.example.com:8080/"},
+		{location: "http://[2001:db8::1]:65535/", want: "http://[2001:db8::1]:65535/"},
+		{location: "/relative", wantErr: true},
+		{location: "ftp://example.com/", wantErr: true},
+		{location: "javascript:alert(1)", wantErr: true},
+		{location: "https:///path", wantErr: true},
+		{location: "https://example.com:0/", wantErr: true},
+		{location: "https://example.com:65536/", wantErr: true},
+		{location: "https://example.com:99999999999/", wantErr: true},
+		{location: "https://example.com:https/", wantErr: true},
+		{location: "https://example.com/\r\nSet-Cookie: a=b", wantErr: true},
+		{location: "https://example.com/\x7f", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.location, func(t *testing.T) {
+			got, err := parseHTTPFileRedirect(tt.location)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got %q", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeHTTPURL(t *testing.T) {
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{url: "https://Example.COM/Path", want: "https://example.com/Path?"},
+		{url: "https://example.com:443/", want: "https://example.com/?"},
+		{url: "http://example.com:80/", want: "http://example.com/?"},
+		{url: "http://example.com:443/", want: "http://example.com:443/?"},
+		{url: "https://example.com:80/", want: "https://example.com:80/?"},
+		{url: "https://example.com./", want: "https://example.com/?"},
+		{url: "https://example.com.:443/", want: "https://example.com/?"},
+		{url: "https://example.com.:8443/", want: "https://example.com:8443/?"},
+		{url: "https://example.com", want: "https://example.com/?"},
+		{url: "https://example.com/?a=b", want: "https://example.com/?a=b"},
+		{url: "https://[2001:DB8::1]:443/", want: "https://[2001:db8::1]/?"},
+		{url: "https://[2001:db8::1]:8443/", want: "https://[2001:db8::1]:8443/?"},
+		{url: "HTTPS://example.com/a%2Fb", want: "https://example.com/a%2Fb?"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			u, err := url.Parse(tt.url)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := normalizeHTTPURL(u); got != tt.want {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHTTPRedirectChains(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	request := func(after time.Duration, https bool, host string, path string, status int, location string) httpItem {
+		item := httpItem{ReceivedAt: start.Add(after), HTTPS: https, Host: host, URL: path, ResponseStatus: &status, ResponseHeader: map[string][]string{}}
+		if location != "" {
+			item.ResponseHeader["Location"] = []string{location}
+		}
+		return item
+	}
+	const host = "www.0123456789abcdef0123456789abcdef.test.example.com"
+
+	tests := []struct {
+		name       string
+		requests   []httpItem
+		chains     [][]int // indexes into requests
+		unfollowed []string
+	}{
+		{
+			name:     "no redirects",
+			requests: []httpItem{request(0, false, host, "/a", 200, "")},
+		},
+		{
+			name: "followed to other scheme and back",
+			requests: []httpItem{
+				request(0, false, host, "/a", 301, "https://"+host+"/b"),
+				request(time.Second, true, host+":443", "/b", 302, "/c"),
+				request(2*time.Second, true, host+".", "/c", 200, ""),
+			},
+			chains:     [][]int{{0, 1, 2}},
+			unfollowed: []string{""},
+		},
+		{
+			name: "unfollowed external redirect",
+			requests: []httpItem{
+				request(0, false, host, "/a", 302, "https://example.net/"),
+			},
+			chains:     [][]int{{0}},
+			unfollowed: []string{"https://example.net/"},
+		},
+		{
+			name: "followed at timeout",
+			requests: []httpItem{
+				request(0, false, host, "/a", 307, "/b"),
+				request(httpRedirectFollowTimeout, false, host, "/b", 200, ""),
+			},
+			chains:     [][]int{{0, 1}},
+			unfollowed: []string{""},
+		},
+		{
+			name: "followed after timeout",
+			requests: []httpItem{
+				request(0, false, host, "/a", 307, "/b"),
+				request(httpRedirectFollowTimeout+time.Second, false, host, "/b", 200, ""),
+			},
+			chains:     [][]int{{0}},
+			unfollowed: []string{"http://" + host + "/b"},
+		},
+		{
+			name: "late request which redirects starts a new chain",
+			requests: []httpItem{
+				request(0, false, host, "/a", 307, "/b"),
+				request(time.Minute, false, host, "/b", 308, "/c"),
+				request(time.Minute+time.Second, false, host, "/c", 200, ""),
+			},
+			chains:     [][]int{{0}, {1, 2}},
+			unfollowed: []string{"http://" + host + "/b", ""},
+		},
+		{
+			name: "interleaved chains",
+			requests: []httpItem{
+				request(0, false, host, "/a", 301, "/b"),
+				request(time.Second, false, host, "/x", 301, "/y"),
+				request(2*time.Second, false, host, "/y", 200, ""),
+				request(3*time.Second, false, host, "/b", 200, ""),
+			},
+			chains:     [][]int{{0, 3}, {1, 2}},
+			unfollowed: []string{"", ""},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dashboard := &testDashboard{HTTP: tt.requests}
+			chains := dashboard.HTTPRedirectChains()
+			if len(chains) != len(tt.chains) {
+				t.Fatalf("got %d chains, want %d", len(chains), len(tt.chains))
+			}
+			for i, chain := range chains {
+				if len(chain.Requests) != len(tt.chains[i]) {
+					t.Fatalf("chain %d has %d requests, want %d", i, len(chain.Requests), len(tt.chains[i]))
+				}
+				for j, index := range tt.chains[i] {
+					if chain.Requests[j] != &dashboard.HTTP[index] {
+						t.Errorf("chain %d request %d is not request %d", i, j, index)
+					}
+				}
+				if chain.Unfollowed != tt.unfollowed[i] {
+					t.Errorf("chain %d: got unfollowed %q, want %q", i, chain.Unfollowed, tt.unfollowed[i])
+				}
+			}
+		})
+	}
+}
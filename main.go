@@ -39,6 +39,7 @@ import (
 	"src.agwa.name/go-dbutil/dbschema"
 	"src.agwa.name/go-listener"
 	"src.agwa.name/go-listener/cert"
+	"strings"
 	"time"
 
 	"software.sslmate.com/src/dcv-inspector/schema"
@@ -51,19 +52,27 @@ var (
 	db                  *sql.DB
 	getHTTPSCertificate cert.GetCertificateFunc
 	userAgentString     string
+	dnsRateLimiter      *responseRateLimiter
 )
 
 func main() {
 	var flags struct {
-		domain      string
-		db          string
-		httpListen  []string
-		httpsListen []string
-		httpsCert   string
-		smtpListen  []string
-		dnsListen   []string
-		dnsUDP      []string
+		domain        string
+		db            string
+		httpListen    []string
+		httpsListen   []string
+		httpsCert     string
+		http3Listen   []string
+		smtpListen    []string
+		dnsListen     []string
+		dnsUDP        []string
+		dotListen     []string
+		dohListen     []string
+		doqListen     []string
+		captureListen []string
+		dnsRRL        responseRateLimit
 	}
+	flags.dnsRRL = defaultResponseRateLimit
 	flag.StringVar(&flags.domain, "domain", "", "Domain name")
 	flag.StringVar(&flags.db, "db", "", "Path to database file")
 	flag.Func("http-listen", "Socket for HTTP server to listen on (go-listener syntax; e.g. tcp:80)", func(arg string) error {
@@ -74,6 +83,19 @@ func main() {
 		flags.httpsListen = append(flags.httpsListen, arg)
 		return nil
 	})
+	flag.Func("http3-listen", "UDP socket for HTTP/3 server, which is advertised to test hostnames (udp:PORTNO or udp:IPADDR:PORTNO or fd:FILDESC)", func(arg string) error {
+		flags.http3Listen = append(flags.http3Listen, arg)
+		return nil
+	})
+	flag.Int64Var(&httpBodyLimit, "http-body-limit", httpBodyLimit, "Number of bytes of each HTTP request body to record")
+	flag.Int64Var(&httpFileLimit, "http-file-limit", httpFileLimit, "Maximum size of HTTP files which tests can publish")
+	flag.Func("http-file-prefix", "Additional path prefix under which tests can publish HTTP files (e.g. /.well-known/mta-sts.txt, or / for any path)", func(arg string) error {
+		if !strings.HasPrefix(arg, "/") {
+			return fmt.Errorf("path prefix must start with /")
+		}
+		httpFilePathPrefixes = append(httpFilePathPrefixes, arg)
+		return nil
+	})
 	flag.StringVar(&flags.httpsCert, "https-cert", "", "HTTPS certificate (default: obtain automatically with ACME)")
 	flag.Func("smtp-listen", "Socket for SMTP server to listen on (go-listener syntax; e.g. tcp:25)", func(arg string) error {
 		flags.smtpListen = append(flags.smtpListen, arg)
@@ -87,11 +109,47 @@ func main() {
 		flags.dnsUDP = append(flags.dnsUDP, arg)
 		return nil
 	})
+	flag.Func("dot-listen", "Socket for DNS over TLS server to listen on (go-listener syntax; e.g. tcp:853)", func(arg string) error {
+		flags.dotListen = append(flags.dotListen, arg)
+		return nil
+	})
+	flag.Func("doh-listen", "Socket for DNS over HTTPS server to listen on, in addition to "+dohPath+" on the HTTPS server (go-listener syntax; e.g. tcp:8443)", func(arg string) error {
+		flags.dohListen = append(flags.dohListen, arg)
+		return nil
+	})
+	flag.Func("doq-listen", "UDP socket for DNS over QUIC server (udp:PORTNO or udp:IPADDR:PORTNO or fd:FILDESC)", func(arg string) error {
+		flags.doqListen = append(flags.doqListen, arg)
+		return nil
+	})
+	flag.Func("capture-listen", "Comma-separated list of non-standard TCP ports (or go-listener sockets) on which to record TLS, HTTP, and SMTP connections", func(arg string) error {
+		flags.captureListen = append(flags.captureListen, parseCaptureListen(arg)...)
+		return nil
+	})
+	flag.Float64Var(&flags.dnsRRL.rate, "dns-rrl-rate", flags.dnsRRL.rate, "Responses per second which the UDP DNS server sends to each source prefix (0 to disable rate limiting)")
+	flag.Float64Var(&flags.dnsRRL.burst, "dns-rrl-burst", flags.dnsRRL.burst, "Responses which the UDP DNS server sends to a source prefix in a burst before rate limiting it")
+	flag.IntVar(&flags.dnsRRL.slip, "dns-rrl-slip", flags.dnsRRL.slip, "Send every Nth rate-limited response truncated instead of dropping it (0 to drop all)")
+	flag.IntVar(&flags.dnsRRL.ipv4Prefix, "dns-rrl-ipv4-prefix", flags.dnsRRL.ipv4Prefix, "Length of IPv4 source prefixes for DNS rate limiting")
+	flag.IntVar(&flags.dnsRRL.ipv6Prefix, "dns-rrl-ipv6-prefix", flags.dnsRRL.ipv6Prefix, "Length of IPv6 source prefixes for DNS rate limiting")
 	flag.Parse()
 
 	if flags.domain == "" {
 		log.Fatal("-domain not specified")
 	}
+	if flags.dnsRRL.rate > 0 {
+		if flags.dnsRRL.burst < 1 {
+			log.Fatal("-dns-rrl-burst must be at least 1")
+		}
+		if flags.dnsRRL.slip < 0 {
+			log.Fatal("-dns-rrl-slip must not be negative")
+		}
+		if flags.dnsRRL.ipv4Prefix < 0 || flags.dnsRRL.ipv4Prefix > 32 {
+			log.Fatal("-dns-rrl-ipv4-prefix must be between 0 and 32")
+		}
+		if flags.dnsRRL.ipv6Prefix < 0 || flags.dnsRRL.ipv6Prefix > 128 {
+			log.Fatal("-dns-rrl-ipv6-prefix must be between 0 and 128")
+		}
+		dnsRateLimiter = newResponseRateLimiter(flags.dnsRRL)
+	}
 	domain = flags.domain
 	if addr, err := net.DefaultResolver.LookupNetIP(context.Background(), "ip4", domain); err != nil {
 		log.Fatal(err)
@@ -123,6 +181,11 @@ func main() {
 		log.Fatalf("error building database schema: %s", err)
 	}
 
+	if err := loadDNSSECKey(context.Background()); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("DS record for test.%s. (publish this in %s. to enable DNSSEC for test domains): %s", domain, domain, makeDS("test."+domain+"."))
+
 	if flags.httpsCert == "" {
 		getHTTPSCertificate = cert.GetCertificateAutomatically([]string{domain})
 	} else {
@@ -137,6 +200,10 @@ func main() {
 	if err != nil {
 		log.Fatalf("error opening HTTPS listeners: %s", err)
 	}
+	http3UDP, err := listenAllUDP(flags.http3Listen)
+	if err != nil {
+		log.Fatalf("error opening HTTP/3 UDP sockets: %s", err)
+	}
 	smtpListeners, err := listener.OpenAll(flags.smtpListen)
 	if err != nil {
 		log.Fatalf("error opening SMTP listeners: %s", err)
@@ -149,15 +216,37 @@ func main() {
 	if err != nil {
 		log.Fatalf("error opening DNS UDP sockets: %s", err)
 	}
+	dotListeners, err := listener.OpenAll(flags.dotListen)
+	if err != nil {
+		log.Fatalf("error opening DNS over TLS listeners: %s", err)
+	}
+	dohListeners, err := listener.OpenAll(flags.dohListen)
+	if err != nil {
+		log.Fatalf("error opening DNS over HTTPS listeners: %s", err)
+	}
+	doqUDP, err := listenAllUDP(flags.doqListen)
+	if err != nil {
+		log.Fatalf("error opening DNS over QUIC UDP sockets: %s", err)
+	}
+	captureListeners, err := listener.OpenAll(flags.captureListen)
+	if err != nil {
+		log.Fatalf("error opening capture listeners: %s", err)
+	}
 
 	if len(httpsListeners) == 0 {
 		redirectDashboardToHTTPS = false
 	}
+	if len(http3UDP) > 0 {
+		http3Port = http3UDP[0].LocalAddr().(*net.UDPAddr).Port
+	}
 
 	go cleanupTestsPeriodically()
 	go refreshPrefixesPeriodically()
 	go refreshASNamesPeriodically()
 	go refreshGooglePublicDNSPeriodically()
+	if dnsRateLimiter != nil {
+		go dnsRateLimiter.reportPeriodically()
+	}
 
 	for _, l := range httpListeners {
 		l := l
@@ -167,6 +256,10 @@ func main() {
 		l := l
 		go runHTTPSServer(l)
 	}
+	for _, u := range http3UDP {
+		u := u
+		go runHTTP3Server(u)
+	}
 	for _, l := range smtpListeners {
 		l := l
 		go runSMTPServer(l)
@@ -179,6 +272,22 @@ func main() {
 		u := u
 		go runDNSServer(nil, u)
 	}
+	for _, l := range dotListeners {
+		l := l
+		go runDoTServer(l)
+	}
+	for _, l := range dohListeners {
+		l := l
+		go runDoHServer(l)
+	}
+	for _, u := range doqUDP {
+		u := u
+		go runDoQServer(u)
+	}
+	for _, l := range captureListeners {
+		l := l
+		go runCaptureServer(l)
+	}
 
 	select {}
 }
@@ -195,6 +304,6 @@ func cleanupTestsPeriodically() {
 }
 
 func cleanupTests() error {
-	_, err := db.Exec(`UPDATE test SET stopped_at = CURRENT_TIMESTAMP WHERE stopped_at IS NULL AND started_at < ?`, time.Now().Add(-6*time.Hour))
+	_, err := db.Exec(`UPDATE test SET stopped_at = CURRENT_TIMESTAMP, tls_key_pem = NULL WHERE stopped_at IS NULL AND started_at < ?`, time.Now().Add(-6*time.Hour))
 	return err
 }
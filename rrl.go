@@ -0,0 +1,248 @@
+// Copyright (C) 2026 Opsmate, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a
+// copy of this software and associated documentation files (the "Software"),
+// to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense,
+// and/or sell copies of the Software, and to permit persons to whom the
+// Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included
+// in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+// THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+// OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+// ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+// OTHER DEALINGS IN THE SOFTWARE.
+//
+// Except as contained in this notice, the name(s) of the above copyright
+// holders shall not be used in advertising or otherwise to promote the
+// sale, use or other dealings in this Software without prior written
+// authorization.
+
+package main
+
+import (
+	"log"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// responseRateLimit configures response rate limiting (RRL) for the UDP DNS
+// server, which prevents it from being used to reflect and amplify traffic
+// towards a spoofed source address.  Each source prefix has a token bucket
+// which refills at rate tokens per second up to burst tokens.  Sending a
+// response costs one token per 512 bytes, so large responses exhaust the
+// bucket sooner.  When the bucket is empty, every slip'th response is
+// replaced with an empty truncated response, prompting legitimate resolvers
+// to retry over TCP, and the rest are dropped.
+type responseRateLimit struct {
+	rate       float64
+	burst      float64
+	slip       int
+	ipv4Prefix int
+	ipv6Prefix int
+}
+
+var defaultResponseRateLimit = responseRateLimit{
+	rate:       20,
+	burst:      100,
+	slip:       2,
+	ipv4Prefix: 24,
+	ipv6Prefix: 56,
+}
+
+type rrlBucket struct {
+	tokens  float64
+	updated time.Time
+	limited int
+}
+
+type responseRateLimiter struct {
+	config responseRateLimit
+
+	mu      sync.Mutex
+	buckets map[netip.Prefix]*rrlBucket
+	dropped uint64
+	slipped uint64
+	sources map[netip.Prefix]struct{}
+}
+
+func newResponseRateLimiter(config responseRateLimit) *responseRateLimiter {
+	return &responseRateLimiter{
+		config:  config,
+		buckets: make(map[netip.Prefix]*rrlBucket),
+		sources: make(map[netip.Prefix]struct{}),
+	}
+}
+
+func (rrl *responseRateLimiter) sourcePrefix(addr netip.Addr) netip.Prefix {
+	addr = addr.Unmap()
+	bits := rrl.config.ipv6Prefix
+	if addr.Is4() {
+		bits = rrl.config.ipv4Prefix
+	}
+	prefix, _ := addr.Prefix(bits)
+	return prefix
+}
+
+const (
+	rrlSend = iota
+	rrlSlip
+	rrlDrop
+)
+
+// maxRRLBuckets limits the number of source prefixes which are tracked at once,
+// so that a flood from many spoofed prefixes can't exhaust memory
+const maxRRLBuckets = 100000
+
+// check decides, before a query from addr is answered, whether to send the
+// response, slip it, or drop it.  Sending charges one token, which is the cost
+// of a response of up to 512 bytes; charge accounts for the rest once the size
+// of the response is known.
+func (rrl *responseRateLimiter) check(addr netip.Addr, now time.Time) int {
+	prefix := rrl.sourcePrefix(addr)
+
+	rrl.mu.Lock()
+	defer rrl.mu.Unlock()
+
+	bucket := rrl.buckets[prefix]
+	if bucket == nil {
+		if len(rrl.buckets) >= maxRRLBuckets {
+			for evicted := range rrl.buckets {
+				delete(rrl.buckets, evicted)
+				break
+			}
+		}
+		bucket = &rrlBucket{tokens: rrl.config.burst, updated: now}
+		rrl.buckets[prefix] = bucket
+	}
+	bucket.tokens = min(rrl.config.burst, bucket.tokens+now.Sub(bucket.updated).Seconds()*rrl.config.rate)
+	bucket.updated = now
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		return rrlSend
+	}
+
+	if len(rrl.sources) < maxRRLBuckets {
+		rrl.sources[prefix] = struct{}{}
+	}
+	bucket.limited++
+	if rrl.config.slip > 0 && bucket.limited%rrl.config.slip == 0 {
+		rrl.slipped++
+		return rrlSlip
+	}
+	rrl.dropped++
+	return rrlDrop
+}
+
+// charge charges the bucket for addr for a response of the given size, beyond
+// the token which check already charged.  The bucket may go into debt, which
+// delays the next response to the prefix.
+func (rrl *responseRateLimiter) charge(addr netip.Addr, size int) {
+	extra := float64(max(1, (size+511)/512) - 1)
+	if extra == 0 {
+		return
+	}
+	prefix := rrl.sourcePrefix(addr)
+
+	rrl.mu.Lock()
+	defer rrl.mu.Unlock()
+
+	if bucket := rrl.buckets[prefix]; bucket != nil {
+		bucket.tokens -= extra
+	}
+}
+
+// prune forgets buckets which have refilled completely, since they are
+// indistinguishable from new ones
+func (rrl *responseRateLimiter) prune(now time.Time) {
+	rrl.mu.Lock()
+	defer rrl.mu.Unlock()
+	for prefix, bucket := range rrl.buckets {
+		if bucket.tokens+now.Sub(bucket.updated).Seconds()*rrl.config.rate >= rrl.config.burst {
+			delete(rrl.buckets, prefix)
+		}
+	}
+}
+
+// takeCounts returns and resets the number of responses that were dropped
+// and slipped, and the number of source prefixes they were for
+func (rrl *responseRateLimiter) takeCounts() (dropped uint64, slipped uint64, sources int) {
+	rrl.mu.Lock()
+	defer rrl.mu.Unlock()
+	dropped, slipped, sources = rrl.dropped, rrl.slipped, len(rrl.sources)
+	rrl.dropped, rrl.slipped = 0, 0
+	clear(rrl.sources)
+	return
+}
+
+func (rrl *responseRateLimiter) reportPeriodically() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for range ticker.C {
+		rrl.prune(time.Now())
+		if dropped, slipped, sources := rrl.takeCounts(); dropped+slipped > 0 {
+			log.Printf("DNS response rate limiting in the last minute: %d responses dropped and %d truncated, to %d source prefixes", dropped, slipped, sources)
+		}
+	}
+}
+
+// Handler returns a DNS handler which applies rate limiting to queries before
+// passing them to next, so that dropped and slipped queries are neither
+// answered nor recorded
+func (rrl *responseRateLimiter) Handler(next dns.Handler) dns.Handler {
+	return dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		remoteAddr, err := netip.ParseAddrPort(w.RemoteAddr().String())
+		if err != nil {
+			return
+		}
+		switch rrl.check(remoteAddr.Addr(), time.Now()) {
+		case rrlSlip:
+			if slipBytes, err := makeSlipResponse(req).Pack(); err == nil {
+				w.Write(slipBytes)
+			}
+		case rrlSend:
+			next.ServeDNS(&rateLimitedDNSWriter{ResponseWriter: w, rrl: rrl, addr: remoteAddr.Addr()}, req)
+		}
+	})
+}
+
+// rateLimitedDNSWriter charges the size of each response it writes to the
+// rate limiter
+type rateLimitedDNSWriter struct {
+	dns.ResponseWriter
+	rrl  *responseRateLimiter
+	addr netip.Addr
+}
+
+func (w *rateLimitedDNSWriter) WriteMsg(msg *dns.Msg) error {
+	msgBytes, err := msg.Pack()
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(msgBytes)
+	return err
+}
+
+func (w *rateLimitedDNSWriter) Write(msgBytes []byte) (int, error) {
+	w.rrl.charge(w.addr, len(msgBytes))
+	return w.ResponseWriter.Write(msgBytes)
+}
+
+// makeSlipResponse returns an empty, truncated response to req
+func makeSlipResponse(req *dns.Msg) *dns.Msg {
+	resp := new(dns.Msg)
+	resp.SetReply(req)
+	resp.Truncated = true
+	if opt := req.IsEdns0(); opt != nil {
+		resp.SetEdns0(opt.UDPSize(), false)
+	}
+	return resp
+}
@@ -0,0 +1,140 @@
+// Copyright (C) 2026 Opsmate, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a
+// copy of this software and associated documentation files (the "Software"),
+// to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense,
+// and/or sell copies of the Software, and to permit persons to whom the
+// Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included
+// in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+// THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+// OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+// ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+// OTHER DEALINGS IN THE SOFTWARE.
+//
+// Except as contained in this notice, the name(s) of the above copyright
+// holders shall not be used in advertising or otherwise to promote the
+// sale, use or other dealings in this Software without prior written
+// authorization.
+
+package main
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestResponseRateLimiterCheck(t *testing.T) {
+	config := responseRateLimit{rate: 2, burst: 4, slip: 3, ipv4Prefix: 24, ipv6Prefix: 56}
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	type step struct {
+		addr   string
+		after  time.Duration // since start
+		size   int           // charged after sending, if non-zero
+		result int
+	}
+
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{
+			name: "burst then drop and slip",
+			steps: []step{
+				{addr: "192.0.2.1", result: rrlSend},
+				{addr: "192.0.2.1", result: rrlSend},
+				{addr: "192.0.2.1", result: rrlSend},
+				{addr: "192.0.2.1", result: rrlSend},
+				{addr: "192.0.2.1", result: rrlDrop},
+				{addr: "192.0.2.1", result: rrlDrop},
+				{addr: "192.0.2.1", result: rrlSlip},
+				{addr: "192.0.2.1", result: rrlDrop},
+				{addr: "192.0.2.1", result: rrlDrop},
+				{addr: "192.0.2.1", result: rrlSlip},
+			},
+		},
+		{
+			name: "refill",
+			steps: []step{
+				{addr: "192.0.2.1", result: rrlSend},
+				{addr: "192.0.2.1", result: rrlSend},
+				{addr: "192.0.2.1", result: rrlSend},
+				{addr: "192.0.2.1", result: rrlSend},
+				{addr: "192.0.2.1", result: rrlDrop},
+				{addr: "192.0.2.1", after: 500 * time.Millisecond, result: rrlSend},
+				{addr: "192.0.2.1", after: 500 * time.Millisecond, result: rrlDrop},
+				{addr: "192.0.2.1", after: time.Hour, result: rrlSend},
+				{addr: "192.0.2.1", after: time.Hour, result: rrlSend},
+				{addr: "192.0.2.1", after: time.Hour, result: rrlSend},
+				{addr: "192.0.2.1", after: time.Hour, result: rrlSend},
+				{addr: "192.0.2.1", after: time.Hour, result: rrlSlip},
+			},
+		},
+		{
+			name: "large responses cost more",
+			steps: []step{
+				{addr: "192.0.2.1", size: 1500, result: rrlSend},
+				{addr: "192.0.2.1", result: rrlSend},
+				{addr: "192.0.2.1", result: rrlDrop},
+				{addr: "192.0.2.1", after: time.Second, size: 4096, result: rrlSend},
+				{addr: "192.0.2.1", after: 3 * time.Second, result: rrlDrop},
+				{addr: "192.0.2.1", after: 4500 * time.Millisecond, result: rrlSend},
+			},
+		},
+		{
+			name: "IPv4 prefix grouping",
+			steps: []step{
+				{addr: "192.0.2.1", result: rrlSend},
+				{addr: "192.0.2.2", result: rrlSend},
+				{addr: "192.0.2.3", result: rrlSend},
+				{addr: "::ffff:192.0.2.4", result: rrlSend},
+				{addr: "192.0.2.255", result: rrlDrop},
+				{addr: "192.0.3.1", result: rrlSend},
+			},
+		},
+		{
+			name: "IPv6 prefix grouping",
+			steps: []step{
+				{addr: "2001:db8:0:1::1", result: rrlSend},
+				{addr: "2001:db8:0:2::1", result: rrlSend},
+				{addr: "2001:db8:0:ff::1", result: rrlSend},
+				{addr: "2001:db8:0:3::1", result: rrlSend},
+				{addr: "2001:db8:0:1::2", result: rrlDrop},
+				{addr: "2001:db8:0:100::1", result: rrlSend},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rrl := newResponseRateLimiter(config)
+			for i, step := range tt.steps {
+				addr := netip.MustParseAddr(step.addr)
+				if got := rrl.check(addr, start.Add(step.after)); got != step.result {
+					t.Fatalf("step %d: got %d, want %d", i, got, step.result)
+				}
+				if step.size != 0 {
+					rrl.charge(addr, step.size)
+				}
+			}
+		})
+	}
+}
+
+func TestResponseRateLimiterBucketLimit(t *testing.T) {
+	rrl := newResponseRateLimiter(defaultResponseRateLimit)
+	now := time.Now()
+	for i := 0; i < maxRRLBuckets+10; i++ {
+		rrl.check(netip.AddrFrom4([4]byte{byte(i >> 16), byte(i >> 8), byte(i), 0}), now)
+	}
+	if len(rrl.buckets) > maxRRLBuckets {
+		t.Fatalf("tracking %d buckets, limit is %d", len(rrl.buckets), maxRRLBuckets)
+	}
+}
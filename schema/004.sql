@@ -0,0 +1,9 @@
+CREATE TABLE dnssec_key (
+	dnssec_key_id	INTEGER PRIMARY KEY,
+	created_at	DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	algorithm	INTEGER NOT NULL,
+	public_key	TEXT NOT NULL,
+	private_key	TEXT NOT NULL
+);
+ALTER TABLE test ADD COLUMN dnssec_mode TEXT NOT NULL DEFAULT 'off';
+ALTER TABLE dns_request ADD COLUMN dnssec_mode TEXT;
@@ -0,0 +1,11 @@
+CREATE TABLE dns_fault (
+	dns_fault_id	INTEGER PRIMARY KEY,
+	test_id		BLOB NOT NULL REFERENCES test ON DELETE CASCADE,
+	subdomain	TEXT NOT NULL,
+	qtype		INTEGER,
+	fault		TEXT NOT NULL,
+	max_count	INTEGER,
+	hit_count	INTEGER NOT NULL DEFAULT 0
+);
+CREATE INDEX dns_fault_index ON dns_fault (test_id, subdomain);
+ALTER TABLE dns_request ADD COLUMN fault TEXT;
@@ -0,0 +1,9 @@
+ALTER TABLE dns_request ADD COLUMN transport TEXT;
+ALTER TABLE dns_request ADD COLUMN edns_version INTEGER;
+ALTER TABLE dns_request ADD COLUMN edns_udp_size INTEGER;
+ALTER TABLE dns_request ADD COLUMN edns_do BOOLEAN;
+ALTER TABLE dns_request ADD COLUMN cookie TEXT;
+ALTER TABLE dns_request ADD COLUMN client_subnet TEXT;
+ALTER TABLE dns_request ADD COLUMN nsid BOOLEAN;
+ALTER TABLE dns_request ADD COLUMN rd BOOLEAN;
+ALTER TABLE dns_request ADD COLUMN cd BOOLEAN;
@@ -0,0 +1,4 @@
+ALTER TABLE dns_request ADD COLUMN response_bytes BLOB;
+ALTER TABLE http_request ADD COLUMN response_status INTEGER;
+ALTER TABLE http_request ADD COLUMN response_header_json TEXT;
+ALTER TABLE http_request ADD COLUMN response_body BLOB;
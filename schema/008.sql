@@ -0,0 +1,2 @@
+ALTER TABLE dns_record ADD COLUMN ttl INTEGER NOT NULL DEFAULT 15;
+ALTER TABLE test ADD COLUMN negative_ttl INTEGER NOT NULL DEFAULT 15;
@@ -0,0 +1,10 @@
+CREATE TABLE dns_synthesis (
+	test_id		BLOB NOT NULL REFERENCES test ON DELETE CASCADE,
+	subdomain	TEXT NOT NULL,
+	addresses	TEXT NOT NULL,
+	mx		BOOLEAN NOT NULL,
+	PRIMARY KEY (test_id, subdomain)
+);
+ALTER TABLE dns_request ADD COLUMN synthesis TEXT;
+ALTER TABLE http_request ADD COLUMN address_family TEXT;
+ALTER TABLE http_request ADD COLUMN synthesis TEXT;
@@ -0,0 +1,12 @@
+CREATE TABLE dns_delay (
+	dns_delay_id	INTEGER PRIMARY KEY,
+	test_id		BLOB NOT NULL REFERENCES test ON DELETE CASCADE,
+	subdomain	TEXT NOT NULL,
+	qtype		INTEGER,
+	delay_ms	INTEGER NOT NULL,
+	jitter_ms	INTEGER NOT NULL,
+	max_count	INTEGER,
+	hit_count	INTEGER NOT NULL DEFAULT 0
+);
+CREATE INDEX dns_delay_index ON dns_delay (test_id, subdomain);
+ALTER TABLE dns_request ADD COLUMN delay_ms INTEGER;
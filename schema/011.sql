@@ -0,0 +1,2 @@
+ALTER TABLE dns_record ADD COLUMN scope TEXT;
+ALTER TABLE dns_request ADD COLUMN variant TEXT;
@@ -0,0 +1 @@
+ALTER TABLE dns_request ADD COLUMN referral TEXT;
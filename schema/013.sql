@@ -0,0 +1,5 @@
+ALTER TABLE http_request ADD COLUMN request_uri TEXT;
+ALTER TABLE http_request ADD COLUMN host_header TEXT;
+ALTER TABLE http_request ADD COLUMN body BLOB;
+ALTER TABLE http_request ADD COLUMN body_truncated BOOLEAN;
+ALTER TABLE http_request ADD COLUMN trailer_json TEXT;
@@ -0,0 +1,15 @@
+CREATE TABLE tls_client_hello (
+	tls_client_hello_id	INTEGER PRIMARY KEY,
+	test_id			BLOB NOT NULL REFERENCES test ON DELETE CASCADE,
+	received_at		DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	remote_ip		TEXT NOT NULL,
+	remote_port		INTEGER NOT NULL,
+	server_name		TEXT NOT NULL,
+	hello_json		TEXT NOT NULL,
+	raw			BLOB NOT NULL,
+	ja3			TEXT NOT NULL,
+	ja3_hash		TEXT NOT NULL,
+	ja4			TEXT NOT NULL
+);
+ALTER TABLE http_request ADD COLUMN tls_client_hello_id INTEGER REFERENCES tls_client_hello ON DELETE SET NULL;
+CREATE INDEX tls_client_hello_index ON tls_client_hello (test_id);
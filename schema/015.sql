@@ -0,0 +1,18 @@
+CREATE TABLE tls_alpn_challenge (
+	test_id				BLOB NOT NULL REFERENCES test ON DELETE CASCADE,
+	subdomain			TEXT NOT NULL,
+	key_authorization_digest	BLOB NOT NULL,
+	PRIMARY KEY (test_id, subdomain)
+);
+CREATE TABLE tls_alpn_validation (
+	tls_alpn_validation_id		INTEGER PRIMARY KEY,
+	test_id				BLOB NOT NULL REFERENCES test ON DELETE CASCADE,
+	received_at			DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	remote_ip			TEXT NOT NULL,
+	remote_port			INTEGER NOT NULL,
+	server_name			TEXT NOT NULL,
+	subdomain			TEXT NOT NULL,
+	key_authorization_digest	BLOB,
+	tls_client_hello_id		INTEGER REFERENCES tls_client_hello ON DELETE SET NULL
+);
+CREATE INDEX tls_alpn_validation_index ON tls_alpn_validation (test_id);
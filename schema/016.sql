@@ -0,0 +1,4 @@
+ALTER TABLE http_file ADD COLUMN status INTEGER NOT NULL DEFAULT 200;
+ALTER TABLE http_file ADD COLUMN content_type TEXT;
+ALTER TABLE http_file ADD COLUMN header_json TEXT;
+ALTER TABLE http_file ADD COLUMN location TEXT;
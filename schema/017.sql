@@ -0,0 +1,4 @@
+ALTER TABLE test ADD COLUMN tls_cert_mode TEXT NOT NULL DEFAULT 'self_signed';
+ALTER TABLE test ADD COLUMN tls_cert_pem TEXT;
+ALTER TABLE test ADD COLUMN tls_key_pem TEXT;
+ALTER TABLE tls_client_hello ADD COLUMN tls_cert_mode TEXT;
@@ -0,0 +1 @@
+ALTER TABLE http_file ADD COLUMN path_match TEXT NOT NULL DEFAULT 'exact';
@@ -0,0 +1,2 @@
+ALTER TABLE test ADD COLUMN acme_thumbprint TEXT;
+ALTER TABLE http_request ADD COLUMN acme_auto_answered BOOLEAN;
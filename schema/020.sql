@@ -0,0 +1,14 @@
+CREATE TABLE capture_connection (
+	capture_connection_id	INTEGER PRIMARY KEY,
+	test_id			BLOB NOT NULL REFERENCES test ON DELETE CASCADE,
+	received_at		DATETIME NOT NULL,
+	remote_ip		TEXT NOT NULL,
+	remote_port		INTEGER NOT NULL,
+	local_port		INTEGER NOT NULL,
+	protocol		TEXT NOT NULL,
+	server_name		TEXT NOT NULL,
+	first_bytes		BLOB NOT NULL,
+	bytes_received		INTEGER NOT NULL,
+	duration_ms		INTEGER NOT NULL
+);
+CREATE INDEX capture_connection_index ON capture_connection (test_id);
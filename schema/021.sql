@@ -0,0 +1,3 @@
+ALTER TABLE http_request ADD COLUMN quic_version TEXT;
+ALTER TABLE http_request ADD COLUMN quic_used_0rtt BOOLEAN;
+ALTER TABLE http_request ADD COLUMN quic_datagrams BOOLEAN;
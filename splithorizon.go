@@ -0,0 +1,171 @@
+// Copyright (C) 2026 Opsmate, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a
+// copy of this software and associated documentation files (the "Software"),
+// to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense,
+// and/or sell copies of the Software, and to permit persons to whom the
+// Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included
+// in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+// THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+// OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+// ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+// OTHER DEALINGS IN THE SOFTWARE.
+//
+// Except as contained in this notice, the name(s) of the above copyright
+// holders shall not be used in advertising or otherwise to promote the
+// sale, use or other dealings in this Software without prior written
+// authorization.
+
+package main
+
+import (
+	"fmt"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// maxScopeEntries limits the number of CIDRs and ASNs in a record's scope
+const maxScopeEntries = 50
+
+// recordScope is the set of source networks which receive a record, for
+// giving different answers to different resolvers (split-horizon DNS)
+type recordScope struct {
+	entries  []string
+	prefixes *cidrSet
+	asns     []uint32
+}
+
+// parseRecordScope parses a list of CIDRs, IP addresses, and ASNs (such as
+// AS15169) separated by commas or whitespace.  It returns nil if str is empty,
+// meaning the record is visible to all sources.
+func parseRecordScope(str string) (*recordScope, error) {
+	fields := strings.FieldsFunc(str, func(r rune) bool { return r == ',' || r == ' ' || r == '\t' })
+	if len(fields) == 0 {
+		return nil, nil
+	}
+	if len(fields) > maxScopeEntries {
+		return nil, fmt.Errorf("scope contains more than %d entries", maxScopeEntries)
+	}
+	scope := &recordScope{prefixes: newCidrSet()}
+	for _, field := range fields {
+		var entry string
+		if num, ok := strings.CutPrefix(strings.ToUpper(field), "AS"); ok {
+			asn, err := strconv.ParseUint(num, 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("invalid ASN %q", field)
+			}
+			scope.asns = append(scope.asns, uint32(asn))
+			entry = fmt.Sprintf("AS%d", asn)
+		} else if prefix, err := netip.ParsePrefix(field); err == nil {
+			prefix = prefix.Masked()
+			scope.prefixes.Add(prefix)
+			entry = prefix.String()
+		} else if addr, err := netip.ParseAddr(field); err == nil && addr.Zone() == "" {
+			addr = addr.Unmap()
+			scope.prefixes.Add(netip.PrefixFrom(addr, addr.BitLen()))
+			entry = addr.String()
+		} else {
+			return nil, fmt.Errorf("%q is not a CIDR, IP address, or ASN", field)
+		}
+		if !slices.Contains(scope.entries, entry) {
+			scope.entries = append(scope.entries, entry)
+		}
+	}
+	return scope, nil
+}
+
+// String returns the scope in the format accepted by parseRecordScope
+func (scope *recordScope) String() string {
+	if scope == nil {
+		return ""
+	}
+	return strings.Join(scope.entries, " ")
+}
+
+func (scope *recordScope) Contains(addr netip.Addr) bool {
+	if scope.prefixes.Has(addr) {
+		return true
+	}
+	for _, as := range getAutonomousSystemsForAddr(addr) {
+		if slices.Contains(scope.asns, as.Number) {
+			return true
+		}
+	}
+	return false
+}
+
+// restrictToClient removes the records which aren't visible to a client at
+// addr.  A scoped record containing the client replaces the unscoped records
+// with the same owner and type, and a scoped record not containing the client
+// is removed.
+func (zone *testZone) restrictToClient(addr netip.Addr) {
+	zone.restrictRecords(func(scope *recordScope) bool { return scope.Contains(addr) })
+}
+
+// restrictToScope removes the records which aren't visible to clients in
+// scope, treating scoped records as visible only if their scope is the same.
+// If scope is nil, only the unscoped records remain.
+func (zone *testZone) restrictToScope(scope *recordScope) {
+	zone.restrictRecords(func(other *recordScope) bool { return scope != nil && other.String() == scope.String() })
+}
+
+func (zone *testZone) restrictRecords(visible func(*recordScope) bool) {
+	type rrset struct {
+		subdomain string
+		rrtype    uint16
+	}
+	var scoped []rrset
+	zone.records = slices.DeleteFunc(zone.records, func(record dnsZoneRecord) bool {
+		if record.scope == nil {
+			return false
+		} else if visible(record.scope) {
+			scoped = append(scoped, rrset{record.subdomain, record.rr.Header().Rrtype})
+			return false
+		} else {
+			return true
+		}
+	})
+	zone.records = slices.DeleteFunc(zone.records, func(record dnsZoneRecord) bool {
+		return record.scope == nil && slices.Contains(scoped, rrset{record.subdomain, record.rr.Header().Rrtype})
+	})
+}
+
+// recordScopes returns the distinct scopes of the records with the given
+// types
+func (zone *testZone) recordScopes(rrtypes []uint16) []*recordScope {
+	var scopes []*recordScope
+	for _, record := range zone.records {
+		if record.scope != nil && slices.Contains(rrtypes, record.rr.Header().Rrtype) && !slices.ContainsFunc(scopes, func(scope *recordScope) bool { return scope.String() == record.scope.String() }) {
+			scopes = append(scopes, record.scope)
+		}
+	}
+	return scopes
+}
+
+// answerVariant returns the scopes of the records in the answer to a query
+// for subdomain, or the empty string if the answer contains no scoped records
+func (zone *testZone) answerVariant(subdomain string, answer dnsAnswer) string {
+	node := subdomain
+	if !zone.exists(subdomain) {
+		node = wildcardSubdomain(zone.closestEncloser(subdomain))
+	}
+	answerTypes := rrTypes(answer.answer)
+	var scopes []string
+	for _, record := range zone.records {
+		if record.scope != nil && record.subdomain == node && slices.Contains(answerTypes, record.rr.Header().Rrtype) {
+			if scope := record.scope.String(); !slices.Contains(scopes, scope) {
+				scopes = append(scopes, scope)
+			}
+		}
+	}
+	return strings.Join(scopes, "; ")
+}
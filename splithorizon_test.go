@@ -0,0 +1,202 @@
+// Copyright (C) 2026 Opsmate, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a
+// copy of this software and associated documentation files (the "Software"),
+// to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense,
+// and/or sell copies of the Software, and to permit persons to whom the
+// Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included
+// in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+// THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+// OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+// ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+// OTHER DEALINGS IN THE SOFTWARE.
+//
+// Except as contained in this notice, the name(s) of the above copyright
+// holders shall not be used in advertising or otherwise to promote the
+// sale, use or other dealings in this Software without prior written
+// authorization.
+
+package main
+
+import (
+	"net/netip"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/kentik/patricia"
+	"github.com/kentik/patricia/uint32_tree"
+	"github.com/miekg/dns"
+)
+
+// setTestBGPPrefix announces prefix from asn for the duration of a test
+func setTestBGPPrefix(t *testing.T, prefix netip.Prefix, asn uint32) {
+	t.Helper()
+	v4prefixes := uint32_tree.NewTreeV4()
+	v4prefixes.Add(patricia.NewIPv4AddressFromBytes(prefix.Addr().AsSlice(), uint(prefix.Bits())), asn, nil)
+	bgpData.mu.Lock()
+	oldV4Prefixes, oldV6Prefixes := bgpData.v4prefixes, bgpData.v6prefixes
+	bgpData.v4prefixes, bgpData.v6prefixes = v4prefixes, nil
+	bgpData.mu.Unlock()
+	t.Cleanup(func() {
+		bgpData.mu.Lock()
+		bgpData.v4prefixes, bgpData.v6prefixes = oldV4Prefixes, oldV6Prefixes
+		bgpData.mu.Unlock()
+	})
+}
+
+func TestParseRecordScope(t *testing.T) {
+	tests := []struct {
+		scope   string
+		want    string
+		wantErr bool
+	}{
+		{scope: "", want: ""},
+		{scope: " ,\t", want: ""},
+		{scope: "192.0.2.0/24", want: "192.0.2.0/24"},
+		{scope: "192.0.2.77/24", want: "192.0.2.0/24"},
+		{scope: "2001:DB8::/32", want: "2001:db8::/32"},
+		{scope: "192.0.2.1", want: "192.0.2.1"},
+		{scope: "::ffff:192.0.2.1", want: "192.0.2.1"},
+		{scope: "2001:db8::1", want: "2001:db8::1"},
+		{scope: "AS15169", want: "AS15169"},
+		{scope: "as15169", want: "AS15169"},
+		{scope: "AS015169", want: "AS15169"},
+		{scope: "192.0.2.1, 198.51.100.0/24\tAS64496", want: "192.0.2.1 198.51.100.0/24 AS64496"},
+		{scope: "AS64496 as64496 192.0.2.1 192.0.2.1/32 192.0.2.1", want: "AS64496 192.0.2.1 192.0.2.1/32"},
+		{scope: "AS", wantErr: true},
+		{scope: "AS-1", wantErr: true},
+		{scope: "AS4294967296", wantErr: true},
+		{scope: "ASN15169", wantErr: true},
+		{scope: "192.0.2.0/33", wantErr: true},
+		{scope: "192.0.2", wantErr: true},
+		{scope: "fe80::1%eth0", wantErr: true},
+		{scope: "example.com", wantErr: true},
+		{scope: "192.0.2.1;198.51.100.1", wantErr: true},
+		{scope: strings.Repeat("AS1 ", maxScopeEntries), want: "AS1"},
+		{scope: strings.Repeat("AS1 ", maxScopeEntries+1), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.scope, func(t *testing.T) {
+			scope, err := parseRecordScope(tt.scope)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got %q", scope.String())
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.want == "" && scope != nil {
+				t.Fatalf("got %q, want nil scope", scope.String())
+			}
+			if got := scope.String(); got != tt.want {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+			if reparsed, err := parseRecordScope(scope.String()); err != nil || reparsed.String() != tt.want {
+				t.Fatalf("scope does not round trip: got %q, %v", reparsed.String(), err)
+			}
+		})
+	}
+}
+
+func TestRecordScopeContains(t *testing.T) {
+	setTestBGPPrefix(t, netip.MustParsePrefix("203.0.113.0/24"), 64496)
+
+	scope, err := parseRecordScope("192.0.2.0/24 2001:db8::1 AS64496")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		addr string
+		want bool
+	}{
+		{addr: "192.0.2.0", want: true},
+		{addr: "192.0.2.255", want: true},
+		{addr: "192.0.3.0", want: false},
+		{addr: "2001:db8::1", want: true},
+		{addr: "2001:db8::2", want: false},
+		{addr: "203.0.113.9", want: true},
+		{addr: "198.51.100.1", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.addr, func(t *testing.T) {
+			if got := scope.Contains(netip.MustParseAddr(tt.addr)); got != tt.want {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRestrictToClient(t *testing.T) {
+	setTestServerGlobals(t)
+
+	const apex = "0123456789abcdef0123456789abcdef.test.example.com."
+	newZone := func() *testZone {
+		return &testZone{
+			apex:        apex,
+			running:     true,
+			dnssecMode:  dnssecOff,
+			negativeTTL: defaultNegativeTTL,
+			records: []dnsZoneRecord{
+				mustZoneRecord(t, apex, "www", "A 192.0.2.10", ""),
+				mustZoneRecord(t, apex, "www", "A 192.0.2.11", ""),
+				mustZoneRecord(t, apex, "www", "A 198.51.100.10", "203.0.113.0/24"),
+				mustZoneRecord(t, apex, "www", `TXT "everyone"`, ""),
+				mustZoneRecord(t, apex, "_only", `TXT "scoped"`, "203.0.113.0/24"),
+				mustZoneRecord(t, apex, "*.wild", `TXT "everyone"`, ""),
+				mustZoneRecord(t, apex, "*.wild", `TXT "scoped"`, "203.0.113.0/24 2001:db8::/32"),
+			},
+		}
+	}
+
+	tests := []struct {
+		name        string
+		client      string
+		subdomain   string
+		qtype       uint16
+		rcode       int
+		wantAnswer  []string // rdata of the answer
+		wantVariant string
+	}{
+		{name: "scoped A replaces unscoped", client: "203.0.113.5", subdomain: "www", qtype: dns.TypeA, wantAnswer: []string{"198.51.100.10"}, wantVariant: "203.0.113.0/24"},
+		{name: "scoped A hidden from others", client: "192.0.2.5", subdomain: "www", qtype: dns.TypeA, wantAnswer: []string{"192.0.2.10", "192.0.2.11"}},
+		{name: "other types unaffected", client: "203.0.113.5", subdomain: "www", qtype: dns.TypeTXT, wantAnswer: []string{`"everyone"`}},
+		{name: "scoped-only name", client: "203.0.113.5", subdomain: "_only", qtype: dns.TypeTXT, wantAnswer: []string{`"scoped"`}, wantVariant: "203.0.113.0/24"},
+		{name: "scoped-only name hidden from others", client: "192.0.2.5", subdomain: "_only", qtype: dns.TypeTXT, rcode: dns.RcodeNameError},
+		{name: "scoped wildcard", client: "2001:db8::5", subdomain: "x.wild", qtype: dns.TypeTXT, wantAnswer: []string{`"scoped"`}, wantVariant: "203.0.113.0/24 2001:db8::/32"},
+		{name: "unscoped wildcard", client: "192.0.2.5", subdomain: "x.wild", qtype: dns.TypeTXT, wantAnswer: []string{`"everyone"`}},
+		{name: "built-in A at scoped wildcard", client: "203.0.113.5", subdomain: "x.wild", qtype: dns.TypeA, wantAnswer: []string{"192.0.2.1"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			zone := newZone()
+			zone.restrictToClient(netip.MustParseAddr(tt.client))
+			fqdn := tt.subdomain + "." + apex
+			answer := zone.lookup(tt.subdomain, fqdn, tt.qtype)
+			if answer.rcode != tt.rcode {
+				t.Fatalf("got rcode %s, want %s", dns.RcodeToString[answer.rcode], dns.RcodeToString[tt.rcode])
+			}
+			var got []string
+			for _, rr := range answer.answer {
+				got = append(got, strings.TrimPrefix(rr.String(), rr.Header().String()))
+			}
+			if !slices.Equal(got, tt.wantAnswer) {
+				t.Fatalf("got answer %q, want %q", got, tt.wantAnswer)
+			}
+			if variant := zone.answerVariant(tt.subdomain, answer); variant != tt.wantVariant {
+				t.Fatalf("got variant %q, want %q", variant, tt.wantVariant)
+			}
+		})
+	}
+}
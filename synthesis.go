@@ -0,0 +1,171 @@
+// Copyright (C) 2026 Opsmate, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a
+// copy of this software and associated documentation files (the "Software"),
+// to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense,
+// and/or sell copies of the Software, and to permit persons to whom the
+// Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included
+// in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+// THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+// OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+// ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+// OTHER DEALINGS IN THE SOFTWARE.
+//
+// Except as contained in this notice, the name(s) of the above copyright
+// holders shall not be used in advertising or otherwise to promote the
+// sale, use or other dealings in this Software without prior written
+// authorization.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"slices"
+
+	"github.com/miekg/dns"
+	"src.agwa.name/go-dbutil"
+)
+
+const (
+	synthesizeDefault  = "default"
+	synthesizeIPv4Only = "ipv4_only"
+	synthesizeIPv6Only = "ipv6_only"
+	synthesizeNone     = "none"
+	synthesizePrivate  = "private"
+	synthesizeLoopback = "loopback"
+	synthesizeMetadata = "metadata"
+)
+
+type addressSynthesis struct {
+	Name        string
+	Description string
+	v4          []netip.Addr // nil means this server's IPv4 addresses
+	v6          []netip.Addr // nil means this server's IPv6 addresses
+}
+
+var addressSyntheses = []addressSynthesis{
+	{Name: synthesizeDefault, Description: "This server's IPv4 and IPv6 addresses"},
+	{Name: synthesizeIPv4Only, Description: "This server's IPv4 addresses only", v6: []netip.Addr{}},
+	{Name: synthesizeIPv6Only, Description: "This server's IPv6 addresses only", v4: []netip.Addr{}},
+	{Name: synthesizeNone, Description: "No addresses", v4: []netip.Addr{}, v6: []netip.Addr{}},
+	{Name: synthesizePrivate, Description: "Private addresses (10.0.0.1, fd00::1)", v4: []netip.Addr{netip.MustParseAddr("10.0.0.1")}, v6: []netip.Addr{netip.MustParseAddr("fd00::1")}},
+	{Name: synthesizeLoopback, Description: "Loopback addresses (127.0.0.1, ::1)", v4: []netip.Addr{netip.MustParseAddr("127.0.0.1")}, v6: []netip.Addr{netip.IPv6Loopback()}},
+	{Name: synthesizeMetadata, Description: "Cloud metadata addresses (169.254.169.254, fd00:ec2::254)", v4: []netip.Addr{netip.MustParseAddr("169.254.169.254")}, v6: []netip.Addr{netip.MustParseAddr("fd00:ec2::254")}},
+}
+
+func getAddressSynthesis(name string) *addressSynthesis {
+	for i := range addressSyntheses {
+		if addressSyntheses[i].Name == name {
+			return &addressSyntheses[i]
+		}
+	}
+	return nil
+}
+
+var dnsSynthesisTable = dbutil.Table{Name: "dns_synthesis"}
+
+// dnsSynthesis controls the A, AAAA, and MX records which are synthesized
+// for a subdomain and its descendants
+type dnsSynthesis struct {
+	Subdomain string `sql:"subdomain"`
+	Addresses string `sql:"addresses"`
+	MX        bool   `sql:"mx"`
+}
+
+var defaultDNSSynthesis = dnsSynthesis{Addresses: synthesizeDefault, MX: true}
+
+func (s *dnsSynthesis) AddressesDescription() string {
+	if synthesis := getAddressSynthesis(s.Addresses); synthesis != nil {
+		return synthesis.Description
+	}
+	return s.Addresses
+}
+
+// Label summarizes the synthesized answers for display in request logs
+func (s *dnsSynthesis) Label() string {
+	if s.MX {
+		return s.Addresses
+	}
+	return s.Addresses + ",no_mx"
+}
+
+func (s *dnsSynthesis) v4() []netip.Addr {
+	if synthesis := getAddressSynthesis(s.Addresses); synthesis != nil && synthesis.v4 != nil {
+		return synthesis.v4
+	}
+	return v4address
+}
+
+func (s *dnsSynthesis) v6() []netip.Addr {
+	if synthesis := getAddressSynthesis(s.Addresses); synthesis != nil && synthesis.v6 != nil {
+		return synthesis.v6
+	}
+	return v6address
+}
+
+func isSynthesizedType(qtype uint16) bool {
+	return qtype == dns.TypeA || qtype == dns.TypeAAAA || qtype == dns.TypeMX || qtype == dns.TypeANY || (qtype == dns.TypeHTTPS && http3Port != 0)
+}
+
+func loadDNSSyntheses(ctx context.Context, testID testID) ([]dnsSynthesis, error) {
+	var syntheses []dnsSynthesis
+	if err := dbutil.QueryStructs(ctx, db, dnsSynthesisTable, &syntheses, `WHERE test_id = ? ORDER BY subdomain`, testID[:]); err != nil {
+		return nil, fmt.Errorf("error querying dns_synthesis table: %w", err)
+	}
+	return syntheses, nil
+}
+
+// matchDNSSynthesis returns the synthesis for subdomain, or its closest ancestor
+// which has one
+func matchDNSSynthesis(syntheses []dnsSynthesis, subdomain string) dnsSynthesis {
+	names := []string{subdomain}
+	ancestors := subdomainAncestors(subdomain)
+	for i := len(ancestors) - 1; i >= 0; i-- {
+		names = append(names, ancestors[i])
+	}
+	names = append(names, "")
+	for _, name := range names {
+		if i := slices.IndexFunc(syntheses, func(s dnsSynthesis) bool { return s.Subdomain == name }); i != -1 {
+			return syntheses[i]
+		}
+	}
+	return defaultDNSSynthesis
+}
+
+// synthesizeRRs returns the A, AAAA, and MX records for fqdn, and an HTTPS
+// record advertising HTTP/3 if it's enabled
+func synthesizeRRs(synthesis dnsSynthesis, fqdn string) []dns.RR {
+	var rrs []dns.RR
+	for _, addr := range synthesis.v4() {
+		rrs = append(rrs, &dns.A{
+			Hdr: dns.RR_Header{Name: fqdn, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 3600},
+			A:   addr.AsSlice(),
+		})
+	}
+	for _, addr := range synthesis.v6() {
+		rrs = append(rrs, &dns.AAAA{
+			Hdr:  dns.RR_Header{Name: fqdn, Rrtype: dns.TypeAAAA, Class: dns.ClassINET, Ttl: 3600},
+			AAAA: addr.AsSlice(),
+		})
+	}
+	if synthesis.MX {
+		rrs = append(rrs, &dns.MX{
+			Hdr:        dns.RR_Header{Name: fqdn, Rrtype: dns.TypeMX, Class: dns.ClassINET, Ttl: 86400},
+			Preference: 10,
+			Mx:         domain + ".",
+		})
+	}
+	if http3Port != 0 {
+		rrs = append(rrs, makeHTTPSRR(fqdn))
+	}
+	return rrs
+}
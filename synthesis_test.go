@@ -0,0 +1,158 @@
+// Copyright (C) 2026 Opsmate, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a
+// copy of this software and associated documentation files (the "Software"),
+// to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense,
+// and/or sell copies of the Software, and to permit persons to whom the
+// Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included
+// in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+// THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+// OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+// ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+// OTHER DEALINGS IN THE SOFTWARE.
+//
+// Except as contained in this notice, the name(s) of the above copyright
+// holders shall not be used in advertising or otherwise to promote the
+// sale, use or other dealings in this Software without prior written
+// authorization.
+
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+// rrDataStrings returns the type and data of each RR, without its owner name
+func rrDataStrings(rrs []dns.RR) []string {
+	var strs []string
+	for _, rr := range rrs {
+		strs = append(strs, dns.TypeToString[rr.Header().Rrtype]+" "+strings.TrimPrefix(rr.String(), rr.Header().String()))
+	}
+	return strs
+}
+
+func TestMatchDNSSynthesis(t *testing.T) {
+	syntheses := []dnsSynthesis{
+		{Subdomain: "", Addresses: synthesizeNone, MX: true},
+		{Subdomain: "b.c", Addresses: synthesizePrivate, MX: false},
+		{Subdomain: "c", Addresses: synthesizeLoopback, MX: true},
+	}
+
+	tests := []struct {
+		syntheses []dnsSynthesis
+		subdomain string
+		want      string
+	}{
+		{syntheses: nil, subdomain: "", want: "default"},
+		{syntheses: nil, subdomain: "a.b.c", want: "default"},
+		{syntheses: syntheses, subdomain: "", want: "none"},
+		{syntheses: syntheses, subdomain: "x", want: "none"},
+		{syntheses: syntheses, subdomain: "c", want: "loopback"},
+		{syntheses: syntheses, subdomain: "x.c", want: "loopback"},
+		{syntheses: syntheses, subdomain: "b.c", want: "private,no_mx"},
+		{syntheses: syntheses, subdomain: "a.b.c", want: "private,no_mx"},
+		{syntheses: syntheses, subdomain: "x.a.b.c", want: "private,no_mx"},
+		{syntheses: syntheses, subdomain: "ab.c", want: "loopback"},
+		{syntheses: syntheses, subdomain: "c.x", want: "none"},
+		{syntheses: syntheses[1:], subdomain: "b.c.x", want: "default"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.subdomain, func(t *testing.T) {
+			got := matchDNSSynthesis(tt.syntheses, tt.subdomain)
+			if label := got.Label(); label != tt.want {
+				t.Fatalf("got %q, want %q", label, tt.want)
+			}
+		})
+	}
+}
+
+func TestSynthesizeRRs(t *testing.T) {
+	setTestServerGlobals(t)
+
+	const fqdn = "www.0123456789abcdef0123456789abcdef.test.example.com."
+	tests := []struct {
+		addresses string
+		mx        bool
+		want      []string
+	}{
+		{addresses: synthesizeDefault, mx: true, want: []string{"A 192.0.2.1", "AAAA 2001:db8::1", "MX 10 example.com."}},
+		{addresses: synthesizeDefault, mx: false, want: []string{"A 192.0.2.1", "AAAA 2001:db8::1"}},
+		{addresses: synthesizeIPv4Only, mx: true, want: []string{"A 192.0.2.1", "MX 10 example.com."}},
+		{addresses: synthesizeIPv6Only, mx: true, want: []string{"AAAA 2001:db8::1", "MX 10 example.com."}},
+		{addresses: synthesizeNone, mx: true, want: []string{"MX 10 example.com."}},
+		{addresses: synthesizeNone, mx: false, want: nil},
+		{addresses: synthesizePrivate, mx: false, want: []string{"A 10.0.0.1", "AAAA fd00::1"}},
+		{addresses: synthesizeLoopback, mx: false, want: []string{"A 127.0.0.1", "AAAA ::1"}},
+		{addresses: synthesizeMetadata, mx: false, want: []string{"A 169.254.169.254", "AAAA fd00:ec2::254"}},
+	}
+
+	for _, tt := range tests {
+		synthesis := dnsSynthesis{Addresses: tt.addresses, MX: tt.mx}
+		t.Run(synthesis.Label(), func(t *testing.T) {
+			rrs := synthesizeRRs(synthesis, fqdn)
+			if got := rrDataStrings(rrs); !slices.Equal(got, tt.want) {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+			for _, rr := range rrs {
+				if rr.Header().Name != fqdn {
+					t.Fatalf("got owner %q, want %q", rr.Header().Name, fqdn)
+				}
+			}
+		})
+	}
+}
+
+func TestTestZoneSynthesis(t *testing.T) {
+	setTestServerGlobals(t)
+
+	const apex = "0123456789abcdef0123456789abcdef.test.example.com."
+	zone := &testZone{
+		apex:        apex,
+		running:     true,
+		dnssecMode:  dnssecOff,
+		negativeTTL: defaultNegativeTTL,
+		syntheses: []dnsSynthesis{
+			{Subdomain: "lab", Addresses: synthesizePrivate, MX: false},
+		},
+	}
+
+	tests := []struct {
+		subdomain string
+		qtype     uint16
+		rcode     int
+		want      []string
+	}{
+		{subdomain: "www", qtype: dns.TypeA, want: []string{"A 192.0.2.1"}},
+		{subdomain: "www", qtype: dns.TypeMX, want: []string{"MX 10 example.com."}},
+		{subdomain: "lab", qtype: dns.TypeAAAA, want: []string{"AAAA fd00::1"}},
+		{subdomain: "host.lab", qtype: dns.TypeA, want: []string{"A 10.0.0.1"}},
+		{subdomain: "host.lab", qtype: dns.TypeMX, want: nil},
+		{subdomain: "_svc", qtype: dns.TypeA, rcode: dns.RcodeNameError, want: nil},
+		{subdomain: "_svc.lab", qtype: dns.TypeA, rcode: dns.RcodeNameError, want: nil},
+		{subdomain: "_svc.lab", qtype: dns.TypeMX, rcode: dns.RcodeNameError, want: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.subdomain+"/"+dns.TypeToString[tt.qtype], func(t *testing.T) {
+			answer := zone.lookup(tt.subdomain, tt.subdomain+"."+apex, tt.qtype)
+			if answer.rcode != tt.rcode {
+				t.Fatalf("got rcode %s, want %s", dns.RcodeToString[answer.rcode], dns.RcodeToString[tt.rcode])
+			}
+			if got := rrDataStrings(answer.answer); !slices.Equal(got, tt.want) {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
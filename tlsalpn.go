@@ -0,0 +1,137 @@
+// Copyright (C) 2026 Opsmate, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a
+// copy of this software and associated documentation files (the "Software"),
+// to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense,
+// and/or sell copies of the Software, and to permit persons to whom the
+// Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included
+// in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+// THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+// OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+// ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+// OTHER DEALINGS IN THE SOFTWARE.
+//
+// Except as contained in this notice, the name(s) of the above copyright
+// holders shall not be used in advertising or otherwise to promote the
+// sale, use or other dealings in this Software without prior written
+// authorization.
+
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509/pkix"
+	"database/sql"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/netip"
+	"strings"
+
+	"src.agwa.name/go-dbutil"
+)
+
+// acmeTLSALPNProtocol is the ALPN protocol of the ACME TLS-ALPN-01 challenge (RFC 8737)
+const acmeTLSALPNProtocol = "acme-tls/1"
+
+// idPeACMEIdentifier is the OID of the extension containing the SHA-256
+// digest of the key authorization (RFC 8737 section 6.1)
+var idPeACMEIdentifier = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 31}
+
+var tlsALPNChallengeTable = dbutil.Table{Name: "tls_alpn_challenge"}
+
+// tlsALPNChallenge is the key authorization digest which is served in the
+// TLS-ALPN-01 challenge certificate for a test hostname
+type tlsALPNChallenge struct {
+	Subdomain              string `sql:"subdomain"`
+	KeyAuthorizationDigest []byte `sql:"key_authorization_digest"`
+}
+
+func (c *tlsALPNChallenge) DigestString() string {
+	return base64.RawURLEncoding.EncodeToString(c.KeyAuthorizationDigest)
+}
+
+// isTLSALPNChallengeHello reports whether hello is from an ACME server
+// performing a TLS-ALPN-01 challenge, which offers only acme-tls/1
+func isTLSALPNChallengeHello(hello *tls.ClientHelloInfo) bool {
+	return len(hello.SupportedProtos) == 1 && hello.SupportedProtos[0] == acmeTLSALPNProtocol
+}
+
+// parseKeyAuthorizationDigest parses the SHA-256 digest of a key
+// authorization, in hex or base64url.  A key authorization itself
+// (token.thumbprint) is also accepted and hashed.
+func parseKeyAuthorizationDigest(str string) ([]byte, error) {
+	str = strings.TrimSpace(str)
+	if len(str) == 2*sha256.Size {
+		if digest, err := hex.DecodeString(str); err == nil {
+			return digest, nil
+		}
+	}
+	if digest, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(str, "=")); err == nil && len(digest) == sha256.Size {
+		return digest, nil
+	}
+	if token, thumbprint, ok := strings.Cut(str, "."); ok && token != "" && thumbprint != "" && !strings.ContainsAny(str, " \t\r\n") {
+		digest := sha256.Sum256([]byte(str))
+		return digest[:], nil
+	}
+	return nil, fmt.Errorf("not a SHA-256 digest in hex or base64url, or a key authorization")
+}
+
+// getTLSALPNChallengeCert returns the TLS-ALPN-01 challenge certificate for
+// a test hostname, recording the handshake as a validation event.  If the
+// test owner hasn't entered a key authorization digest for the hostname,
+// the handshake fails.
+func getTLSALPNChallengeCert(hello *tls.ClientHelloInfo, testID testID, subdomain string) (*tls.Certificate, error) {
+	ctx := hello.Context()
+	if ok, err := isRunningTest(ctx, testID); err != nil {
+		return nil, fmt.Errorf("error checking if %v is a running test: %w", testID, err)
+	} else if !ok {
+		return nil, fmt.Errorf("%v is not a running test", testID)
+	}
+
+	var digest []byte
+	if err := db.QueryRowContext(ctx, `SELECT key_authorization_digest FROM tls_alpn_challenge WHERE test_id = ? AND subdomain = ?`, testID[:], subdomain).Scan(&digest); err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("error querying tls_alpn_challenge: %w", err)
+	}
+
+	if err := recordTLSALPNValidation(ctx, testID, subdomain, hello, digest); err != nil {
+		log.Printf("error recording TLS-ALPN-01 validation for test %v: %s", testID, err)
+	}
+
+	if digest == nil {
+		return nil, fmt.Errorf("no TLS-ALPN-01 challenge for %s", hello.ServerName)
+	}
+	return makeTLSALPNChallengeCert(hello.ServerName, digest)
+}
+
+// makeTLSALPNChallengeCert returns a self-signed certificate for serverName
+// with the critical acmeIdentifier extension containing digest
+func makeTLSALPNChallengeCert(serverName string, digest []byte) (*tls.Certificate, error) {
+	extensionValue, err := asn1.Marshal(digest)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling acmeIdentifier extension: %w", err)
+	}
+	return makeSelfSignedCert(serverName, []pkix.Extension{{Id: idPeACMEIdentifier, Critical: true, Value: extensionValue}})
+}
+
+func recordTLSALPNValidation(ctx context.Context, testID testID, subdomain string, hello *tls.ClientHelloInfo, digest []byte) error {
+	remoteAddr, err := netip.ParseAddrPort(hello.Conn.RemoteAddr().String())
+	if err != nil {
+		return fmt.Errorf("error parsing remote address: %w", err)
+	}
+	if _, err := db.ExecContext(ctx, `INSERT INTO tls_alpn_validation (test_id, remote_ip, remote_port, server_name, subdomain, key_authorization_digest, tls_client_hello_id) VALUES (?, ?, ?, ?, ?, ?, ?)`, testID[:], remoteAddr.Addr().String(), remoteAddr.Port(), hello.ServerName, subdomain, digest, recordedClientHelloID(hello.Conn)); err != nil {
+		return fmt.Errorf("error inserting tls_alpn_validation: %w", err)
+	}
+	return nil
+}
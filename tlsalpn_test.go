@@ -0,0 +1,157 @@
+// Copyright (C) 2026 Opsmate, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a
+// copy of this software and associated documentation files (the "Software"),
+// to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense,
+// and/or sell copies of the Software, and to permit persons to whom the
+// Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included
+// in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+// THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+// OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+// ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+// OTHER DEALINGS IN THE SOFTWARE.
+//
+// Except as contained in this notice, the name(s) of the above copyright
+// holders shall not be used in advertising or otherwise to promote the
+// sale, use or other dealings in this Software without prior written
+// authorization.
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/hex"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestMakeTLSALPNChallengeCert(t *testing.T) {
+	const serverName = "www.0123456789abcdef0123456789abcdef.test.example.com"
+	digest := sha256.Sum256([]byte("evaGxfADs6pSRb2LAv9IZf17Dt3juxGJ-PCt92wr-oA.NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs"))
+
+	cert, err := makeTLSALPNChallengeCert(serverName, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cert.Certificate) != 1 {
+		t.Fatalf("got chain of %d certificates, want 1", len(cert.Certificate))
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// RFC 8737 section 3: the certificate must contain exactly one SAN,
+	// a dNSName for the domain being validated
+	if !slices.Equal(leaf.DNSNames, []string{serverName}) || len(leaf.IPAddresses) != 0 || len(leaf.EmailAddresses) != 0 || len(leaf.URIs) != 0 {
+		t.Errorf("got SANs %v %v %v %v, want only %s", leaf.DNSNames, leaf.IPAddresses, leaf.EmailAddresses, leaf.URIs, serverName)
+	}
+
+	var found int
+	for _, ext := range leaf.Extensions {
+		if !ext.Id.Equal(idPeACMEIdentifier) {
+			continue
+		}
+		found++
+		if !ext.Critical {
+			t.Errorf("acmeIdentifier extension is not critical")
+		}
+		// Authorization ::= OCTET STRING (SIZE (32))
+		want := append([]byte{asn1.TagOctetString, sha256.Size}, digest[:]...)
+		if !bytes.Equal(ext.Value, want) {
+			t.Errorf("got extension value %x, want %x", ext.Value, want)
+		}
+		var value []byte
+		if rest, err := asn1.Unmarshal(ext.Value, &value); err != nil || len(rest) != 0 {
+			t.Errorf("extension value is not a single OCTET STRING: %v", err)
+		} else if !bytes.Equal(value, digest[:]) {
+			t.Errorf("got digest %x, want %x", value, digest)
+		}
+	}
+	if found != 1 {
+		t.Errorf("found %d acmeIdentifier extensions, want 1", found)
+	}
+	if slices.ContainsFunc(leaf.UnhandledCriticalExtensions, func(id asn1.ObjectIdentifier) bool { return !id.Equal(idPeACMEIdentifier) }) {
+		t.Errorf("unexpected critical extensions %v", leaf.UnhandledCriticalExtensions)
+	}
+}
+
+func TestParseKeyAuthorizationDigest(t *testing.T) {
+	const keyAuthorization = "evaGxfADs6pSRb2LAv9IZf17Dt3juxGJ-PCt92wr-oA.NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs"
+	digest := sha256.Sum256([]byte(keyAuthorization))
+	hexDigest := hex.EncodeToString(digest[:])
+
+	tests := []struct {
+		name    string
+		input   string
+		want    []byte
+		wantErr bool
+	}{
+		{name: "hex", input: hexDigest, want: digest[:]},
+		{name: "upper case hex", input: "  " + string(bytes.ToUpper([]byte(hexDigest))) + "\n", want: digest[:]},
+		{name: "base64url", input: "xPnaOZ0EJeB7AHjhXkPdgVVIsh04IImDBrLhyLZfP38", want: mustDecodeHex(t, "c4f9da399d0425e07b0078e15e43dd815548b21d3820898306b2e1c8b65f3f7f")},
+		{name: "padded base64url", input: "xPnaOZ0EJeB7AHjhXkPdgVVIsh04IImDBrLhyLZfP38=", want: mustDecodeHex(t, "c4f9da399d0425e07b0078e15e43dd815548b21d3820898306b2e1c8b65f3f7f")},
+		{name: "key authorization", input: keyAuthorization, want: digest[:]},
+		{name: "key authorization with whitespace", input: " " + keyAuthorization + "\r\n", want: digest[:]},
+		{name: "short hex", input: hexDigest[:62], wantErr: true},
+		{name: "long hex", input: hexDigest + "00", wantErr: true},
+		{name: "short base64url", input: "xPnaOZ0EJeB7AHjhXkPdgVVIsh04IImDBrLhyLZfP3", wantErr: true},
+		{name: "standard base64", input: "xPnaOZ0EJeB7AHjhXkPdgVVIsh04IImDBrLhyLZfP38+", wantErr: true},
+		{name: "empty token", input: ".NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs", wantErr: true},
+		{name: "empty thumbprint", input: "evaGxfADs6pSRb2LAv9IZf17Dt3juxGJ-PCt92wr-oA.", wantErr: true},
+		{name: "key authorization with space", input: "token. thumbprint", wantErr: true},
+		{name: "empty", input: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseKeyAuthorizationDigest(tt.input)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got %x", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Fatalf("got %x, want %x", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsTLSALPNChallengeHello(t *testing.T) {
+	tests := []struct {
+		protos []string
+		want   bool
+	}{
+		{protos: []string{"acme-tls/1"}, want: true},
+		{protos: []string{"acme-tls/1", "http/1.1"}, want: false},
+		{protos: []string{"http/1.1", "acme-tls/1"}, want: false},
+		{protos: []string{"h2", "http/1.1"}, want: false},
+		{protos: []string{"ACME-TLS/1"}, want: false},
+		{protos: nil, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.protos, ","), func(t *testing.T) {
+			if got := isTLSALPNChallengeHello(&tls.ClientHelloInfo{SupportedProtos: tt.protos}); got != tt.want {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
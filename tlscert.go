@@ -0,0 +1,263 @@
+// Copyright (C) 2026 Opsmate, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a
+// copy of this software and associated documentation files (the "Software"),
+// to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense,
+// and/or sell copies of the Software, and to permit persons to whom the
+// Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included
+// in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+// THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+// OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+// ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+// OTHER DEALINGS IN THE SOFTWARE.
+//
+// Except as contained in this notice, the name(s) of the above copyright
+// holders shall not be used in advertising or otherwise to promote the
+// sale, use or other dealings in this Software without prior written
+// authorization.
+
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"database/sql"
+	"encoding/pem"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	tlsCertSelfSigned  = "self_signed"
+	tlsCertExpired     = "expired"
+	tlsCertNotYetValid = "not_yet_valid"
+	tlsCertWrongName   = "wrong_name"
+	tlsCertECDSA       = "ecdsa"
+	tlsCertLocalRoot   = "local_root"
+	tlsCertTLS10Only   = "tls10_only"
+	tlsCertTLS13Only   = "tls13_only"
+	tlsCertUploaded    = "uploaded"
+
+	// tlsCertTLSALPNChallenge is recorded for handshakes which received a
+	// TLS-ALPN-01 challenge certificate instead of the test's certificate
+	tlsCertTLSALPNChallenge = "tls_alpn_challenge"
+)
+
+type tlsCertMode struct {
+	Name        string
+	Description string
+}
+
+var tlsCertModes = []tlsCertMode{
+	{tlsCertSelfSigned, "Self-signed RSA certificate"},
+	{tlsCertExpired, "Self-signed certificate which expired yesterday"},
+	{tlsCertNotYetValid, "Self-signed certificate which is valid from tomorrow"},
+	{tlsCertWrongName, "Self-signed certificate for a different name"},
+	{tlsCertECDSA, "Self-signed ECDSA P-256 certificate"},
+	{tlsCertLocalRoot, "Certificate chain from a throwaway root CA"},
+	{tlsCertTLS10Only, "Self-signed certificate, TLS 1.0 only"},
+	{tlsCertTLS13Only, "Self-signed certificate, TLS 1.3 only"},
+	{tlsCertUploaded, "Uploaded certificate and key"},
+}
+
+func isValidTLSCertMode(mode string) bool {
+	return slices.ContainsFunc(tlsCertModes, func(m tlsCertMode) bool { return m.Name == mode })
+}
+
+func tlsCertModeDescription(mode string) string {
+	if mode == tlsCertTLSALPNChallenge {
+		return "TLS-ALPN-01 challenge certificate"
+	}
+	for _, m := range tlsCertModes {
+		if m.Name == mode {
+			return m.Description
+		}
+	}
+	return mode
+}
+
+// maxUploadedCertSize limits the size of an uploaded certificate chain or key, in PEM
+const maxUploadedCertSize = 64 * 1024
+
+// wrongCertName is the DNS name of certificates in wrong_name mode
+const wrongCertName = "wrong-name.invalid"
+
+var ecdsaKey = sync.OnceValues(func() (crypto.Signer, error) {
+	return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+})
+
+// localRootCA is generated on first use and never persisted, so no client
+// can trust it
+var localRootCA = sync.OnceValues(func() (*tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	template := &x509.Certificate{
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		NotAfter:              time.Now().Add(10 * 365 * 24 * time.Hour),
+		NotBefore:             time.Now().Add(-24 * time.Hour),
+		SerialNumber:          generateRandomSerialNumber(),
+		Subject:               pkix.Name{CommonName: "DCV Inspector Throwaway Root"},
+	}
+	certBytes, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(certBytes)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Certificate{Certificate: [][]byte{certBytes}, PrivateKey: key, Leaf: cert}, nil
+})
+
+// testTLSSettings controls the certificate and TLS versions which are
+// presented by a test's HTTPS hostnames
+type testTLSSettings struct {
+	running  bool
+	mode     string
+	uploaded *tls.Certificate
+}
+
+func loadTestTLSSettings(ctx context.Context, testID testID) (*testTLSSettings, error) {
+	var (
+		stoppedAt sql.NullTime
+		mode      string
+	)
+	if err := db.QueryRowContext(ctx, `SELECT stopped_at, tls_cert_mode FROM test WHERE test_id = ?`, testID[:]).Scan(&stoppedAt, &mode); err == sql.ErrNoRows {
+		return &testTLSSettings{mode: tlsCertSelfSigned}, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("error querying test: %w", err)
+	}
+	if stoppedAt.Valid {
+		// Stopped tests present a self-signed certificate, since the
+		// uploaded key is deleted when the test stops
+		return &testTLSSettings{mode: tlsCertSelfSigned}, nil
+	}
+	settings := &testTLSSettings{running: true, mode: mode}
+	if mode == tlsCertUploaded {
+		var certPEM, keyPEM sql.NullString
+		if err := db.QueryRowContext(ctx, `SELECT tls_cert_pem, tls_key_pem FROM test WHERE test_id = ?`, testID[:]).Scan(&certPEM, &keyPEM); err != nil {
+			return nil, fmt.Errorf("error querying test: %w", err)
+		}
+		cert, err := tls.X509KeyPair([]byte(certPEM.String), []byte(keyPEM.String))
+		if err != nil {
+			return nil, fmt.Errorf("test %v has a bad uploaded certificate: %w", testID, err)
+		}
+		settings.uploaded = &cert
+	}
+	return settings, nil
+}
+
+// config returns the TLS configuration for HTTPS connections to a test
+// hostname.  Connections which offer only acme-tls/1 receive the TLS-ALPN-01
+// challenge certificate, regardless of mode.
+func (settings *testTLSSettings) config(testID testID, subdomain string) *tls.Config {
+	config := &tls.Config{
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			if isTLSALPNChallengeHello(hello) {
+				return getTLSALPNChallengeCert(hello, testID, subdomain)
+			}
+			return settings.getCertificate(hello.ServerName)
+		},
+		NextProtos: []string{"h2", "http/1.1", acmeTLSALPNProtocol},
+		MinVersion: tls.VersionTLS10,
+	}
+	switch settings.mode {
+	case tlsCertTLS10Only:
+		config.MaxVersion = tls.VersionTLS10
+	case tlsCertTLS13Only:
+		config.MinVersion = tls.VersionTLS13
+	}
+	return config
+}
+
+func (settings *testTLSSettings) getCertificate(serverName string) (*tls.Certificate, error) {
+	if settings.uploaded != nil {
+		return settings.uploaded, nil
+	}
+
+	key, err := getSelfSignedKey()
+	if err != nil {
+		return nil, err
+	}
+	template := newCertTemplate(serverName)
+	var chain [][]byte
+	switch settings.mode {
+	case tlsCertExpired:
+		template.NotBefore = time.Now().Add(-30 * 24 * time.Hour)
+		template.NotAfter = time.Now().Add(-24 * time.Hour)
+	case tlsCertNotYetValid:
+		template.NotBefore = time.Now().Add(24 * time.Hour)
+		template.NotAfter = time.Now().Add(30 * 24 * time.Hour)
+	case tlsCertWrongName:
+		template.DNSNames = []string{wrongCertName}
+		template.Subject = pkix.Name{CommonName: wrongCertName}
+	case tlsCertECDSA:
+		if key, err = ecdsaKey(); err != nil {
+			return nil, fmt.Errorf("error generating ECDSA key: %w", err)
+		}
+		template.KeyUsage = x509.KeyUsageDigitalSignature
+	}
+	issuer, issuerKey := template, key
+	if settings.mode == tlsCertLocalRoot {
+		root, err := localRootCA()
+		if err != nil {
+			return nil, fmt.Errorf("error generating local root CA: %w", err)
+		}
+		issuer, issuerKey = root.Leaf, root.PrivateKey.(crypto.Signer)
+		chain = root.Certificate
+	}
+	certBytes, err := x509.CreateCertificate(rand.Reader, template, issuer, key.Public(), issuerKey)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Certificate{Certificate: append([][]byte{certBytes}, chain...), PrivateKey: key}, nil
+}
+
+// validateUploadedCert checks that an uploaded PEM certificate chain and
+// private key match
+func validateUploadedCert(certPEM, keyPEM string) error {
+	if len(certPEM) > maxUploadedCertSize || len(keyPEM) > maxUploadedCertSize {
+		return fmt.Errorf("certificate and key must not be longer than %d bytes", maxUploadedCertSize)
+	}
+	cert, err := tls.X509KeyPair([]byte(certPEM), []byte(keyPEM))
+	if err != nil {
+		return err
+	}
+	if cert.Leaf.IsCA {
+		return fmt.Errorf("the first certificate in the chain must be the leaf certificate, not a CA certificate")
+	}
+	return nil
+}
+
+// describeUploadedCert summarizes the leaf of an uploaded certificate chain
+func describeUploadedCert(certPEM string) string {
+	block, _ := pem.Decode([]byte(certPEM))
+	if block == nil {
+		return "no certificate"
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return "error parsing certificate: " + err.Error()
+	}
+	return fmt.Sprintf("%s, issued by %s, valid %s to %s", strings.Join(cert.DNSNames, ", "), cert.Issuer.CommonName, cert.NotBefore.UTC().Format(time.DateTime), cert.NotAfter.UTC().Format(time.DateTime))
+}
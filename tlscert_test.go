@@ -0,0 +1,207 @@
+// Copyright (C) 2026 Opsmate, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a
+// copy of this software and associated documentation files (the "Software"),
+// to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense,
+// and/or sell copies of the Software, and to permit persons to whom the
+// Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included
+// in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+// THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+// OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+// ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+// OTHER DEALINGS IN THE SOFTWARE.
+//
+// Except as contained in this notice, the name(s) of the above copyright
+// holders shall not be used in advertising or otherwise to promote the
+// sale, use or other dealings in this Software without prior written
+// authorization.
+
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGetCertificate(t *testing.T) {
+	const serverName = "www.0123456789abcdef0123456789abcdef.test.example.com"
+	now := time.Now()
+
+	tests := []struct {
+		mode  string
+		check func(t *testing.T, leaf *x509.Certificate, chain []*x509.Certificate)
+	}{
+		{mode: tlsCertSelfSigned, check: func(t *testing.T, leaf *x509.Certificate, chain []*x509.Certificate) {
+			if _, ok := leaf.PublicKey.(*rsa.PublicKey); !ok {
+				t.Errorf("got %T public key, want RSA", leaf.PublicKey)
+			}
+			if err := leaf.CheckSignature(leaf.SignatureAlgorithm, leaf.RawTBSCertificate, leaf.Signature); err != nil {
+				t.Errorf("certificate is not self-signed: %v", err)
+			}
+		}},
+		{mode: tlsCertExpired, check: func(t *testing.T, leaf *x509.Certificate, chain []*x509.Certificate) {
+			if !leaf.NotAfter.Before(now) {
+				t.Errorf("certificate is valid until %v", leaf.NotAfter)
+			}
+		}},
+		{mode: tlsCertNotYetValid, check: func(t *testing.T, leaf *x509.Certificate, chain []*x509.Certificate) {
+			if !leaf.NotBefore.After(now) {
+				t.Errorf("certificate is valid from %v", leaf.NotBefore)
+			}
+		}},
+		{mode: tlsCertWrongName, check: func(t *testing.T, leaf *x509.Certificate, chain []*x509.Certificate) {
+			if err := leaf.VerifyHostname(serverName); err == nil {
+				t.Errorf("certificate is valid for %s", serverName)
+			}
+			if !slices.Equal(leaf.DNSNames, []string{wrongCertName}) {
+				t.Errorf("got DNS names %v, want %s", leaf.DNSNames, wrongCertName)
+			}
+		}},
+		{mode: tlsCertECDSA, check: func(t *testing.T, leaf *x509.Certificate, chain []*x509.Certificate) {
+			if key, ok := leaf.PublicKey.(*ecdsa.PublicKey); !ok || key.Curve != elliptic.P256() {
+				t.Errorf("got %T public key, want ECDSA P-256", leaf.PublicKey)
+			}
+		}},
+		{mode: tlsCertLocalRoot, check: func(t *testing.T, leaf *x509.Certificate, chain []*x509.Certificate) {
+			if len(chain) != 1 || !chain[0].IsCA {
+				t.Fatalf("got chain of %d certificates, want the root", len(chain))
+			}
+			roots := x509.NewCertPool()
+			roots.AddCert(chain[0])
+			if _, err := leaf.Verify(x509.VerifyOptions{DNSName: serverName, Roots: roots}); err != nil {
+				t.Errorf("certificate does not chain to the local root: %v", err)
+			}
+			if _, err := leaf.Verify(x509.VerifyOptions{DNSName: serverName}); err == nil {
+				t.Errorf("certificate chains to a system root")
+			}
+		}},
+		{mode: tlsCertTLS10Only},
+		{mode: tlsCertTLS13Only},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			settings := &testTLSSettings{running: true, mode: tt.mode}
+			cert, err := settings.getCertificate(serverName)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var certs []*x509.Certificate
+			for _, der := range cert.Certificate {
+				c, err := x509.ParseCertificate(der)
+				if err != nil {
+					t.Fatal(err)
+				}
+				certs = append(certs, c)
+			}
+			leaf := certs[0]
+			if !leaf.PublicKey.(interface{ Equal(crypto.PublicKey) bool }).Equal(cert.PrivateKey.(crypto.Signer).Public()) {
+				t.Fatalf("private key does not match certificate")
+			}
+			if tt.mode != tlsCertWrongName {
+				if err := leaf.VerifyHostname(serverName); err != nil {
+					t.Errorf("certificate is not valid for %s: %v", serverName, err)
+				}
+			}
+			if tt.mode != tlsCertExpired && tt.mode != tlsCertNotYetValid && (now.Before(leaf.NotBefore) || now.After(leaf.NotAfter)) {
+				t.Errorf("certificate is valid from %v to %v", leaf.NotBefore, leaf.NotAfter)
+			}
+			if tt.mode != tlsCertLocalRoot && len(certs) != 1 {
+				t.Errorf("got chain of %d certificates, want 1", len(certs))
+			}
+			if tt.check != nil {
+				tt.check(t, leaf, certs[1:])
+			}
+		})
+	}
+}
+
+// makeTestCertPEM returns a PEM certificate and PKCS#8 key for a new ECDSA
+// key, issued by parent or self-signed if parent is nil
+func makeTestCertPEM(t *testing.T, template *x509.Certificate, parent *x509.Certificate, parentKey crypto.Signer) (string, string, *x509.Certificate, crypto.Signer) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, key.Public(), parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+	keyPEM := string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}))
+	return certPEM, keyPEM, cert, key
+}
+
+func TestValidateUploadedCert(t *testing.T) {
+	caTemplate := &x509.Certificate{
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		NotAfter:              time.Now().Add(time.Hour),
+		NotBefore:             time.Now().Add(-time.Hour),
+		SerialNumber:          generateRandomSerialNumber(),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+	}
+	caPEM, caKeyPEM, ca, caKey := makeTestCertPEM(t, caTemplate, nil, nil)
+	leafPEM, leafKeyPEM, _, _ := makeTestCertPEM(t, newCertTemplate("www.example.com"), ca, caKey)
+	_, otherKeyPEM, _, _ := makeTestCertPEM(t, newCertTemplate("www.example.com"), nil, nil)
+
+	tests := []struct {
+		name    string
+		certPEM string
+		keyPEM  string
+		wantErr bool
+	}{
+		{name: "leaf", certPEM: leafPEM, keyPEM: leafKeyPEM},
+		{name: "chain", certPEM: leafPEM + caPEM, keyPEM: leafKeyPEM},
+		{name: "mismatched key", certPEM: leafPEM, keyPEM: otherKeyPEM, wantErr: true},
+		{name: "CA key for leaf", certPEM: leafPEM + caPEM, keyPEM: caKeyPEM, wantErr: true},
+		{name: "non-leaf first with leaf key", certPEM: caPEM + leafPEM, keyPEM: leafKeyPEM, wantErr: true},
+		{name: "non-leaf first with its key", certPEM: caPEM + leafPEM, keyPEM: caKeyPEM, wantErr: true},
+		{name: "garbage certificate", certPEM: "-----BEGIN CERTIFICATE-----\nZ2FyYmFnZQ==\n-----END CERTIFICATE-----\n", keyPEM: leafKeyPEM, wantErr: true},
+		{name: "not PEM", certPEM: "garbage", keyPEM: "garbage", wantErr: true},
+		{name: "key only", certPEM: leafKeyPEM, keyPEM: leafKeyPEM, wantErr: true},
+		{name: "empty", wantErr: true},
+		{name: "too long", certPEM: leafPEM + strings.Repeat("\n", maxUploadedCertSize), keyPEM: leafKeyPEM, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateUploadedCert(tt.certPEM, tt.keyPEM)
+			if tt.wantErr && err == nil {
+				t.Fatalf("expected error")
+			} else if !tt.wantErr && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,107 @@
+// Copyright (C) 2026 Opsmate, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a
+// copy of this software and associated documentation files (the "Software"),
+// to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense,
+// and/or sell copies of the Software, and to permit persons to whom the
+// Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included
+// in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+// THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+// OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+// ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+// OTHER DEALINGS IN THE SOFTWARE.
+//
+// Except as contained in this notice, the name(s) of the above copyright
+// holders shall not be used in advertising or otherwise to promote the
+// sale, use or other dealings in this Software without prior written
+// authorization.
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// maxZoneFileRecords limits the number of records which can be imported at once
+const maxZoneFileRecords = 200
+
+// scopeCommentPrefix introduces the scope of a record in a zone file comment
+const scopeCommentPrefix = "; scope:"
+
+// parseZoneFile parses BIND master file text containing records for the test
+// zone.  Relative names are relative to the test hostname.  A record may be
+// followed by a comment such as "; scope: 192.0.2.0/24 AS64496" to restrict
+// it to the given source networks.
+func parseZoneFile(testID testID, text string) ([]dnsZoneRecord, error) {
+	apex := makeHostname(testID, "") + "."
+	zp := dns.NewZoneParser(strings.NewReader(text), apex, "")
+	zp.SetDefaultTTL(defaultRecordTTL)
+
+	var records []dnsZoneRecord
+	for rr, ok := zp.Next(); ok; rr, ok = zp.Next() {
+		if len(records) == maxZoneFileRecords {
+			return nil, fmt.Errorf("zone file contains more than %d records", maxZoneFileRecords)
+		}
+		name := strings.ToLower(rr.Header().Name)
+		if !dns.IsSubDomain(apex, name) {
+			return nil, fmt.Errorf("%s is not within the test zone", rr.Header().Name)
+		}
+		if rr.Header().Ttl > math.MaxInt32 {
+			return nil, fmt.Errorf("%s: TTL is too large", rr.Header().Name)
+		}
+		if rr.Header().Class != dns.ClassINET {
+			return nil, fmt.Errorf("%s: only records of class IN are supported", rr.Header().Name)
+		}
+		subdomain := testSubdomain(apex, name)
+		if err := validatePostedRR(testID, subdomain, rr); err != nil {
+			return nil, fmt.Errorf("%s: %w", rr.Header().Name, err)
+		}
+		var scope *recordScope
+		if comment, ok := strings.CutPrefix(zp.Comment(), scopeCommentPrefix); ok {
+			var err error
+			if scope, err = parseRecordScope(comment); err != nil {
+				return nil, fmt.Errorf("%s: %w", rr.Header().Name, err)
+			}
+		}
+		records = append(records, dnsZoneRecord{subdomain: subdomain, rr: rr, scope: scope})
+	}
+	if err := zp.Err(); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// formatZoneFile returns BIND master file text containing the given records,
+// which can be imported again with parseZoneFile
+func formatZoneFile(testID testID, records []dnsRecord) (string, error) {
+	apex := makeHostname(testID, "") + "."
+	var text strings.Builder
+	fmt.Fprintf(&text, "$ORIGIN %s\n", apex)
+	for _, record := range records {
+		owner := "@"
+		if record.Subdomain != "" {
+			owner = record.Subdomain
+		}
+		rr, err := makeRecordRR(apex, record.Type, record.TTL, record.DataJSON)
+		if err != nil {
+			return "", fmt.Errorf("dns_record row %d contains bad data: %w", record.DNSRecordID, err)
+		}
+		fmt.Fprintf(&text, "%s\t%d\tIN\t%s\t%s", owner, rr.Header().Ttl, record.TypeString(), rdataString(rr))
+		if record.Scope != nil {
+			fmt.Fprintf(&text, " %s %s", scopeCommentPrefix, *record.Scope)
+		}
+		text.WriteString("\n")
+	}
+	return text.String(), nil
+}
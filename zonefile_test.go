@@ -0,0 +1,138 @@
+// Copyright (C) 2026 Opsmate, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a
+// copy of this software and associated documentation files (the "Software"),
+// to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense,
+// and/or sell copies of the Software, and to permit persons to whom the
+// Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included
+// in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+// THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+// OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+// ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+// OTHER DEALINGS IN THE SOFTWARE.
+//
+// Except as contained in this notice, the name(s) of the above copyright
+// holders shall not be used in advertising or otherwise to promote the
+// sale, use or other dealings in this Software without prior written
+// authorization.
+
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestZoneFileRoundTrip(t *testing.T) {
+	setTestServerGlobals(t)
+	testID := generateTestID()
+	apex := makeHostname(testID, "") + "."
+
+	zone := `www IN AAAA 2001:db8::1 ; scope: 192.0.2.0/24 AS64496
+www 300 IN A 192.0.2.1
+@ IN CAA 0 issue "ca.example; accounturi=https://ca.example/acct/1"
+_acme-challenge IN TXT "token with spaces" "second string"
+*.wild IN MX 10 mail.example.net.
+_443._tcp.www IN TLSA 3 1 1 0000000000000000000000000000000000000000000000000000000000000000
+svc IN HTTPS 1 . alpn="h2,h3" port=8443
+alias IN CNAME target.` + apex + `
+`
+	parsed, err := parseZoneFile(testID, zone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parsed) != 8 {
+		t.Fatalf("got %d records, want 8", len(parsed))
+	}
+	if parsed[0].subdomain != "www" || parsed[0].rr.Header().Ttl != defaultRecordTTL {
+		t.Errorf("first record has subdomain %q and TTL %d", parsed[0].subdomain, parsed[0].rr.Header().Ttl)
+	}
+	if parsed[1].rr.Header().Ttl != 300 {
+		t.Errorf("record with explicit TTL has TTL %d", parsed[1].rr.Header().Ttl)
+	}
+	if got := parsed[0].scope.String(); got != "192.0.2.0/24 AS64496" {
+		t.Errorf("scope comment parsed as %q", got)
+	}
+	if parsed[1].scope != nil || parsed[2].scope != nil {
+		t.Errorf("scope of one record applied to another")
+	}
+	if parsed[2].subdomain != "" || parsed[4].subdomain != "*.wild" {
+		t.Errorf("got subdomains %q and %q", parsed[2].subdomain, parsed[4].subdomain)
+	}
+
+	rows := make([]dnsRecord, len(parsed))
+	for i, record := range parsed {
+		data, err := makeRecordData(record.rr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dataJSON, err := json.Marshal(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows[i] = dnsRecord{DNSRecordID: i + 1, Subdomain: record.subdomain, Type: record.rr.Header().Rrtype, TTL: record.rr.Header().Ttl, DataJSON: string(dataJSON)}
+		if record.scope != nil {
+			scope := record.scope.String()
+			rows[i].Scope = &scope
+		}
+	}
+	formatted, err := formatZoneFile(testID, rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reparsed, err := parseZoneFile(testID, formatted)
+	if err != nil {
+		t.Fatalf("formatted zone file does not parse: %v\n%s", err, formatted)
+	}
+	if len(reparsed) != len(parsed) {
+		t.Fatalf("got %d records after round trip, want %d\n%s", len(reparsed), len(parsed), formatted)
+	}
+	for i := range parsed {
+		if got, want := reparsed[i].subdomain, parsed[i].subdomain; got != want {
+			t.Errorf("record %d: got subdomain %q, want %q", i, got, want)
+		}
+		if got, want := reparsed[i].rr.String(), parsed[i].rr.String(); got != want {
+			t.Errorf("record %d: got %s, want %s", i, got, want)
+		}
+		if got, want := reparsed[i].scope.String(), parsed[i].scope.String(); got != want {
+			t.Errorf("record %d: got scope %q, want %q", i, got, want)
+		}
+	}
+}
+
+func TestParseZoneFileRejects(t *testing.T) {
+	setTestServerGlobals(t)
+	testID := generateTestID()
+
+	tests := []struct {
+		name string
+		zone string
+	}{
+		{name: "owner outside zone", zone: "www.example.net. IN A 192.0.2.1"},
+		{name: "owner is parent zone", zone: "test.example.com. IN TXT \"x\""},
+		{name: "bad scope", zone: "www IN A 192.0.2.1 ; scope: not-a-network"},
+		{name: "bad ASN in scope", zone: "www IN A 192.0.2.1 ; scope: AS99999999999"},
+		{name: "too many scope entries", zone: "www IN A 192.0.2.1 ; scope:" + strings.Repeat(" AS1", maxScopeEntries+1)},
+		{name: "TTL too large", zone: "www 2147483648 IN A 192.0.2.1"},
+		{name: "class CH", zone: "www CH TXT \"x\""},
+		{name: "CNAME at apex", zone: "@ IN CNAME _x.acm-validations.aws."},
+		{name: "generated type", zone: "www IN NSEC www A"},
+		{name: "syntax error", zone: "www IN A not-an-address"},
+		{name: "too many records", zone: strings.Repeat("www IN TXT \"x\"\n", maxZoneFileRecords+1)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if records, err := parseZoneFile(testID, tt.zone); err == nil {
+				t.Fatalf("expected error, got %d records", len(records))
+			}
+		})
+	}
+}